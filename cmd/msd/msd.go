@@ -1,25 +1,31 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
-	"os"
 
 	"github.com/kpotier/selfdiff/pkg/cfg"
 )
 
 func main() {
-	if len(os.Args) != 2 {
+	lenient := flag.Bool("lenient", false, "skip the malformed configurations of the trajectory with a warning")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
 		log.Fatal("The path of the configuration file must be specified in the arguments")
 	}
 
-	log.Printf("Reading configuration file `%s`\n", os.Args[1])
-	c, err := cfg.New(os.Args[1])
+	log.Printf("Reading configuration file `%s`\n", flag.Arg(0))
+	c, err := cfg.New(flag.Arg(0))
 	if err != nil {
 		log.Fatal(fmt.Errorf("newInput: %w", err))
 	}
+	if *lenient {
+		c.Lenient = true
+	}
 
-	if c.PBC {
+	if c.PBC && c.NoPBC {
 		log.Println("Converting the PBC trajectory into a non PBC one")
 		err := c.Conv()
 		if err != nil {
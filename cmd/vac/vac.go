@@ -1,23 +1,29 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
-	"os"
 
 	"github.com/kpotier/selfdiff/pkg/cfg"
 )
 
 func main() {
-	if len(os.Args) != 2 {
+	lenient := flag.Bool("lenient", false, "skip the malformed configurations of the trajectory with a warning")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
 		log.Fatal("The path of the configuration file must be specified in the arguments")
 	}
 
-	log.Printf("Reading configuration file `%s`\n", os.Args[1])
-	c, err := cfg.New(os.Args[1])
+	log.Printf("Reading configuration file `%s`\n", flag.Arg(0))
+	c, err := cfg.New(flag.Arg(0))
 	if err != nil {
 		log.Fatal(fmt.Errorf("newInput: %w", err))
 	}
+	if *lenient {
+		c.Lenient = true
+	}
 
 	log.Println("Calculating the velocity autocorrelation function")
 	err = c.VAC()
@@ -3,14 +3,26 @@ package cfg
 import (
 	"bufio"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
+	"github.com/kpotier/selfdiff/pkg/hdf5"
 	"github.com/kpotier/selfdiff/pkg/msd"
-	lammpstrjMSD "github.com/kpotier/selfdiff/pkg/msd/lammpstrj"
+	"github.com/kpotier/selfdiff/pkg/quad"
+	"github.com/kpotier/selfdiff/pkg/topo"
+	"github.com/kpotier/selfdiff/pkg/topo/lammpsdata"
+	"github.com/kpotier/selfdiff/pkg/traj"
+	"github.com/kpotier/selfdiff/pkg/traj/dcd"
+	"github.com/kpotier/selfdiff/pkg/traj/extxyz"
+	"github.com/kpotier/selfdiff/pkg/traj/gromacs"
+	"github.com/kpotier/selfdiff/pkg/traj/h5md"
+	"github.com/kpotier/selfdiff/pkg/traj/lammpstrj"
+	"github.com/kpotier/selfdiff/pkg/traj/netcdf"
+	"github.com/kpotier/selfdiff/pkg/traj/xdatcar"
 	"github.com/kpotier/selfdiff/pkg/vac"
-	lammpstrjVAC "github.com/kpotier/selfdiff/pkg/vac/lammpstrj"
 
 	"gopkg.in/yaml.v3"
 )
@@ -25,12 +37,34 @@ var (
 	MVAC Method = "vac"
 )
 
+// Algo is the algorithm used to perform the calculation
+type Algo string
+
+// Here are the accepted algorithms. ADirect loops over every pair of
+// configurations (O(N^2)). AFFT uses the fast Fourier transform (O(N log N)).
+var (
+	ADirect Algo = "direct"
+	AFFT    Algo = "fft"
+)
+
 // Type is the type of the trajectory
 type Type string
 
-// Here are the accepted types. Lammpstrj is a Lammps Trajectory file.
+// Here are the accepted types. Lammpstrj is a Lammps Trajectory file (text,
+// compressed with gzip or binary). Extxyz is an extended XYZ file (e.g: ASE,
+// CP2K or i-PI). XTC (positions) and TRR (positions and velocities) are
+// GROMACS trajectories. DCD is a CHARMM, NAMD, OpenMM or Lammps trajectory.
+// NetCDF is an AMBER NetCDF trajectory. H5MD is a H5MD (HDF5) trajectory.
+// XDATCAR is a VASP trajectory.
 var (
 	TLammpstrj Type = "lammpstrj"
+	TExtxyz    Type = "extxyz"
+	TXTC       Type = "xtc"
+	TTRR       Type = "trr"
+	TDCD       Type = "dcd"
+	TNetCDF    Type = "netcdf"
+	TH5MD      Type = "h5md"
+	TXDATCAR   Type = "xdatcar"
 )
 
 // Cfg is a structure containing the parameters specified in the configuration
@@ -41,15 +75,26 @@ type Cfg struct {
 	// Traj is the file containing the configurations
 	Traj string `yaml:"traj"`
 
-	// Type is the type of trajectory (e.g: lammpstrj)
+	// Type is the type of trajectory (e.g: lammpstrj, extxyz, xtc, trr, dcd,
+	// netcdf, h5md or xdatcar)
 	Type Type `yaml:"type"`
 
 	// Method is the method of calculation
 	Method Method `yaml:"method"`
 
+	// Algo is the algorithm of calculation (direct or fft). Default is direct
+	Algo Algo `yaml:"algo"`
+
 	// PBC specifies if the periodic boundary conditions are used in the above file
+	// (e.g: positions x y z instead of xu yu zu). It is required by the types
+	// which don't specify whether the positions are wrapped
 	PBC bool `yaml:"pbc"`
 
+	// NoPBC specifies if the unwrapped trajectory is written into a new file
+	// (<traj>_nopbc.lammpstrj) on which the calculation is then performed.
+	// Otherwise, the trajectory is unwrapped on the fly
+	NoPBC bool `yaml:"nopbc"`
+
 	// Start is the first configuration that will be read. It must start be
 	// greater or equal to 0
 	Start int `yaml:"start"`
@@ -68,14 +113,89 @@ type Cfg struct {
 	// At is the number of atoms in one molecule
 	At int `yaml:"at"`
 
-	// Dist is the largest distance between two atoms in one molecule
+	// Dist is the largest distance between two atoms in one molecule, along
+	// each vector of the cell for a triclinic box
 	Dist [3]float64 `yaml:"msdDist"`
 
+	// Unwrap is the scheme (jump or tor) used to unwrap the trajectory when
+	// pbc is true. Jump is the historical one, but tor must be preferred if
+	// the box fluctuates (NpT). The image flags ix iy iz are used instead if
+	// the trajectory gives them, the scheme being only compared to them.
+	// Default is jump
+	Unwrap msd.Scheme `yaml:"unwrap"`
+
 	// Masses are the masses of each atoms in one molecule
 	Masses []float64 `yaml:"masses"`
 
-	// Dt is the timestep in whatever unit you want
+	// Species are the kinds of molecules in one configuration, in the order
+	// they appear in the trajectory. It replaces Mol, At and Masses which
+	// describe a single species. If all of them are omitted, the species are
+	// inferred from the first configuration: its atoms (with the columns mol,
+	// type and mass of the trajectory or of Data) are grouped into molecules
+	// by their mol column (each atom is a molecule without it). Otherwise,
+	// they are checked against this configuration
+	Species []topo.Species `yaml:"species"`
+
+	// TypeMasses are the masses of each atom type, used with the column type
+	// if the masses are given neither by the trajectory nor by Data
+	TypeMasses map[int]float64 `yaml:"typeMasses"`
+
+	// Data is a Lammps data file giving the topology of the atoms: their
+	// molecules and masses (species), their bonds used to make the molecules
+	// whole instead of Dist, and the columns id, mol, type and mass if the
+	// trajectory doesn't have them
+	Data string `yaml:"data"`
+
+	// Select selects the atoms that are read according to their id, type or
+	// mol column (e.g: "type 1 2" or "mol 1-500"). Every atom is read if it is
+	// empty
+	Select string `yaml:"select"`
+
+	// ByMol specifies if the atoms are grouped into molecules by their mol
+	// column instead of their order in the trajectory
+	ByMol bool `yaml:"byMol"`
+
+	// Dt is the timestep in whatever unit you want. It can be omitted if the
+	// trajectory gives the time of each configuration (h5md)
 	Dt float64 `yaml:"dt"`
+
+	// Dims are the dimensions (x, y and/or z) included in the calculation.
+	// For instance, [x, y] gives the lateral diffusion. Default is [x, y, z]
+	Dims []string `yaml:"dims"`
+
+	// FitStart and FitEnd are the first and last lags (in number of
+	// configurations) of the MSD curve used to fit the diffusion coefficient.
+	// If both are equal to 0, the diffusive regime is detected automatically.
+	// Otherwise, the window must contain at least 3 lags
+	FitStart int `yaml:"fitStart"`
+	FitEnd   int `yaml:"fitEnd"`
+
+	// Quad is the quadrature rule (trapezoid or simpson) used to perform the
+	// Green-Kubo integral. Default is trapezoid
+	Quad quad.Rule `yaml:"quad"`
+
+	// Cut is the upper limit (in number of configurations) of the Green-Kubo
+	// integral. If it is equal to 0, the whole function is integrated
+	Cut int `yaml:"cut"`
+
+	// Blocks is the number of blocks used to estimate the statistical errors.
+	// The configurations from Start to End are split into Blocks contiguous
+	// blocks. If it is lower or equal to 1, no block averaging is performed
+	Blocks int `yaml:"blocks"`
+
+	// H5MD specifies if the results are also written into a H5MD file
+	// (observables group) next to the trajectory
+	H5MD bool `yaml:"h5md"`
+
+	// Lenient specifies if the malformed configurations of the trajectory
+	// (e.g: truncated or with values which aren't numbers) are skipped with a
+	// warning instead of stopping the calculation. The indexes of the
+	// configurations (Start and End) don't count them
+	Lenient bool `yaml:"lenient"`
+
+	skipped map[string]bool      // Errors of the configurations skipped
+	unwraps []*msd.Unwrap        // Readers unwrapping the positions (see warn)
+	top     *lammpsdata.Topology // Topology read from Data
 }
 
 // New opens and decodes the specified configuration file. The file must be
@@ -123,18 +243,90 @@ func (c *Cfg) Check() error {
 		return fmt.Errorf("Mem cannot be lower than 0 or greater than End-Start")
 	}
 
-	if c.Mol <= 0 || c.At <= 0 {
-		return fmt.Errorf("Mol or Att cannot be lower or equal to 0")
+	switch {
+	case len(c.Species) == 0 && c.auto():
+		// The species are inferred from the trajectory (see Cfg.topology)
+	case len(c.Species) == 0:
+		if c.Mol <= 0 || c.At <= 0 {
+			return fmt.Errorf("Mol or Att cannot be lower or equal to 0")
+		}
+
+		if len(c.Masses) != c.At {
+			return fmt.Errorf("the length of the masses slice is not equal to At")
+		}
+	default:
+		if c.Mol != 0 || c.At != 0 || len(c.Masses) != 0 {
+			return fmt.Errorf("Mol, At and Masses cannot be used with Species")
+		}
+
+		err := topo.Check(c.Species)
+		if err != nil {
+			return err
+		}
+	}
+
+	for typ, m := range c.TypeMasses {
+		if m <= 0 {
+			return fmt.Errorf("the mass of the type %d must be greater than 0", typ)
+		}
+	}
+
+	if c.Select != "" {
+		_, err := topo.ParseSelection(c.Select)
+		if err != nil {
+			return fmt.Errorf("Select: %w", err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, d := range c.Dims {
+		if d != "x" && d != "y" && d != "z" {
+			return fmt.Errorf("unsupported dimension %s", d)
+		}
+		if seen[d] {
+			return fmt.Errorf("dimension %s specified twice", d)
+		}
+		seen[d] = true
 	}
 
-	if len(c.Masses) != c.At {
-		return fmt.Errorf("the length of the masses slice is not equal to At")
+	if c.Algo != "" && c.Algo != ADirect && c.Algo != AFFT {
+		return fmt.Errorf("unsupported algorithm")
 	}
 
-	if c.Dt == 0 {
+	if c.Dt < 0 || (c.Dt == 0 && c.Type != TH5MD) {
 		return fmt.Errorf("Dt cannot be lower or equal to 0")
 	}
 
+	// The fit needs at least 3 points. The VAC isn't fitted
+	if c.Method == MMSD && (c.FitStart != 0 || c.FitEnd != 0) && (c.FitStart <= 0 || c.FitEnd-c.FitStart < 2 || c.FitEnd >= (c.End-c.Start)) {
+		return fmt.Errorf("FitStart and FitEnd must verify 0 < FitStart, FitStart+2 <= FitEnd and FitEnd < End-Start")
+	}
+
+	if c.Unwrap != "" && c.Unwrap != msd.SJump && c.Unwrap != msd.STOR {
+		return fmt.Errorf("unsupported unwrapping scheme")
+	}
+
+	if c.Quad != "" && c.Quad != quad.Trapezoid && c.Quad != quad.Simpson {
+		return fmt.Errorf("unsupported quadrature rule")
+	}
+
+	n := c.End - c.Start // Number of configurations in one block
+	if c.Blocks > 1 {
+		n /= c.Blocks
+	}
+
+	if n < 2 {
+		return fmt.Errorf("a block must contain at least 2 configurations")
+	}
+
+	if c.Method == MMSD && c.FitEnd >= n {
+		return fmt.Errorf("FitEnd must be lower than the number of configurations in one block")
+	}
+
+	if c.Cut < 0 || c.Cut >= n {
+		return fmt.Errorf("Cut cannot be lower than 0 or greater or equal to the number of configurations in one block")
+	}
+
 	return nil
 }
 
@@ -149,48 +341,76 @@ func (c *Cfg) Conv() error {
 		return fmt.Errorf("msd method is required")
 	}
 
-	ext := filepath.Ext(c.Traj)
-	filename := strings.TrimSuffix(c.Traj, ext)
+	// The converted trajectory doesn't keep the time and the species
+	err := c.fromTraj()
+	if err != nil {
+		return err
+	}
+
+	// The unwrapped trajectory is always an uncompressed Lammps Trajectory
+	// file
+	filename := strings.TrimSuffix(c.Traj, ".gz")
+	ext := filepath.Ext(filename)
+	filename = strings.TrimSuffix(filename, ext)
+	if c.Type != TLammpstrj || ext == ".bin" {
+		ext = ".lammpstrj"
+	}
 	newTraj := fmt.Sprint(filename, "_nopbc", ext)
 
-	conv := &msd.Conv{Traj: c.Traj, Out: newTraj, At: c.At, Mol: c.Mol, AtTot: (c.At * c.Mol), Dist: c.Dist}
+	r, err := c.reader()
+	if err != nil {
+		return err
+	}
 
-	var err error
-	switch c.Type {
-	case TLammpstrj:
-		err = lammpstrjMSD.Conv(conv)
-	default:
-		err = fmt.Errorf("unsupported type")
+	u, err := c.unwrap(r)
+	if err != nil {
+		r.Close()
+		return err
+	}
+	defer u.Close()
+
+	w, err := lammpstrj.Create(newTraj)
+	if err != nil {
+		return err
 	}
 
+	conv := &msd.Conv{Unwrap: u, Writer: w}
+	err = conv.Perform()
+	if err != nil {
+		w.Close()
+		return err
+	}
+	c.warn()
+
+	err = w.Close()
 	if err != nil {
 		return err
 	}
 
 	c.PBC = false
 	c.Traj = newTraj
+	c.Type = TLammpstrj
 
 	return nil
 }
 
 // MSD calculates the mean squared displacement.
 func (c *Cfg) MSD() (err error) {
-	if c.PBC {
-		return fmt.Errorf("pbc set to true")
-	}
-
 	if c.Method != MMSD {
 		return fmt.Errorf("msd method is required")
 	}
 
-	out := fmt.Sprint(c.Traj, "_msd.out")
-	msd := &msd.MSD{Traj: c.Traj, Out: out, Start: c.Start, End: c.End, Mem: c.Mem, At: c.At, Mol: c.Mol, Masses: c.Masses, Dt: c.Dt}
+	err = c.fromTraj()
+	if err != nil {
+		return
+	}
 
-	switch c.Type {
-	case TLammpstrj:
-		msd.Method = lammpstrjMSD.New(msd)
-	default:
-		err = fmt.Errorf("unsupported type")
+	if c.Blocks > 1 {
+		return c.msdBlocks()
+	}
+
+	msd, err := c.newMSD(c.Start, c.End)
+	if err != nil {
 		return
 	}
 
@@ -198,9 +418,99 @@ func (c *Cfg) MSD() (err error) {
 	if err != nil {
 		return
 	}
+	c.warn()
 
 	err = msd.Write()
-	return
+	if err != nil {
+		return
+	}
+
+	// The curve is kept even if no diffusive regime is found (e.g: short
+	// trajectory)
+	err = msd.Einstein()
+	if err != nil {
+		log.Printf("Warning: Einstein: %v (no diffusion coefficient)\n", err)
+	} else {
+		for _, cur := range msd.Curves {
+			log.Printf("%sFit from %g to %g: R2 = %g\n", prefix(cur.Species), float64(cur.Fit.Start)*c.Dt, float64(cur.Fit.End)*c.Dt, cur.Fit.R2)
+			log.Printf("%sDiffusion coefficient: %g +/- %g\n", prefix(cur.Species), cur.Fit.D, cur.Fit.DErr)
+		}
+
+		err = msd.WriteFit()
+		if err != nil {
+			return
+		}
+	}
+
+	if !c.H5MD {
+		return nil
+	}
+
+	return c.writeH5MD("_msd.h5", msd)
+}
+
+// msdBlocks calculates the mean squared displacement using block averaging.
+func (c *Cfg) msdBlocks() error {
+	b := &msd.Blocks{Out: fmt.Sprint(c.Traj, "_msd.out"), FitOut: fmt.Sprint(c.Traj, "_msd_fit.out"), Dt: c.Dt, FitStart: c.FitStart, FitEnd: c.FitEnd}
+	for _, r := range c.blocks() {
+		m, err := c.newMSD(r[0], r[1])
+		if err != nil {
+			return err
+		}
+		b.Blocks = append(b.Blocks, m)
+	}
+
+	err := b.Perform()
+	if err != nil {
+		return err
+	}
+	c.warn()
+
+	err = b.Write()
+	if err != nil {
+		return err
+	}
+
+	// The curve is kept even if no diffusive regime is found (see MSD)
+	err = b.Einstein()
+	if err != nil {
+		log.Printf("Warning: Einstein: %v (no diffusion coefficient)\n", err)
+	} else {
+		for _, cur := range b.Curves {
+			log.Printf("%sFit from %g to %g\n", prefix(cur.Species), float64(cur.Start)*c.Dt, float64(cur.End)*c.Dt)
+			log.Printf("%sDiffusion coefficient: %g +/- %g (%d blocks)\n", prefix(cur.Species), cur.D, cur.DErr, len(b.Blocks))
+		}
+
+		err = b.WriteFit()
+		if err != nil {
+			return err
+		}
+	}
+
+	if !c.H5MD {
+		return nil
+	}
+
+	return c.writeH5MD("_msd.h5", b)
+}
+
+// newMSD returns an instance of msd.MSD for the configurations from start to
+// end.
+func (c *Cfg) newMSD(start, end int) (*msd.MSD, error) {
+	out := fmt.Sprint(c.Traj, "_msd.out")
+	fitOut := fmt.Sprint(c.Traj, "_msd_fit.out")
+	mem := c.Mem
+	if mem > end-start {
+		mem = end - start
+	}
+
+	method, err := c.method(traj.Pos, start, end, mem)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &msd.MSD{Method: method, Traj: c.Traj, Out: out, FitOut: fitOut, Start: start, End: end, Mem: mem, Species: c.species(), Dt: c.Dt, Dims: c.dims(), FFT: c.Algo == AFFT, FitStart: c.FitStart, FitEnd: c.FitEnd}
+	return m, nil
 }
 
 // VAC calculates the velocity autocorrelation function.
@@ -209,14 +519,17 @@ func (c *Cfg) VAC() (err error) {
 		return fmt.Errorf("vac method is required")
 	}
 
-	out := fmt.Sprint(c.Traj, "_vac.out")
-	vac := &vac.VAC{Traj: c.Traj, Out: out, Start: c.Start, End: c.End, Mem: c.Mem, At: c.At, Mol: c.Mol, Masses: c.Masses, Dt: c.Dt}
+	err = c.fromTraj()
+	if err != nil {
+		return
+	}
 
-	switch c.Type {
-	case TLammpstrj:
-		vac.Method = lammpstrjVAC.New(vac)
-	default:
-		err = fmt.Errorf("unsupported type")
+	if c.Blocks > 1 {
+		return c.vacBlocks()
+	}
+
+	vac, err := c.newVAC(c.Start, c.End)
+	if err != nil {
 		return
 	}
 
@@ -225,6 +538,384 @@ func (c *Cfg) VAC() (err error) {
 		return
 	}
 
+	vac.GreenKubo()
+	for _, cur := range vac.Curves {
+		log.Printf("%sDiffusion coefficient: %g\n", prefix(cur.Species), cur.D)
+	}
+
 	err = vac.Write()
-	return
+	if err != nil || !c.H5MD {
+		return
+	}
+
+	return c.writeH5MD("_vac.h5", vac)
+}
+
+// vacBlocks calculates the velocity autocorrelation function using block
+// averaging.
+func (c *Cfg) vacBlocks() error {
+	b := &vac.Blocks{Out: fmt.Sprint(c.Traj, "_vac.out"), Dt: c.Dt}
+	for _, r := range c.blocks() {
+		m, err := c.newVAC(r[0], r[1])
+		if err != nil {
+			return err
+		}
+		b.Blocks = append(b.Blocks, m)
+	}
+
+	err := b.Perform()
+	if err != nil {
+		return err
+	}
+
+	for _, cur := range b.Curves {
+		log.Printf("%sDiffusion coefficient: %g +/- %g (%d blocks)\n", prefix(cur.Species), cur.D, cur.DErr, len(b.Blocks))
+	}
+
+	err = b.Write()
+	if err != nil || !c.H5MD {
+		return err
+	}
+
+	return c.writeH5MD("_vac.h5", b)
+}
+
+// newVAC returns an instance of vac.VAC for the configurations from start to
+// end.
+func (c *Cfg) newVAC(start, end int) (*vac.VAC, error) {
+	out := fmt.Sprint(c.Traj, "_vac.out")
+	mem := c.Mem
+	if mem > end-start {
+		mem = end - start
+	}
+
+	method, err := c.method(traj.Vel, start, end, mem)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &vac.VAC{Method: method, Traj: c.Traj, Out: out, Start: start, End: end, Mem: mem, Species: c.species(), Dt: c.Dt, Dims: c.dims(), FFT: c.Algo == AFFT, Cut: c.Cut, Quad: c.Quad}
+	return m, nil
+}
+
+// method returns the method reading the columns cols of the configurations
+// from start to end, the last mem ones being put into memory.
+func (c *Cfg) method(cols [3]string, start, end, mem int) (traj.Method, error) {
+	r, err := c.reader()
+	if err != nil {
+		return nil, err
+	}
+
+	// The positions are unwrapped on the fly
+	if c.PBC && cols == traj.Pos {
+		r, err = c.unwrap(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &traj.COM{Reader: r, Cols: cols, Start: start, Tot: end - start, Mem: mem, Species: c.species(), Select: c.selection(), ByMol: c.ByMol}, nil
+}
+
+// unwrap returns the reader unwrapping the positions of r. Every atom is
+// unwrapped, the selection being applied afterwards by traj.COM. The state of
+// each reader is kept for warn.
+func (c *Cfg) unwrap(r traj.Reader) (*msd.Unwrap, error) {
+	sp := c.species()
+	u := &msd.Unwrap{Reader: r, Species: sp, Dist: c.Dist, Scheme: c.Unwrap, Select: c.selection(), ByMol: c.ByMol}
+
+	// The species only describe the selected atoms
+	if c.Select == "" {
+		u.AtTot = topo.AtTot(sp)
+	}
+
+	if c.Data != "" {
+		t, err := c.data()
+		if err == nil {
+			u.Bonds, err = t.Pairs()
+		}
+		if err == nil && u.AtTot != 0 && len(t.Atoms) != u.AtTot {
+			err = fmt.Errorf("the number of atoms of the species doesn't match %s", c.Data)
+		}
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		u.AtTot = len(t.Atoms)
+	}
+
+	c.unwraps = append(c.unwraps, u)
+	return u, nil
+}
+
+// warn warns if the unwrapping scheme disagrees with the image flags of the
+// trajectory.
+func (c *Cfg) warn() {
+	var n int
+	for _, u := range c.unwraps {
+		if u.Disagree > n {
+			n = u.Disagree
+		}
+	}
+	c.unwraps = nil
+
+	if n > 0 {
+		log.Printf("Warning: the unwrapping scheme disagrees with the image flags for %d atoms (the image flags are used)\n", n)
+	}
+}
+
+// reader opens the trajectory according to its type. The malformed frames are
+// skipped if Lenient is true (see traj.Lenient) and the columns of Data are
+// added to the frames (see lammpsdata.Reader).
+func (c *Cfg) reader() (traj.Reader, error) {
+	r, err := c.open()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Lenient {
+		r = &traj.Lenient{Reader: r, Warn: c.skip}
+	}
+	if c.Data == "" {
+		return r, nil
+	}
+
+	t, err := c.data()
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return &lammpsdata.Reader{Reader: r, Topology: t}, nil
+}
+
+// skip warns that a frame of the trajectory is skipped because of err. The
+// warning is given once for each frame.
+func (c *Cfg) skip(err error) {
+	if c.skipped == nil {
+		c.skipped = make(map[string]bool)
+	}
+
+	if !c.skipped[err.Error()] {
+		c.skipped[err.Error()] = true
+		log.Printf("Warning: %v (configuration skipped)\n", err)
+	}
+}
+
+// open opens the trajectory according to its type.
+func (c *Cfg) open() (traj.Reader, error) {
+	switch c.Type {
+	case TLammpstrj:
+		return lammpstrj.Open(c.Traj)
+	case TExtxyz:
+		return extxyz.Open(c.Traj)
+	case TXTC:
+		return gromacs.OpenXTC(c.Traj)
+	case TTRR:
+		return gromacs.OpenTRR(c.Traj)
+	case TDCD:
+		return dcd.Open(c.Traj)
+	case TNetCDF:
+		return netcdf.Open(c.Traj)
+	case TH5MD:
+		return h5md.Open(c.Traj)
+	case TXDATCAR:
+		return xdatcar.Open(c.Traj)
+	}
+	return nil, fmt.Errorf("unsupported type")
+}
+
+// fromTraj sets the parameters which are given by the trajectory if they are
+// omitted: Species (see topology) and Dt (see timestep).
+func (c *Cfg) fromTraj() error {
+	err := c.topology()
+	if err != nil {
+		return err
+	}
+	return c.timestep()
+}
+
+// auto returns true if the species must be inferred from the trajectory, that
+// is if neither Species nor Mol, At and Masses are specified.
+func (c *Cfg) auto() bool {
+	return len(c.Species) == 0 && c.Mol == 0 && c.At == 0 && len(c.Masses) == 0
+}
+
+// data reads Data once.
+func (c *Cfg) data() (*lammpsdata.Topology, error) {
+	if c.top != nil {
+		return c.top, nil
+	}
+
+	var err error
+	c.top, err = lammpsdata.Read(c.Data)
+	return c.top, err
+}
+
+// topology sets Species, if it must be inferred (see auto), or checks it
+// against the atoms of the configuration Start (see lammpsdata.FromFrame). The
+// species of a xdatcar trajectory without Data and TypeMasses are the ones of
+// its header (see xdatcar).
+func (c *Cfg) topology() error {
+	if c.auto() && c.Type == TXDATCAR && c.Data == "" && len(c.TypeMasses) == 0 {
+		return c.xdatcar()
+	}
+
+	r, err := c.reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, err := r.Frame(c.Start)
+	if err != nil {
+		return err
+	}
+	t := lammpsdata.FromFrame(f, c.TypeMasses)
+
+	if !c.auto() {
+		err = t.Check(c.species(), c.ByMol, c.selection())
+		if err != nil {
+			return fmt.Errorf("the species don't match the trajectory: %w", err)
+		}
+		return nil
+	}
+
+	c.Species, err = t.Species(c.ByMol, c.selection())
+	if err != nil {
+		return fmt.Errorf("unable to infer the species: %w", err)
+	}
+	for _, sp := range c.Species {
+		log.Printf("%sSpecies from the trajectory: %d molecules of %d atoms\n", prefix(sp), sp.Mol, sp.At)
+	}
+
+	return topo.Check(c.Species)
+}
+
+// xdatcar sets Species from the species of the header of the trajectory. Each
+// atom is a molecule, so its mass doesn't matter.
+func (c *Cfg) xdatcar() error {
+	r, err := xdatcar.Open(c.Traj)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	names, counts := r.Species()
+	seen := make(map[string]bool)
+	for k, n := range counts {
+		if n == 0 {
+			continue
+		}
+
+		name := strconv.Itoa(k + 1)
+		if len(names) != 0 {
+			name = names[k]
+		}
+		if seen[name] {
+			name = fmt.Sprint(name, "_", k+1)
+		}
+		seen[name] = true
+
+		c.Species = append(c.Species, topo.Species{Name: name, Mol: n, At: 1, Masses: []float64{1}})
+		log.Printf("Species %s from the trajectory: %d atoms\n", name, n)
+	}
+
+	return topo.Check(c.Species)
+}
+
+// timestep sets Dt, if it is equal to 0, from the time of the configurations
+// Start and Start+1 of the trajectory.
+func (c *Cfg) timestep() error {
+	if c.Dt != 0 {
+		return nil
+	}
+
+	r, err := c.reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var t [2]float64
+	for i := range t {
+		f, err := r.Frame(c.Start + i)
+		if err != nil {
+			return err
+		}
+		t[i] = f.Time
+	}
+
+	c.Dt = t[1] - t[0]
+	if c.Dt <= 0 {
+		return fmt.Errorf("unable to get Dt from the time of the configurations")
+	}
+
+	log.Printf("Dt from the trajectory: %g\n", c.Dt)
+	return nil
+}
+
+// writeH5MD writes the results of res into the H5MD file named after the
+// trajectory and suffix (see h5md.Create).
+func (c *Cfg) writeH5MD(suffix string, res interface{ WriteH5MD(*hdf5.Writer) }) error {
+	w, err := h5md.Create(fmt.Sprint(c.Traj, suffix))
+	if err != nil {
+		return err
+	}
+
+	res.WriteH5MD(w)
+	return w.Close()
+}
+
+// species returns Species or, if it is empty, the single species described by
+// Mol, At and Masses.
+func (c *Cfg) species() []topo.Species {
+	if len(c.Species) != 0 {
+		return c.Species
+	}
+	return []topo.Species{{Mol: c.Mol, At: c.At, Masses: c.Masses}}
+}
+
+// selection returns the parsed Select. It returns nil if Select is empty.
+func (c *Cfg) selection() *topo.Selection {
+	if c.Select == "" {
+		return nil
+	}
+
+	sel, _ := topo.ParseSelection(c.Select) // Checked by Check
+	return sel
+}
+
+// prefix returns the prefix of the log messages related to the species sp.
+func prefix(sp topo.Species) string {
+	if sp.Name == "" {
+		return ""
+	}
+	return fmt.Sprint(sp.Name, ": ")
+}
+
+// dims converts the dimensions x, y and z into 0, 1 and 2. If no dimension is
+// specified, all of them are returned.
+func (c *Cfg) dims() []int {
+	if len(c.Dims) == 0 {
+		return []int{0, 1, 2}
+	}
+
+	dims := make([]int, len(c.Dims))
+	for i, d := range c.Dims {
+		dims[i] = int(d[0] - 'x')
+	}
+	return dims
+}
+
+// blocks splits the configurations from Start to End into Blocks contiguous
+// blocks of the same length. The remaining configurations are discarded.
+func (c *Cfg) blocks() [][2]int {
+	n := (c.End - c.Start) / c.Blocks
+
+	var r [][2]int
+	for b := 0; b < c.Blocks; b++ {
+		start := c.Start + b*n
+		r = append(r, [2]int{start, start + n})
+	}
+	return r
 }
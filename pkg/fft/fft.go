@@ -0,0 +1,104 @@
+package fft
+
+import (
+	"math"
+	"math/bits"
+)
+
+// FFT performs an in-place radix-2 fast Fourier transform of x. The length of
+// x must be a power of 2. If inv is true, the inverse transform is performed
+// (the result is divided by len(x)).
+func FFT(x []complex128, inv bool) {
+	n := len(x)
+	if n <= 1 {
+		return
+	}
+
+	// Bit reversal permutation
+	shift := uint(64 - bits.TrailingZeros(uint(n)))
+	for i := 0; i < n; i++ {
+		j := int(bits.Reverse64(uint64(i)) >> shift)
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	sign := -1.
+	if inv {
+		sign = 1.
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		half := size >> 1
+		theta := sign * 2. * math.Pi / float64(size)
+		wm := complex(math.Cos(theta), math.Sin(theta))
+
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < half; k++ {
+				t := w * x[start+k+half]
+				x[start+k+half] = x[start+k] - t
+				x[start+k] += t
+				w *= wm
+			}
+		}
+	}
+
+	if inv {
+		for i := range x {
+			x[i] /= complex(float64(n), 0)
+		}
+	}
+}
+
+// Pow2 returns the smallest power of 2 greater or equal to n.
+func Pow2(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// Transform returns the fast Fourier transform of the real signal x
+// zero-padded to a power of 2 greater or equal to 2*len(x). The padding avoids
+// the circular correlation when the transform is used by CorrSym.
+func Transform(x []float64) []complex128 {
+	buf := make([]complex128, Pow2(2*len(x)))
+	for i, v := range x {
+		buf[i] = complex(v, 0)
+	}
+
+	FFT(buf, false)
+	return buf
+}
+
+// CorrSym returns the unnormalized symmetrized cross-correlation of two real
+// signals of length n from their transforms a and b (see Transform). The m-th
+// element of the returned slice is the sum of x[k]*y[k+m] + y[k]*x[k+m] for k
+// from 0 to n-m-1.
+func CorrSym(a, b []complex128, n int) []float64 {
+	buf := make([]complex128, len(a))
+	for i := range buf {
+		buf[i] = complex(2*(real(a[i])*real(b[i])+imag(a[i])*imag(b[i])), 0)
+	}
+	FFT(buf, true)
+
+	res := make([]float64, n)
+	for i := range res {
+		res[i] = real(buf[i])
+	}
+	return res
+}
+
+// Autocorr returns the unnormalized autocorrelation of x. The m-th element of
+// the returned slice is the sum of x[k]*x[k+m] for k from 0 to len(x)-m-1.
+// The signal is zero-padded to avoid the circular correlation.
+func Autocorr(x []float64) []float64 {
+	t := Transform(x)
+	res := CorrSym(t, t, len(x))
+	for i := range res {
+		res[i] /= 2.
+	}
+	return res
+}
@@ -0,0 +1,102 @@
+package fit
+
+import (
+	"fmt"
+	"math"
+)
+
+// Line is the result of a linear regression y = Slope*x + Intercept. SlopeErr
+// is the standard error of the slope and R2 the coefficient of determination.
+type Line struct {
+	Slope     float64
+	Intercept float64
+	SlopeErr  float64
+	R2        float64
+}
+
+// Linear performs an ordinary least squares fit of y against x. At least 3
+// points are required to estimate the standard error of the slope.
+func Linear(x, y []float64) (l Line, err error) {
+	n := len(x)
+	if n != len(y) {
+		err = fmt.Errorf("x and y don't have the same length")
+		return
+	}
+	if n < 3 {
+		err = fmt.Errorf("at least 3 points are required")
+		return
+	}
+
+	var mx, my float64
+	for i := 0; i < n; i++ {
+		mx += x[i]
+		my += y[i]
+	}
+	mx /= float64(n)
+	my /= float64(n)
+
+	var sxx, sxy, syy float64
+	for i := 0; i < n; i++ {
+		dx, dy := x[i]-mx, y[i]-my
+		sxx += dx * dx
+		sxy += dx * dy
+		syy += dy * dy
+	}
+	if sxx == 0 {
+		err = fmt.Errorf("x values are all identical")
+		return
+	}
+
+	l.Slope = sxy / sxx
+	l.Intercept = my - l.Slope*mx
+
+	var ssr float64 // Sum of squared residuals
+	for i := 0; i < n; i++ {
+		r := y[i] - l.Slope*x[i] - l.Intercept
+		ssr += r * r
+	}
+
+	l.SlopeErr = math.Sqrt(ssr / float64(n-2) / sxx)
+	l.R2 = 1
+	if syy != 0 {
+		l.R2 = 1 - ssr/syy
+	}
+
+	return
+}
+
+// Diffusive detects the linear (diffusive) regime of a mean squared
+// displacement curve y(x). The local exponent d log(y) / d log(x) is computed
+// for the first half of the curve (the second half is poorly averaged) and
+// the longest range [start, end) in which it is within tol of 1 is returned.
+// An error is returned if no range of at least 10 points is found.
+func Diffusive(x, y []float64, tol float64) (start, end int, err error) {
+	n := len(x) / 2
+	w := len(x) / 100 // Half width of the smoothing window
+	if w < 1 {
+		w = 1
+	}
+
+	cur := w // Beginning of the current range
+	for i := w; i < n; i++ {
+		ok := x[i-w] > 0 && y[i-w] > 0 && y[i+w] > 0
+		if ok {
+			beta := math.Log(y[i+w]/y[i-w]) / math.Log(x[i+w]/x[i-w])
+			ok = math.Abs(beta-1) <= tol
+		}
+
+		if !ok {
+			cur = i + 1
+			continue
+		}
+
+		if i+1-cur > end-start {
+			start, end = cur, i+1
+		}
+	}
+
+	if end-start < 10 {
+		err = fmt.Errorf("unable to detect the diffusive regime")
+	}
+	return
+}
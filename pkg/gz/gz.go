@@ -0,0 +1,355 @@
+// Package gz reads gzip files with random access. The compressed stream is
+// decoded once and the state of the decoder (position in the compressed file
+// and last 32 KiB of decompressed data) is recorded regularly at the start of
+// a deflate block. Seeking backward restarts the decompression from the last
+// recorded state before the requested position instead of from the start of
+// the file.
+package gz
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Spacing is the minimum number of decompressed bytes between two recorded
+// states. Each state costs 32 KiB of memory.
+var Spacing int64 = 4 << 20
+
+// window is the size of the history of deflate.
+const window = 32 << 10
+
+// ErrFormat is returned when the file is not a valid gzip file.
+var ErrFormat = errors.New("gz: invalid format")
+
+// point is a recorded state of the decoder at the start of a deflate block.
+type point struct {
+	in   int64 // Position in the compressed file (in bits)
+	out  int64 // Position in the decompressed data
+	hist []byte
+}
+
+// Reader is a gzip file which can be read and seeked like a regular file. It
+// supports files made of several gzip members. The checksums are not
+// verified.
+type Reader struct {
+	f  *os.File
+	in *bufio.Reader
+
+	inPos int64  // Number of bytes read from the file
+	bits  uint64 // Bit buffer
+	nb    uint   // Number of bits in the bit buffer
+
+	hist   []byte // Decompressed data. hist[r:] has not been read yet
+	r      int
+	outPos int64 // Position in the decompressed data of the end of hist
+
+	member bool // A member is being decompressed
+	eof    bool
+
+	points []point
+	done   int64 // Position in the decompressed data up to which the states are recorded
+
+	lit, dist huffman // Tables of the current block
+}
+
+// Open opens the gzip file name.
+func Open(name string) (*Reader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	z := &Reader{f: f, in: bufio.NewReaderSize(f, 64<<10)}
+	err = z.header()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return z, nil
+}
+
+// Read reads the decompressed data.
+func (z *Reader) Read(p []byte) (int, error) {
+	for z.r == len(z.hist) {
+		if z.eof {
+			return 0, io.EOF
+		}
+
+		err := z.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, z.hist[z.r:])
+	z.r += n
+	return n, nil
+}
+
+// Seek sets the position in the decompressed data. Only io.SeekStart and
+// io.SeekCurrent are supported.
+func (z *Reader) Seek(off int64, whence int) (int64, error) {
+	cur := z.outPos - int64(len(z.hist)-z.r)
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		off += cur
+	default:
+		return cur, fmt.Errorf("gz: unsupported whence")
+	}
+
+	if off < 0 {
+		return cur, fmt.Errorf("gz: negative position")
+	}
+
+	// The position is in the buffer
+	start := z.outPos - int64(len(z.hist))
+	if off >= start && off <= z.outPos {
+		z.r = int(off - start)
+		return off, nil
+	}
+
+	if off < start {
+		err := z.restore(off)
+		if err != nil {
+			return cur, err
+		}
+	}
+
+	for off > z.outPos {
+		z.r = len(z.hist)
+		if z.eof {
+			return z.outPos, nil
+		}
+
+		err := z.next()
+		if err != nil {
+			return cur, err
+		}
+	}
+
+	z.r = len(z.hist) - int(z.outPos-off)
+	return off, nil
+}
+
+// Close closes the file.
+func (z *Reader) Close() error {
+	return z.f.Close()
+}
+
+// restore restores the last recorded state before the position off.
+func (z *Reader) restore(off int64) error {
+	var p point
+	for _, q := range z.points {
+		if q.out > off {
+			break
+		}
+		p = q
+	}
+
+	// The first state is the start of the file
+	if p.hist == nil && p.out == 0 {
+		p.in = 0
+	}
+
+	_, err := z.f.Seek(p.in/8, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	z.in.Reset(z.f)
+	z.inPos = p.in / 8
+	z.bits, z.nb = 0, 0
+	z.eof = false
+
+	z.hist = append(z.hist[:0], p.hist...)
+	z.r = len(z.hist)
+	z.outPos = p.out
+
+	if p.out == 0 && p.hist == nil {
+		z.member = false
+		return z.header()
+	}
+
+	z.member = true
+	if p.in%8 != 0 {
+		_, err = z.need(uint(p.in % 8))
+		if err != nil {
+			return err
+		}
+		z.drop(uint(p.in % 8))
+	}
+	return nil
+}
+
+// next decompresses the next deflate block (or the next gzip member).
+func (z *Reader) next() error {
+	// The history is kept but the data already read are discarded
+	if z.r > 2*window {
+		n := copy(z.hist, z.hist[z.r-window:])
+		z.hist = z.hist[:n]
+		z.r = window
+	}
+
+	if !z.member {
+		return z.header()
+	}
+
+	// State at the start of the block
+	if z.outPos >= z.done {
+		if len(z.points) == 0 || z.outPos-z.points[len(z.points)-1].out >= Spacing {
+			h := z.hist
+			if len(h) > window {
+				h = h[len(h)-window:]
+			}
+			z.points = append(z.points, point{z.inPos*8 - int64(z.nb), z.outPos, append([]byte{}, h...)})
+		}
+		z.done = z.outPos
+	}
+
+	final, err := z.block()
+	if err != nil {
+		return err
+	}
+
+	if final {
+		// Trailer (CRC32 and size)
+		z.drop(z.nb % 8)
+		for i := 0; i < 8; i++ {
+			_, err = z.byte()
+			if err != nil {
+				return err
+			}
+		}
+		z.member = false
+	}
+
+	return nil
+}
+
+// header reads the header of a gzip member. The end of the file is reached if
+// there are no more members.
+func (z *Reader) header() error {
+	b, err := z.byte()
+	if err == io.EOF {
+		if z.outPos == 0 {
+			return ErrFormat
+		}
+		z.eof = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var h [10]byte
+	h[0] = b
+	for i := 1; i < 10; i++ {
+		h[i], err = z.byte()
+		if err != nil {
+			return ErrFormat
+		}
+	}
+
+	if h[0] != 0x1f || h[1] != 0x8b || h[2] != 8 {
+		return ErrFormat
+	}
+	flg := h[3]
+
+	// Extra field
+	if flg&4 != 0 {
+		lo, _ := z.byte()
+		hi, err := z.byte()
+		if err != nil {
+			return ErrFormat
+		}
+		for i := 0; i < int(lo)|int(hi)<<8; i++ {
+			_, err = z.byte()
+			if err != nil {
+				return ErrFormat
+			}
+		}
+	}
+
+	// Name and comment
+	for _, f := range []byte{8, 16} {
+		if flg&f == 0 {
+			continue
+		}
+		for {
+			c, err := z.byte()
+			if err != nil {
+				return ErrFormat
+			}
+			if c == 0 {
+				break
+			}
+		}
+	}
+
+	// CRC16
+	if flg&2 != 0 {
+		z.byte()
+		_, err = z.byte()
+		if err != nil {
+			return ErrFormat
+		}
+	}
+
+	z.member = true
+	return nil
+}
+
+// byte reads one byte. The bit buffer must be aligned on a byte.
+func (z *Reader) byte() (byte, error) {
+	if z.nb >= 8 {
+		b := byte(z.bits)
+		z.drop(8)
+		return b, nil
+	}
+
+	b, err := z.in.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	z.inPos++
+	return b, nil
+}
+
+// need makes sure that the bit buffer contains at least n bits (n <= 32) and
+// returns it.
+func (z *Reader) need(n uint) (uint64, error) {
+	for z.nb < n {
+		b, err := z.in.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		z.inPos++
+		z.bits |= uint64(b) << z.nb
+		z.nb += 8
+	}
+	return z.bits, nil
+}
+
+// drop discards n bits of the bit buffer.
+func (z *Reader) drop(n uint) {
+	z.bits >>= n
+	z.nb -= n
+}
+
+// read reads n bits (n <= 32).
+func (z *Reader) read(n uint) (int, error) {
+	b, err := z.need(n)
+	if err != nil {
+		return 0, err
+	}
+	z.drop(n)
+	return int(b & (1<<n - 1)), nil
+}
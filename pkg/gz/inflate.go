@@ -0,0 +1,339 @@
+package gz
+
+import "io"
+
+// Base values and extra bits of the length and distance codes (RFC 1951).
+var (
+	lenBase  = [...]int{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	lenExtra = [...]uint{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+
+	distBase  = [...]int{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	distExtra = [...]uint{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+
+	// Order of the code lengths of the code length alphabet
+	clOrder = [...]int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+)
+
+// fastBits is the number of bits decoded at once by the Huffman tables.
+const fastBits = 9
+
+// huffman is a canonical Huffman code. The codes up to fastBits bits are
+// decoded with a table, the longer ones bit by bit.
+type huffman struct {
+	count  [16]int // Number of codes of each length
+	symbol []int   // Symbols ordered by code
+	fast   [1 << fastBits]uint16
+}
+
+// build builds the code from the length of the code of each symbol.
+func (h *huffman) build(lengths []int) error {
+	h.count = [16]int{}
+	for _, l := range lengths {
+		h.count[l]++
+	}
+	h.count[0] = 0
+
+	// The code must not be over-subscribed
+	left := 1
+	for l := 1; l < 16; l++ {
+		left <<= 1
+		left -= h.count[l]
+		if left < 0 {
+			return ErrFormat
+		}
+	}
+
+	var offs [16]int
+	for l := 1; l < 15; l++ {
+		offs[l+1] = offs[l] + h.count[l]
+	}
+
+	h.symbol = make([]int, len(lengths))
+	for s, l := range lengths {
+		if l != 0 {
+			h.symbol[offs[l]] = s
+			offs[l]++
+		}
+	}
+
+	h.fast = [1 << fastBits]uint16{}
+	code, i := 0, 0
+	for l := 1; l <= fastBits; l++ {
+		for c := 0; c < h.count[l]; c++ {
+			// The codes are stored from their most significant bit
+			rev := 0
+			for b := 0; b < l; b++ {
+				rev |= (code >> uint(b) & 1) << uint(l-1-b)
+			}
+			for j := rev; j < len(h.fast); j += 1 << uint(l) {
+				h.fast[j] = uint16(h.symbol[i]<<4 | l)
+			}
+			code++
+			i++
+		}
+		code <<= 1
+	}
+
+	return nil
+}
+
+// decode decodes one symbol with the code h.
+func (z *Reader) decode(h *huffman) (int, error) {
+	// Enough bits are available except at the end of a truncated file
+	b, err := z.need(fastBits)
+	if err == nil {
+		if e := h.fast[b&(1<<fastBits-1)]; e != 0 {
+			z.drop(uint(e & 15))
+			return int(e >> 4), nil
+		}
+	}
+
+	code, first, index := 0, 0, 0
+	for l := uint(1); l < 16; l++ {
+		b, err := z.need(l)
+		if err != nil {
+			return 0, err
+		}
+		code |= int(b>>(l-1)) & 1
+
+		count := h.count[l]
+		if code-count < first {
+			z.drop(l)
+			return h.symbol[index+code-first], nil
+		}
+		index += count
+		first += count
+		first <<= 1
+		code <<= 1
+	}
+
+	return 0, ErrFormat
+}
+
+// fixed are the tables of the fixed Huffman codes.
+var fixed struct {
+	lit, dist huffman
+	ok        bool
+}
+
+// block decompresses one deflate block into hist. It returns true if it is
+// the last block of the member.
+func (z *Reader) block() (bool, error) {
+	h, err := z.read(3)
+	if err != nil {
+		return false, err
+	}
+	final := h&1 == 1
+
+	switch h >> 1 {
+	case 0:
+		err = z.stored()
+	case 1:
+		if !fixed.ok {
+			var l [288]int
+			for s := range l {
+				switch {
+				case s < 144:
+					l[s] = 8
+				case s < 256:
+					l[s] = 9
+				case s < 280:
+					l[s] = 7
+				default:
+					l[s] = 8
+				}
+			}
+			fixed.lit.build(l[:])
+
+			var d [30]int
+			for s := range d {
+				d[s] = 5
+			}
+			fixed.dist.build(d[:])
+			fixed.ok = true
+		}
+		err = z.codes(&fixed.lit, &fixed.dist)
+	case 2:
+		err = z.dynamic()
+		if err == nil {
+			err = z.codes(&z.lit, &z.dist)
+		}
+	default:
+		err = ErrFormat
+	}
+
+	return final, err
+}
+
+// stored copies a block which isn't compressed.
+func (z *Reader) stored() error {
+	z.drop(z.nb % 8)
+
+	var b [4]byte
+	for i := range b {
+		var err error
+		b[i], err = z.byte()
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+	}
+
+	n := int(b[0]) | int(b[1])<<8
+	if n != ^(int(b[2])|int(b[3])<<8)&0xffff {
+		return ErrFormat
+	}
+
+	for i := 0; i < n; i++ {
+		c, err := z.byte()
+		if err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		z.hist = append(z.hist, c)
+	}
+	z.outPos += int64(n)
+
+	return nil
+}
+
+// dynamic reads the Huffman codes of a dynamic block.
+func (z *Reader) dynamic() error {
+	nlen, err := z.read(5)
+	if err != nil {
+		return err
+	}
+	ndist, err := z.read(5)
+	if err != nil {
+		return err
+	}
+	ncode, err := z.read(4)
+	if err != nil {
+		return err
+	}
+	nlen += 257
+	ndist++
+	ncode += 4
+
+	if nlen > 286 || ndist > 30 {
+		return ErrFormat
+	}
+
+	var cl [19]int
+	for i := 0; i < ncode; i++ {
+		cl[clOrder[i]], err = z.read(3)
+		if err != nil {
+			return err
+		}
+	}
+
+	var code huffman
+	err = code.build(cl[:])
+	if err != nil {
+		return err
+	}
+
+	lengths := make([]int, nlen+ndist)
+	for i := 0; i < len(lengths); {
+		s, err := z.decode(&code)
+		if err != nil {
+			return err
+		}
+
+		if s < 16 {
+			lengths[i] = s
+			i++
+			continue
+		}
+
+		var l, rep int
+		switch s {
+		case 16:
+			if i == 0 {
+				return ErrFormat
+			}
+			l = lengths[i-1]
+			rep, err = z.read(2)
+			rep += 3
+		case 17:
+			rep, err = z.read(3)
+			rep += 3
+		default:
+			rep, err = z.read(7)
+			rep += 11
+		}
+		if err != nil {
+			return err
+		}
+
+		if i+rep > len(lengths) {
+			return ErrFormat
+		}
+		for ; rep > 0; rep-- {
+			lengths[i] = l
+			i++
+		}
+	}
+
+	if lengths[256] == 0 {
+		return ErrFormat
+	}
+
+	err = z.lit.build(lengths[:nlen])
+	if err != nil {
+		return err
+	}
+	return z.dist.build(lengths[nlen:])
+}
+
+// codes decompresses the literals and the matches of a block until the end of
+// the block.
+func (z *Reader) codes(lit, dist *huffman) error {
+	for {
+		s, err := z.decode(lit)
+		if err != nil {
+			return err
+		}
+
+		if s < 256 {
+			z.hist = append(z.hist, byte(s))
+			z.outPos++
+			continue
+		}
+		if s == 256 {
+			return nil
+		}
+
+		s -= 257
+		if s >= len(lenBase) {
+			return ErrFormat
+		}
+		e, err := z.read(lenExtra[s])
+		if err != nil {
+			return err
+		}
+		n := lenBase[s] + e
+
+		s, err = z.decode(dist)
+		if err != nil {
+			return err
+		}
+		if s >= len(distBase) {
+			return ErrFormat
+		}
+		e, err = z.read(distExtra[s])
+		if err != nil {
+			return err
+		}
+		d := distBase[s] + e
+
+		if d > len(z.hist) {
+			return ErrFormat
+		}
+
+		// The match may overlap the data it produces
+		p := len(z.hist) - d
+		for i := 0; i < n; i++ {
+			z.hist = append(z.hist, z.hist[p+i])
+		}
+		z.outPos += int64(n)
+	}
+}
@@ -0,0 +1,174 @@
+package gz
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fixedGz is a gzip member made of a single fixed Huffman block (written by
+// zlib) and its decompressed data.
+var (
+	fixedGz   = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x02\x03\xf3\x0c\x71\xf5\xb5\x52\x08\xf1\xf4\x75\x0d\x0e\x71\x0d\xe0\x32\xe0\xf2\x44\x17\x00\x00\xf1\xef\x8c\xee\x22\x00\x00\x00")
+	fixedData = []byte("ITEM: TIMESTEP\n0\nITEM: TIMESTEP\n0\n")
+)
+
+// lammpstrj returns n frames of a Lammps dump of random positions.
+func lammpstrj(rng *rand.Rand, n int) []byte {
+	var b bytes.Buffer
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "ITEM: TIMESTEP\n%d\nITEM: NUMBER OF ATOMS\n100\n", 10*i)
+		b.WriteString("ITEM: BOX BOUNDS pp pp pp\n0 20\n0 20\n0 20\nITEM: ATOMS id type xu yu zu\n")
+		for a := 0; a < 100; a++ {
+			fmt.Fprintf(&b, "%d %d %.5f %.5f %.5f\n", a+1, a%3+1, 20*rng.Float64(), 20*rng.Float64(), 20*rng.Float64())
+		}
+	}
+	return b.Bytes()
+}
+
+// member compresses data into a gzip member with the given level. The
+// compressor is flushed every flush bytes if flush isn't 0, which ends the
+// current block with an empty stored block.
+func member(t *testing.T, data []byte, level, flush int) []byte {
+	var b bytes.Buffer
+	w, err := gzip.NewWriterLevel(&b, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Name = "traj.lammpstrj"
+	w.Comment = "selfdiff"
+
+	for len(data) > 0 {
+		n := len(data)
+		if flush > 0 && flush < n {
+			n = flush
+		}
+		_, err = w.Write(data[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if flush > 0 {
+			err = w.Flush()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		data = data[n:]
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestReader(t *testing.T) {
+	defer func(s int64) { Spacing = s }(Spacing)
+	Spacing = 64 << 10
+
+	dir, err := ioutil.TempDir("", "gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 300<<10)
+	rng.Read(random)
+	text := lammpstrj(rng, 200)
+
+	// Stored, dynamic Huffman (with and without matches) and fixed Huffman
+	// blocks in several members
+	var gz, want []byte
+	for _, m := range []struct {
+		data         []byte
+		level, flush int
+	}{
+		{random, gzip.NoCompression, 0},
+		{text, gzip.HuffmanOnly, 0},
+		{text, gzip.DefaultCompression, 100 << 10},
+		{append(text[:len(text)/2:len(text)/2], random[:100<<10]...), gzip.BestSpeed, 0},
+	} {
+		gz = append(gz, member(t, m.data, m.level, m.flush)...)
+		want = append(want, m.data...)
+
+		gz = append(gz, fixedGz...)
+		want = append(want, fixedData...)
+	}
+
+	name := filepath.Join(dir, "traj.lammpstrj.gz")
+	err = ioutil.WriteFile(name, gz, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	got, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("%d bytes decompressed, want %d identical bytes", len(got), len(want))
+	}
+	if len(z.points) < 10 {
+		t.Fatalf("%d recorded states, want at least 10", len(z.points))
+	}
+
+	// Backward and forward, across and between the recorded states
+	cur := int64(len(want))
+	for i := 0; i < 500; i++ {
+		off := rng.Int63n(int64(len(want)))
+		whence := io.SeekStart
+		if i%2 == 1 {
+			whence = io.SeekCurrent
+			off -= cur
+		}
+
+		pos, err := z.Seek(off, whence)
+		if err != nil {
+			t.Fatalf("Seek(%d, %d): %v", off, whence, err)
+		}
+		if whence == io.SeekCurrent {
+			off += cur
+		}
+		if pos != off {
+			t.Fatalf("Seek(%d, %d) = %d, want %d", off, whence, pos, off)
+		}
+
+		n := rng.Intn(100 << 10)
+		if rest := len(want) - int(off); n > rest {
+			n = rest
+		}
+
+		b := make([]byte, n)
+		_, err = io.ReadFull(z, b)
+		if err != nil {
+			t.Fatalf("read %d bytes at %d: %v", n, off, err)
+		}
+		if !bytes.Equal(b, want[off:int(off)+n]) {
+			t.Fatalf("%d bytes read at %d are different", n, off)
+		}
+		cur = off + int64(n)
+	}
+
+	pos, err := z.Seek(int64(len(want))+10, io.SeekStart)
+	if err != nil || pos != int64(len(want)) {
+		t.Fatalf("Seek past the end = %d, %v, want %d", pos, err, len(want))
+	}
+	_, err = z.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Fatalf("Read at the end = %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,727 @@
+package hdf5
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+)
+
+// Classes of the datatypes
+const (
+	classFixed  = 0
+	classFloat  = 1
+	classString = 3
+)
+
+// Classes of the layouts
+const (
+	layoutCompact    = 0
+	layoutContiguous = 1
+	layoutChunked    = 2
+)
+
+// Filters
+const (
+	filterDeflate    = 1
+	filterShuffle    = 2
+	filterFletcher32 = 3
+)
+
+// datatype is a numerical or string datatype.
+type datatype struct {
+	class  int
+	size   int
+	order  binary.ByteOrder
+	signed bool
+}
+
+// filter is a filter of the pipeline of a chunked dataset.
+type filter struct {
+	id     int
+	params []uint32
+}
+
+// chunk is a chunk of a dataset.
+type chunk struct {
+	offset []int // Position of the first element
+	addr   uint64
+	size   int
+	mask   uint32 // Filters which are not applied
+}
+
+// Dataset is a dataset of numerical values. Shape is empty for a scalar.
+type Dataset struct {
+	f     *File
+	Shape []int
+
+	dtype   datatype
+	layout  int
+	addr    uint64
+	compact []byte
+	dims    []int // Dimensions of the chunks
+	filters []filter
+	chunks  []chunk
+
+	// Chunks of the last rows read
+	cache map[int][]byte
+	row   int
+}
+
+// Dataset returns the dataset name.
+func (f *File) Dataset(name string) (*Dataset, error) {
+	o, err := f.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := f.dataset(o)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return d, nil
+}
+
+// dataset decodes the messages of the dataset o.
+func (f *File) dataset(o *object) (*Dataset, error) {
+	space, dt, layout := o.find(msgDataspace), o.find(msgDatatype), o.find(msgLayout)
+	if space == nil || dt == nil || layout == nil {
+		return nil, fmt.Errorf("not a dataset")
+	}
+
+	d := &Dataset{f: f, row: -1}
+
+	var err error
+	d.Shape, err = f.dataspace(space)
+	if err != nil {
+		return nil, err
+	}
+
+	d.dtype, err = decodeType(dt)
+	if err != nil {
+		return nil, err
+	}
+	if d.dtype.class == classString {
+		return nil, fmt.Errorf("not a numerical dataset")
+	}
+
+	err = d.decodeLayout(layout)
+	if err != nil {
+		return nil, err
+	}
+
+	if b := o.find(msgFilters); b != nil {
+		d.filters, err = decodeFilters(b)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// dataspace returns the shape of a dataspace message.
+func (f *File) dataspace(b []byte) ([]int, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("invalid dataspace")
+	}
+
+	d := &decoder{b: b, f: f}
+	version, rank := d.u8(), int(d.u8())
+	d.u8() // Flags
+	switch version {
+	case 1:
+		d.skip(5)
+	case 2:
+		if d.u8() == 2 {
+			return nil, fmt.Errorf("null dataspace")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported version of dataspace")
+	}
+
+	shape := make([]int, rank)
+	for i := range shape {
+		shape[i] = int(d.length())
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("invalid dataspace")
+	}
+
+	return shape, nil
+}
+
+// decodeType decodes a datatype message. Only the fixed-point, floating-point
+// and fixed-length string datatypes are supported.
+func decodeType(b []byte) (datatype, error) {
+	if len(b) < 8 {
+		return datatype{}, fmt.Errorf("invalid datatype")
+	}
+
+	t := datatype{class: int(b[0] & 0x0f), size: int(binary.LittleEndian.Uint32(b[4:]))}
+
+	t.order = binary.LittleEndian
+	if b[1]&1 != 0 {
+		t.order = binary.BigEndian
+	}
+
+	switch t.class {
+	case classFixed:
+		t.signed = b[1]&0x08 != 0
+		if t.size != 1 && t.size != 2 && t.size != 4 && t.size != 8 {
+			return t, fmt.Errorf("unsupported size of integer (%d)", t.size)
+		}
+	case classFloat:
+		if b[1]&0x40 != 0 {
+			return t, fmt.Errorf("VAX floats are not supported")
+		}
+		if t.size != 4 && t.size != 8 {
+			return t, fmt.Errorf("unsupported size of float (%d)", t.size)
+		}
+	case classString:
+	default:
+		return t, fmt.Errorf("unsupported datatype (class %d)", t.class)
+	}
+
+	return t, nil
+}
+
+// decode converts the values of b to float64.
+func (t datatype) decode(b []byte) []float64 {
+	v := make([]float64, len(b)/t.size)
+	for i := range v {
+		e := b[i*t.size:]
+		switch {
+		case t.class == classFloat && t.size == 4:
+			v[i] = float64(math.Float32frombits(t.order.Uint32(e)))
+		case t.class == classFloat:
+			v[i] = math.Float64frombits(t.order.Uint64(e))
+		case t.size == 1 && t.signed:
+			v[i] = float64(int8(e[0]))
+		case t.size == 1:
+			v[i] = float64(e[0])
+		case t.size == 2 && t.signed:
+			v[i] = float64(int16(t.order.Uint16(e)))
+		case t.size == 2:
+			v[i] = float64(t.order.Uint16(e))
+		case t.size == 4 && t.signed:
+			v[i] = float64(int32(t.order.Uint32(e)))
+		case t.size == 4:
+			v[i] = float64(t.order.Uint32(e))
+		case t.signed:
+			v[i] = float64(int64(t.order.Uint64(e)))
+		default:
+			v[i] = float64(t.order.Uint64(e))
+		}
+	}
+	return v
+}
+
+// decodeLayout decodes a data layout message.
+func (d *Dataset) decodeLayout(b []byte) error {
+	dec := &decoder{b: b, f: d.f}
+	version := dec.u8()
+
+	switch version {
+	case 1, 2:
+		rank := int(dec.u8())
+		d.layout = int(dec.u8())
+		dec.skip(5)
+		if d.layout != layoutCompact {
+			d.addr = dec.offset()
+		}
+		dims := make([]int, rank)
+		for i := range dims {
+			dims[i] = int(dec.uint(4))
+		}
+		switch d.layout {
+		case layoutCompact:
+			d.compact = dec.bytes(int(dec.uint(4)))
+		case layoutChunked:
+			d.dims = dims[:rank-1]
+		}
+	case 3, 4:
+		d.layout = int(dec.u8())
+		switch d.layout {
+		case layoutCompact:
+			d.compact = dec.bytes(int(dec.uint(2)))
+		case layoutContiguous:
+			d.addr = dec.offset()
+			dec.length()
+		case layoutChunked:
+			if version == 3 {
+				rank := int(dec.u8())
+				d.addr = dec.offset()
+				for i := 0; i < rank; i++ {
+					d.dims = append(d.dims, int(dec.uint(4)))
+				}
+				d.dims = d.dims[:rank-1]
+				break
+			}
+
+			flags := dec.u8()
+			rank := int(dec.u8())
+			w := int(dec.u8())
+			for i := 0; i < rank; i++ {
+				d.dims = append(d.dims, int(dec.uint(w)))
+			}
+			d.dims = d.dims[:rank-1]
+
+			index := int(dec.u8())
+			switch index {
+			case 1: // Single chunk
+				c := chunk{offset: make([]int, len(d.dims)), size: d.chunkSize()}
+				if flags&0x02 != 0 {
+					c.size = int(dec.length())
+					c.mask = uint32(dec.uint(4))
+				}
+				c.addr = dec.offset()
+				d.chunks = []chunk{c}
+			case 2: // Implicit
+				d.addr = dec.offset()
+			default:
+				return fmt.Errorf("unsupported chunk index (%d)", index)
+			}
+			d.layout = -index // Index of version 4
+		default:
+			return fmt.Errorf("unsupported layout (%d)", d.layout)
+		}
+	default:
+		return fmt.Errorf("unsupported version of layout")
+	}
+
+	if dec.err != nil {
+		return fmt.Errorf("invalid layout")
+	}
+
+	if d.dims != nil && len(d.dims) != len(d.Shape) {
+		return fmt.Errorf("invalid dimensions of the chunks")
+	}
+
+	return nil
+}
+
+// decodeFilters decodes a filter pipeline message.
+func decodeFilters(b []byte) ([]filter, error) {
+	dec := &decoder{b: b}
+	version := dec.u8()
+	n := int(dec.u8())
+	if version == 1 {
+		dec.skip(6)
+	} else if version != 2 {
+		return nil, fmt.Errorf("unsupported version of filter pipeline")
+	}
+
+	filters := make([]filter, n)
+	for i := range filters {
+		f := &filters[i]
+		f.id = int(dec.uint(2))
+
+		name := 0
+		if version == 1 || f.id >= 256 {
+			name = int(dec.uint(2))
+		}
+		dec.uint(2) // Flags
+		nparams := int(dec.uint(2))
+
+		if version == 1 {
+			name = (name + 7) / 8 * 8
+		}
+		dec.skip(name)
+
+		for k := 0; k < nparams; k++ {
+			f.params = append(f.params, uint32(dec.uint(4)))
+		}
+		if version == 1 && nparams%2 == 1 {
+			dec.skip(4)
+		}
+
+		switch f.id {
+		case filterDeflate, filterShuffle, filterFletcher32:
+		default:
+			return nil, fmt.Errorf("unsupported filter (%d)", f.id)
+		}
+	}
+
+	if dec.err != nil {
+		return nil, fmt.Errorf("invalid filter pipeline")
+	}
+
+	return filters, nil
+}
+
+// chunkSize returns the size of a chunk (without filters).
+func (d *Dataset) chunkSize() int {
+	n := d.dtype.size
+	for _, c := range d.dims {
+		n *= c
+	}
+	return n
+}
+
+// Read reads all the values of the dataset.
+func (d *Dataset) Read() ([]float64, error) {
+	if len(d.Shape) == 0 {
+		b, err := d.rows(0, 1)
+		if err != nil {
+			return nil, err
+		}
+		return d.dtype.decode(b), nil
+	}
+	return d.Rows(0, d.Shape[0])
+}
+
+// Rows reads the n rows (along the first dimension) starting from the row
+// start.
+func (d *Dataset) Rows(start, n int) ([]float64, error) {
+	if len(d.Shape) == 0 || start < 0 || n < 0 || start+n > d.Shape[0] {
+		return nil, fmt.Errorf("rows out of range")
+	}
+
+	b, err := d.rows(start, n)
+	if err != nil {
+		return nil, err
+	}
+	return d.dtype.decode(b), nil
+}
+
+// rowSize returns the number of elements of a row.
+func (d *Dataset) rowSize() int {
+	n := 1
+	if len(d.Shape) > 0 {
+		for _, s := range d.Shape[1:] {
+			n *= s
+		}
+	}
+	return n
+}
+
+// rows returns the raw values of n rows starting from the row start.
+func (d *Dataset) rows(start, n int) ([]byte, error) {
+	size := d.rowSize() * d.dtype.size
+	out := make([]byte, n*size)
+
+	switch d.layout {
+	case layoutCompact:
+		if (start+n)*size > len(d.compact) {
+			return nil, fmt.Errorf("invalid compact dataset")
+		}
+		copy(out, d.compact[start*size:])
+	case layoutContiguous:
+		// The dataset may have never been written
+		if d.addr == undef {
+			return out, nil
+		}
+		b, err := d.f.read(d.addr+uint64(start*size), len(out))
+		if err != nil {
+			return nil, err
+		}
+		copy(out, b)
+	default:
+		err := d.chunked(start, n, out)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+// chunked copies the values of the chunked dataset into out.
+func (d *Dataset) chunked(start, n int, out []byte) error {
+	if d.chunks == nil {
+		err := d.index()
+		if err != nil {
+			return err
+		}
+	}
+
+	size := d.dtype.size
+	rank := len(d.Shape)
+
+	// Strides of the output and of a chunk
+	strides := make([]int, rank)
+	cstrides := make([]int, rank)
+	strides[rank-1], cstrides[rank-1] = 1, 1
+	for k := rank - 2; k >= 0; k-- {
+		strides[k] = strides[k+1] * d.Shape[k+1]
+		cstrides[k] = cstrides[k+1] * d.dims[k+1]
+	}
+
+	idx := make([]int, rank)
+	for i, c := range d.chunks {
+		if c.offset[0]+d.dims[0] <= start || c.offset[0] >= start+n {
+			continue
+		}
+
+		b, err := d.chunk(i)
+		if err != nil {
+			return err
+		}
+
+		// Each element of the chunk inside the rows is copied
+		for e := 0; e < len(b)/size; e++ {
+			in := true
+			pos := 0
+			for k := 0; k < rank; k++ {
+				idx[k] = c.offset[k] + e/cstrides[k]%d.dims[k]
+				if idx[k] >= d.Shape[k] {
+					in = false
+					break
+				}
+				pos += idx[k] * strides[k]
+			}
+			if !in || idx[0] < start || idx[0] >= start+n {
+				continue
+			}
+
+			pos -= start * strides[0]
+			copy(out[pos*size:(pos+1)*size], b[e*size:])
+		}
+	}
+
+	return nil
+}
+
+// chunk returns the values of the chunk i. The chunks of the same row of
+// chunks than the last one read are kept in memory.
+func (d *Dataset) chunk(i int) ([]byte, error) {
+	c := d.chunks[i]
+	if c.offset[0] != d.row {
+		d.cache = make(map[int][]byte)
+		d.row = c.offset[0]
+	}
+	if b, ok := d.cache[i]; ok {
+		return b, nil
+	}
+
+	b, err := d.f.read(c.addr, c.size)
+	if err != nil {
+		return nil, err
+	}
+
+	// The filters are undone in the reverse order
+	for k := len(d.filters) - 1; k >= 0; k-- {
+		if c.mask&(1<<uint(k)) != 0 {
+			continue
+		}
+
+		switch f := d.filters[k]; f.id {
+		case filterDeflate:
+			r, err := zlib.NewReader(bytes.NewReader(b))
+			if err != nil {
+				return nil, fmt.Errorf("invalid compressed chunk: %w", err)
+			}
+			b, err = ioutil.ReadAll(r)
+			if err != nil {
+				return nil, fmt.Errorf("invalid compressed chunk: %w", err)
+			}
+		case filterShuffle:
+			n := d.dtype.size
+			if len(f.params) > 0 {
+				n = int(f.params[0])
+			}
+			b = unshuffle(b, n)
+		case filterFletcher32:
+			if len(b) < 4 {
+				return nil, fmt.Errorf("invalid chunk")
+			}
+			b = b[:len(b)-4]
+		}
+	}
+
+	if len(b) != d.chunkSize() {
+		return nil, fmt.Errorf("invalid size of chunk")
+	}
+
+	d.cache[i] = b
+	return b, nil
+}
+
+// unshuffle undoes the shuffle filter: the bytes of the elements of size n
+// were grouped by significance.
+func unshuffle(b []byte, n int) []byte {
+	if n <= 1 {
+		return b
+	}
+
+	out := make([]byte, len(b))
+	m := len(b) / n
+	for k := 0; k < n; k++ {
+		for e := 0; e < m; e++ {
+			out[e*n+k] = b[k*m+e]
+		}
+	}
+	copy(out[m*n:], b[m*n:])
+	return out
+}
+
+// index finds the chunks of the dataset.
+func (d *Dataset) index() error {
+	d.chunks = []chunk{}
+
+	if d.addr == undef {
+		return nil // Never written
+	}
+
+	// The chunks of the implicit index are stored in order
+	if d.layout == -2 {
+		var n []int
+		total := 1
+		for k, s := range d.Shape {
+			n = append(n, (s+d.dims[k]-1)/d.dims[k])
+			total *= n[k]
+		}
+
+		for i := 0; i < total; i++ {
+			c := chunk{offset: make([]int, len(n)), addr: d.addr + uint64(i*d.chunkSize()), size: d.chunkSize()}
+			r := i
+			for k := len(n) - 1; k >= 0; k-- {
+				c.offset[k] = r % n[k] * d.dims[k]
+				r /= n[k]
+			}
+			d.chunks = append(d.chunks, c)
+		}
+		return nil
+	}
+
+	return d.chunkTree(d.addr)
+}
+
+// chunkTree adds the chunks of the version 1 B-tree at the address addr.
+func (d *Dataset) chunkTree(addr uint64) error {
+	f := d.f
+	b, err := f.read(addr, 8+2*f.offsets)
+	if err != nil {
+		return err
+	}
+	if string(b[:4]) != "TREE" || b[4] != 1 {
+		return fmt.Errorf("invalid chunk B-tree")
+	}
+	level := b[5]
+	n := int(binary.LittleEndian.Uint16(b[6:]))
+
+	// Size, filter mask and offsets (one more for the size of an element)
+	rank := len(d.Shape)
+	key := 8 + 8*(rank+1)
+	b, err = f.read(addr+uint64(len(b)), (n+1)*key+n*f.offsets)
+	if err != nil {
+		return err
+	}
+	dec := &decoder{b: b, f: f}
+
+	for i := 0; i < n; i++ {
+		c := chunk{size: int(dec.uint(4)), mask: uint32(dec.uint(4)), offset: make([]int, rank)}
+		for k := range c.offset {
+			c.offset[k] = int(dec.uint(8))
+		}
+		dec.uint(8)
+		c.addr = dec.offset()
+		if dec.err != nil {
+			return fmt.Errorf("invalid chunk B-tree")
+		}
+
+		if level > 0 {
+			err = d.chunkTree(c.addr)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		d.chunks = append(d.chunks, c)
+	}
+
+	return nil
+}
+
+// Attr is the value of an attribute: numbers or strings.
+type Attr struct {
+	Shape []int
+	Num   []float64
+	Str   []string
+}
+
+// Attr returns the attribute name of the object path.
+func (f *File) Attr(path, name string) (*Attr, error) {
+	o, err := f.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range o.msgs {
+		if m.typ != msgAttribute {
+			continue
+		}
+
+		// The attributes of unsupported types are ignored unless requested
+		a, n, err := f.attr(m.data)
+		if err != nil && (n == "" || n == name) {
+			return nil, fmt.Errorf("%s: attribute %s: %w", path, name, err)
+		}
+		if n == name {
+			return a, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s: attribute %s: %w", path, name, os.ErrNotExist)
+}
+
+// attr decodes an attribute message. It returns the attribute and its name.
+func (f *File) attr(b []byte) (*Attr, string, error) {
+	dec := &decoder{b: b, f: f}
+	version := dec.u8()
+	dec.u8() // Flags
+	nameSize, typeSize, spaceSize := int(dec.uint(2)), int(dec.uint(2)), int(dec.uint(2))
+
+	// Version 1 pads each part to 8 bytes
+	pad := func(n int) int { return n }
+	switch version {
+	case 1:
+		pad = func(n int) int { return (n + 7) / 8 * 8 }
+	case 2:
+	case 3:
+		dec.u8() // Encoding of the name
+	default:
+		return nil, "", fmt.Errorf("unsupported version")
+	}
+
+	name := strings.TrimRight(string(dec.bytes(pad(nameSize))[:nameSize]), "\x00")
+	tb := dec.bytes(pad(typeSize))[:typeSize]
+	sb := dec.bytes(pad(spaceSize))[:spaceSize]
+	if dec.err != nil {
+		return nil, "", fmt.Errorf("invalid attribute")
+	}
+
+	t, err := decodeType(tb)
+	if err != nil {
+		return nil, name, err
+	}
+	shape, err := f.dataspace(sb)
+	if err != nil {
+		return nil, name, err
+	}
+
+	n := 1
+	for _, s := range shape {
+		n *= s
+	}
+	data := dec.bytes(n * t.size)
+	if dec.err != nil {
+		return nil, "", fmt.Errorf("invalid attribute")
+	}
+
+	a := &Attr{Shape: shape}
+	if t.class == classString {
+		for i := 0; i < n; i++ {
+			a.Str = append(a.Str, strings.TrimRight(string(data[i*t.size:(i+1)*t.size]), "\x00 "))
+		}
+	} else {
+		a.Num = t.decode(data)
+	}
+
+	return a, name, nil
+}
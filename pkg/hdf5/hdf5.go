@@ -0,0 +1,617 @@
+// Package hdf5 reads and writes a subset of the HDF5 file format, enough to
+// read the trajectories of H5MD files and to write small files of results.
+//
+// The reader supports the superblocks of version 0 to 3, the object headers of
+// version 1 and 2, the groups stored in a symbol table or with compact links,
+// and the datasets stored in compact, contiguous or chunked layouts (indexed
+// by a version 1 B-tree, the default of the HDF5 library, or by the single
+// chunk and implicit indexes). The chunks can be compressed with deflate (gzip)
+// and the shuffle and fletcher32 filters. The numerical values are converted
+// to float64. The dense storage of the links (groups with many members written
+// with the latest file format) and the other chunk indexes aren't supported.
+package hdf5
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// signature is the signature of the superblock.
+const signature = "\x89HDF\r\n\x1a\n"
+
+// undef is the undefined address.
+const undef = ^uint64(0)
+
+// Types of the header messages
+const (
+	msgDataspace    = 0x01
+	msgLinkInfo     = 0x02
+	msgDatatype     = 0x03
+	msgFillValue    = 0x05
+	msgLink         = 0x06
+	msgLayout       = 0x08
+	msgFilters      = 0x0b
+	msgAttribute    = 0x0c
+	msgContinuation = 0x10
+	msgSymbolTable  = 0x11
+)
+
+// File is a HDF5 file opened for reading. The objects are designated by their
+// absolute path (e.g: /particles/all/position/value).
+type File struct {
+	f *os.File
+
+	offsets int // Size of the addresses
+	lengths int // Size of the lengths
+	base    uint64
+	root    uint64 // Address of the object header of the root group
+}
+
+// Open opens the HDF5 file name and reads its superblock.
+func Open(name string) (*File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &File{f: f}
+	err = h.superblock()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	return f.f.Close()
+}
+
+// superblock reads the superblock. It is at the start of the file or at
+// 512, 1024, 2048... bytes if the file has a user block.
+func (f *File) superblock() error {
+	st, err := f.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	off := int64(-1)
+	for o := int64(0); o+8 <= st.Size(); o *= 2 {
+		var sig [8]byte
+		_, err := f.f.ReadAt(sig[:], o)
+		if err != nil {
+			break
+		}
+		if string(sig[:]) == signature {
+			off = o
+			break
+		}
+		if o == 0 {
+			o = 256
+		}
+	}
+	if off < 0 {
+		return fmt.Errorf("not a HDF5 file")
+	}
+
+	b := make([]byte, 128)
+	n, _ := f.f.ReadAt(b, off)
+	b = b[:n]
+	if len(b) < 24 {
+		return fmt.Errorf("not a HDF5 file")
+	}
+
+	switch b[8] {
+	case 0, 1:
+		f.offsets, f.lengths = int(b[13]), int(b[14])
+		p := 24
+		if b[8] == 1 {
+			p += 4 // Indexed storage internal node K
+		}
+		if !f.valid() {
+			return fmt.Errorf("unsupported size of the addresses")
+		}
+
+		d := &decoder{b: b, p: p, f: f}
+		f.base = d.offset()
+		d.offset() // Free-space info
+		d.offset() // End of file
+		d.offset() // Driver information block
+
+		// Root group symbol table entry
+		d.offset() // Name
+		f.root = d.offset()
+		if d.err != nil {
+			return fmt.Errorf("invalid superblock")
+		}
+	case 2, 3:
+		f.offsets, f.lengths = int(b[9]), int(b[10])
+		if !f.valid() {
+			return fmt.Errorf("unsupported size of the addresses")
+		}
+
+		d := &decoder{b: b, p: 12, f: f}
+		f.base = d.offset()
+		d.offset() // Superblock extension
+		d.offset() // End of file
+		f.root = d.offset()
+		if d.err != nil {
+			return fmt.Errorf("invalid superblock")
+		}
+	default:
+		return fmt.Errorf("unsupported version of the superblock (%d)", b[8])
+	}
+
+	// The addresses are relative to the superblock if the base address is 0
+	if f.base == 0 {
+		f.base = uint64(off)
+	}
+
+	return nil
+}
+
+// valid returns true if the sizes of the addresses and lengths are supported.
+func (f *File) valid() bool {
+	return (f.offsets == 4 || f.offsets == 8) && (f.lengths == 4 || f.lengths == 8)
+}
+
+// read reads n bytes at the address addr.
+func (f *File) read(addr uint64, n int) ([]byte, error) {
+	if addr == undef || n < 0 || n > 1<<30 {
+		return nil, fmt.Errorf("invalid address")
+	}
+
+	b := make([]byte, n)
+	_, err := f.f.ReadAt(b, int64(f.base+addr))
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return b, nil
+}
+
+// message is a header message of an object.
+type message struct {
+	typ  int
+	data []byte
+}
+
+// object is the header of an object (group or dataset).
+type object struct {
+	msgs []message
+}
+
+// find returns the first message of type typ. It returns nil if there is
+// none.
+func (o *object) find(typ int) []byte {
+	for _, m := range o.msgs {
+		if m.typ == typ {
+			return m.data
+		}
+	}
+	return nil
+}
+
+// object reads the object header at the address addr.
+func (f *File) object(addr uint64) (*object, error) {
+	b, err := f.read(addr, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &object{}
+	type block struct {
+		addr, size uint64
+	}
+	var blocks []block
+
+	if string(b[:4]) == "OHDR" {
+		if b[4] != 2 {
+			return nil, fmt.Errorf("unsupported version of object header")
+		}
+		flags := b[5]
+		p := uint64(6)
+		if flags&0x20 != 0 {
+			p += 16 // Times
+		}
+		if flags&0x10 != 0 {
+			p += 4 // Phase change values of the attributes
+		}
+
+		w := 1 << (flags & 3)
+		s, err := f.read(addr+p, w)
+		if err != nil {
+			return nil, err
+		}
+		var size uint64
+		for i := w - 1; i >= 0; i-- {
+			size = size<<8 | uint64(s[i])
+		}
+
+		blocks = append(blocks, block{addr + p + uint64(w), size})
+		for i := 0; i < len(blocks); i++ {
+			data, err := f.read(blocks[i].addr, int(blocks[i].size))
+			if err != nil {
+				return nil, err
+			}
+			if i > 0 {
+				if len(data) < 8 || string(data[:4]) != "OCHK" {
+					return nil, fmt.Errorf("invalid object header continuation")
+				}
+				data = data[4 : len(data)-4]
+			}
+
+			// The creation order of the messages is stored if it is tracked
+			hs := 4
+			if flags&0x04 != 0 {
+				hs = 6
+			}
+
+			for p := 0; p+hs <= len(data); {
+				typ, size, mflags := int(data[p]), int(binary.LittleEndian.Uint16(data[p+1:])), data[p+3]
+				p += hs
+				if p+size > len(data) {
+					return nil, fmt.Errorf("invalid object header")
+				}
+
+				m := message{typ, data[p : p+size]}
+				p += size
+
+				err = o.add(f, m, mflags, func(a, s uint64) { blocks = append(blocks, block{a, s}) })
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		return o, nil
+	}
+
+	if b[0] != 1 {
+		return nil, fmt.Errorf("unsupported version of object header")
+	}
+	size := uint64(binary.LittleEndian.Uint32(b[8:]))
+
+	blocks = append(blocks, block{addr + 16, size})
+	for i := 0; i < len(blocks); i++ {
+		data, err := f.read(blocks[i].addr, int(blocks[i].size))
+		if err != nil {
+			return nil, err
+		}
+
+		for p := 0; p+8 <= len(data); {
+			typ, size, mflags := int(binary.LittleEndian.Uint16(data[p:])), int(binary.LittleEndian.Uint16(data[p+2:])), data[p+4]
+			p += 8
+			if p+size > len(data) {
+				return nil, fmt.Errorf("invalid object header")
+			}
+
+			m := message{typ, data[p : p+size]}
+			p += size
+
+			err = o.add(f, m, mflags, func(a, s uint64) { blocks = append(blocks, block{a, s}) })
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return o, nil
+}
+
+// add adds the message m to the object. The continuation messages give a new
+// block of messages to cont.
+func (o *object) add(f *File, m message, flags byte, cont func(addr, size uint64)) error {
+	if m.typ == 0 {
+		return nil // NIL message
+	}
+
+	if m.typ == msgContinuation {
+		d := &decoder{b: m.data, f: f}
+		addr, size := d.offset(), d.length()
+		if d.err != nil {
+			return fmt.Errorf("invalid object header continuation")
+		}
+		cont(addr, size)
+		return nil
+	}
+
+	if flags&0x02 != 0 && (m.typ == msgDatatype || m.typ == msgDataspace || m.typ == msgFilters) {
+		return fmt.Errorf("shared messages are not supported")
+	}
+
+	o.msgs = append(o.msgs, m)
+	return nil
+}
+
+// lookup returns the object at the absolute path name.
+func (f *File) lookup(name string) (*object, error) {
+	o, err := f.object(f.root)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, part := range strings.Split(path.Clean("/"+name), "/") {
+		if part == "" {
+			continue
+		}
+
+		links, err := f.links(o)
+		if err != nil {
+			return nil, err
+		}
+
+		addr, ok := links[part]
+		if !ok {
+			return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+		}
+
+		o, err = f.object(addr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	return o, nil
+}
+
+// Links returns the names of the members of the group name, in alphabetical
+// order.
+func (f *File) Links(name string) ([]string, error) {
+	o, err := f.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	links, err := f.links(o)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	var names []string
+	for n := range links {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Exists returns true if the object name exists.
+func (f *File) Exists(name string) bool {
+	_, err := f.lookup(name)
+	return err == nil
+}
+
+// links returns the address of the members of the group o (hard links only).
+func (f *File) links(o *object) (map[string]uint64, error) {
+	if o.find(msgLayout) != nil {
+		return nil, fmt.Errorf("not a group")
+	}
+
+	links := make(map[string]uint64)
+
+	// Old-style groups: symbol table
+	if b := o.find(msgSymbolTable); b != nil {
+		d := &decoder{b: b, f: f}
+		tree, heap := d.offset(), d.offset()
+		if d.err != nil {
+			return nil, fmt.Errorf("invalid symbol table")
+		}
+
+		names, err := f.localHeap(heap)
+		if err != nil {
+			return nil, err
+		}
+
+		err = f.groupTree(tree, names, links)
+		if err != nil {
+			return nil, err
+		}
+		return links, nil
+	}
+
+	// New-style groups: the links are in the object header unless they are
+	// stored in a fractal heap
+	if b := o.find(msgLinkInfo); b != nil && len(b) >= 2 {
+		d := &decoder{b: b, p: 2, f: f}
+		if b[1]&1 != 0 {
+			d.p += 8 // Maximum creation index
+		}
+		if d.offset() != undef {
+			return nil, fmt.Errorf("the dense storage of the links is not supported")
+		}
+	}
+
+	for _, m := range o.msgs {
+		if m.typ != msgLink {
+			continue
+		}
+
+		d := &decoder{b: m.data, f: f}
+		d.u8() // Version
+		flags := d.u8()
+		typ := 0
+		if flags&0x08 != 0 {
+			typ = int(d.u8())
+		}
+		if flags&0x04 != 0 {
+			d.skip(8) // Creation order
+		}
+		if flags&0x10 != 0 {
+			d.u8() // Charset
+		}
+		n := int(d.uint(1 << (flags & 3)))
+		name := string(d.bytes(n))
+
+		if typ != 0 {
+			continue // Soft or external link
+		}
+
+		addr := d.offset()
+		if d.err != nil {
+			return nil, fmt.Errorf("invalid link")
+		}
+		links[name] = addr
+	}
+
+	return links, nil
+}
+
+// localHeap returns the data segment of the local heap at the address addr.
+func (f *File) localHeap(addr uint64) ([]byte, error) {
+	b, err := f.read(addr, 8+2*f.lengths+f.offsets)
+	if err != nil {
+		return nil, err
+	}
+	if string(b[:4]) != "HEAP" {
+		return nil, fmt.Errorf("invalid local heap")
+	}
+
+	d := &decoder{b: b, p: 8, f: f}
+	size := d.length()
+	d.length() // Free list
+	data := d.offset()
+
+	return f.read(data, int(size))
+}
+
+// groupTree adds the members of the group whose B-tree is at the address addr
+// to links. The names are in the local heap names.
+func (f *File) groupTree(addr uint64, names []byte, links map[string]uint64) error {
+	b, err := f.read(addr, 8+2*f.offsets)
+	if err != nil {
+		return err
+	}
+	if string(b[:4]) != "TREE" || b[4] != 0 {
+		return fmt.Errorf("invalid group B-tree")
+	}
+	level := b[5]
+	n := int(binary.LittleEndian.Uint16(b[6:]))
+
+	// Keys and children are interleaved
+	b, err = f.read(addr+uint64(len(b)), (2*n+1)*f.lengths+n*f.offsets)
+	if err != nil {
+		return err
+	}
+	d := &decoder{b: b, f: f}
+
+	for i := 0; i < n; i++ {
+		d.length()
+		child := d.offset()
+		if d.err != nil {
+			return fmt.Errorf("invalid group B-tree")
+		}
+
+		if level > 0 {
+			err = f.groupTree(child, names, links)
+		} else {
+			err = f.symbolNode(child, names, links)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// symbolNode adds the members of the symbol table node at the address addr
+// to links.
+func (f *File) symbolNode(addr uint64, names []byte, links map[string]uint64) error {
+	b, err := f.read(addr, 8)
+	if err != nil {
+		return err
+	}
+	if string(b[:4]) != "SNOD" {
+		return fmt.Errorf("invalid symbol table node")
+	}
+	n := int(binary.LittleEndian.Uint16(b[6:]))
+
+	entry := 2*f.offsets + 24
+	b, err = f.read(addr+8, n*entry)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < n; i++ {
+		d := &decoder{b: b[i*entry:], f: f}
+		off := d.offset()
+		obj := d.offset()
+
+		if off >= uint64(len(names)) {
+			return fmt.Errorf("invalid symbol table entry")
+		}
+		name := names[off:]
+		if end := strings.IndexByte(string(name), 0); end >= 0 {
+			name = name[:end]
+		}
+		links[string(name)] = obj
+	}
+
+	return nil
+}
+
+// decoder decodes the little endian fields of the structures of the file. The
+// first error is kept and the following reads return zero values.
+type decoder struct {
+	b   []byte
+	p   int
+	f   *File
+	err error
+}
+
+// bytes returns the next n bytes.
+func (d *decoder) bytes(n int) []byte {
+	if d.err == nil && (n < 0 || d.p+n > len(d.b)) {
+		d.err = io.ErrUnexpectedEOF
+	}
+	if d.err != nil {
+		return make([]byte, 8)
+	}
+	d.p += n
+	return d.b[d.p-n : d.p]
+}
+
+// skip skips n bytes.
+func (d *decoder) skip(n int) {
+	d.bytes(n)
+}
+
+// u8 reads a byte.
+func (d *decoder) u8() byte {
+	return d.bytes(1)[0]
+}
+
+// uint reads an unsigned integer of n bytes.
+func (d *decoder) uint(n int) uint64 {
+	b := d.bytes(n)
+	if d.err != nil {
+		return 0
+	}
+
+	var v uint64
+	for i := n - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v
+}
+
+// offset reads an address. The undefined address is kept whatever its size.
+func (d *decoder) offset() uint64 {
+	v := d.uint(d.f.offsets)
+	if d.f.offsets < 8 && v == 1<<(8*uint(d.f.offsets))-1 {
+		return undef
+	}
+	return v
+}
+
+// length reads a length.
+func (d *decoder) length() uint64 {
+	return d.uint(d.f.lengths)
+}
@@ -0,0 +1,540 @@
+package hdf5
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// tempFile returns the name of a file in a temporary directory and a function
+// removing the directory.
+func tempFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "hdf5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "test.h5"), func() { os.RemoveAll(dir) }
+}
+
+func TestWriter(t *testing.T) {
+	name, remove := tempFile(t)
+	defer remove()
+
+	w, err := Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// More members than a symbol table node holds
+	g := w.Root().Group("results").Attr("title", "msd").Attr("ids", []int{1, -2, 3})
+	var members []string
+	for i := 0; i < 3*2*leafK+1; i++ {
+		n := fmt.Sprintf("d%02d", i)
+		g.Dataset(n, []int{2, 3}, []float64{float64(i), 1, 2, 3, 4, -5.5})
+		members = append(members, n)
+	}
+	g.Group("sub").Dataset("scalar", nil, []float64{math.Pi}).Attr("unit", []string{"nm", "ps^-1"}).Attr("dt", 0.5).Attr("n", 7).Attr("w", []float64{1, 2})
+	members = append(members, "sub")
+	w.Root().Group("results").Group("sub") // Already created
+
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	links, err := f.Links("/results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(members)
+	if !reflect.DeepEqual(links, members) {
+		t.Errorf("Links = %v, want %v", links, members)
+	}
+
+	for i := 0; i < 3*2*leafK+1; i++ {
+		d, err := f.Dataset(fmt.Sprintf("/results/d%02d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := d.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []float64{float64(i), 1, 2, 3, 4, -5.5}; !reflect.DeepEqual(d.Shape, []int{2, 3}) || !reflect.DeepEqual(v, want) {
+			t.Errorf("d%02d: %v %v, want [2 3] %v", i, d.Shape, v, want)
+		}
+		v, err = d.Rows(1, 1)
+		if err != nil || !reflect.DeepEqual(v, []float64{3, 4, -5.5}) {
+			t.Errorf("d%02d: Rows(1, 1) = %v, %v, want [3 4 -5.5]", i, v, err)
+		}
+	}
+
+	d, err := f.Dataset("results/sub/scalar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := d.Read()
+	if err != nil || len(d.Shape) != 0 || !reflect.DeepEqual(v, []float64{math.Pi}) {
+		t.Errorf("scalar: %v %v, %v, want [] [%g]", d.Shape, v, err, math.Pi)
+	}
+
+	for _, tc := range []struct {
+		path, name string
+		want       *Attr
+	}{
+		{"/results", "title", &Attr{Shape: []int{}, Str: []string{"msd"}}},
+		{"/results", "ids", &Attr{Shape: []int{3}, Num: []float64{1, -2, 3}}},
+		{"/results/sub/scalar", "unit", &Attr{Shape: []int{2}, Str: []string{"nm", "ps^-1"}}},
+		{"/results/sub/scalar", "dt", &Attr{Shape: []int{}, Num: []float64{0.5}}},
+		{"/results/sub/scalar", "n", &Attr{Shape: []int{}, Num: []float64{7}}},
+		{"/results/sub/scalar", "w", &Attr{Shape: []int{2}, Num: []float64{1, 2}}},
+	} {
+		a, err := f.Attr(tc.path, tc.name)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		} else if !reflect.DeepEqual(a, tc.want) {
+			t.Errorf("%s: Attr = %+v, want %+v", tc.name, a, tc.want)
+		}
+	}
+
+	if f.Exists("/results/d99") || !f.Exists("/results/sub") {
+		t.Errorf("Exists is wrong")
+	}
+	_, err = f.Dataset("/results/sub")
+	if err == nil {
+		t.Errorf("Dataset accepted a group")
+	}
+}
+
+// builder writes a HDF5 file in the latest format of the HDF5 library 1.8:
+// superblock version 2, object headers version 2 and links stored in the
+// headers. The datasets are chunked and indexed by version 1 B-trees (the
+// datasets of unlimited size written by h5py and the H5MD writers) or by the
+// single chunk and implicit indexes of HDF5 1.10.
+type builder struct {
+	b []byte
+}
+
+// alloc appends b to the file and returns its address.
+func (w *builder) alloc(b []byte) uint64 {
+	addr := uint64(len(w.b))
+	w.b = append(w.b, b...)
+	return addr
+}
+
+// object writes an object header made of the messages msgs (see msg).
+func (w *builder) object(msgs ...[]byte) uint64 {
+	var body []byte
+	for _, m := range msgs {
+		body = append(body, m...)
+	}
+
+	h := []byte("OHDR")
+	h = append(h, 2, 0x02) // Size of the chunk in 4 bytes
+	h = appendU(h, 4, uint64(len(body)))
+	h = append(h, body...)
+	return w.alloc(appendU(h, 4, 0)) // Checksum (not verified)
+}
+
+// msg returns a header message of an object header version 2.
+func msg(typ int, data []byte) []byte {
+	b := []byte{byte(typ)}
+	b = appendU(b, 2, uint64(len(data)))
+	return append(append(b, 0), data...)
+}
+
+// group writes a group whose members are links.
+func (w *builder) group(links map[string]uint64, attrs ...[]byte) uint64 {
+	info := []byte{0, 0}
+	info = appendU(info, 8, undef) // No fractal heap
+	info = appendU(info, 8, undef)
+	msgs := [][]byte{msg(msgLinkInfo, info), msg(0x0a, []byte{0, 0})}
+
+	var names []string
+	for n := range links {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		l := []byte{1, 0, byte(len(n))}
+		l = append(l, n...)
+		msgs = append(msgs, msg(msgLink, appendU(l, 8, links[n])))
+	}
+
+	for _, a := range attrs {
+		msgs = append(msgs, msg(msgAttribute, a))
+	}
+	return w.object(msgs...)
+}
+
+// space returns a dataspace message version 2 of shape shape, whose first
+// dimension is unlimited if it isn't a scalar.
+func space(shape []int) []byte {
+	if len(shape) == 0 {
+		return []byte{2, 0, 0, 0}
+	}
+
+	b := []byte{2, byte(len(shape)), 1, 1}
+	for _, s := range shape {
+		b = appendU(b, 8, uint64(s))
+	}
+	b = appendU(b, 8, undef)
+	for _, s := range shape[1:] {
+		b = appendU(b, 8, uint64(s))
+	}
+	return b
+}
+
+// attr3 returns an attribute message version 3.
+func attr3(name string, dtype, space, data []byte) []byte {
+	b := []byte{3, 0}
+	b = appendU(b, 2, uint64(len(name)+1))
+	b = appendU(b, 2, uint64(len(dtype)))
+	b = appendU(b, 2, uint64(len(space)))
+	b = append(b, 0)
+	b = append(b, name...)
+	b = append(b, 0)
+	b = append(b, dtype...)
+	b = append(b, space...)
+	return append(b, data...)
+}
+
+// float32Type returns the datatype of a little endian float32.
+func float32Type() []byte {
+	b := []byte{0x10 | classFloat, 0x20, 31, 0}
+	b = appendU(b, 4, 4)
+	b = appendU(b, 2, 0)
+	b = appendU(b, 2, 32)
+	b = append(b, 23, 8, 0, 23)
+	return appendU(b, 4, 127)
+}
+
+// int16BEType returns the datatype of a big endian signed int16.
+func int16BEType() []byte {
+	b := []byte{0x10 | classFixed, 0x09, 0, 0}
+	b = appendU(b, 4, 2)
+	b = appendU(b, 2, 0)
+	return appendU(b, 2, 16)
+}
+
+// filters applies the shuffle (if shuffle is true) and deflate filters to the
+// chunk b of elements of size size.
+func filters(b []byte, size int, shuffle bool) []byte {
+	if shuffle {
+		s := make([]byte, len(b))
+		m := len(b) / size
+		for e := 0; e < m; e++ {
+			for k := 0; k < size; k++ {
+				s[k*m+e] = b[e*size+k]
+			}
+		}
+		b = s
+	}
+
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(b)
+	zw.Close()
+	return z.Bytes()
+}
+
+// pipeline returns a filter pipeline message version 2 of the shuffle (if
+// shuffle is true) and deflate filters.
+func pipeline(size int, shuffle bool) []byte {
+	b := []byte{2, 1}
+	if shuffle {
+		b[1] = 2
+		b = appendU(b, 2, filterShuffle)
+		b = appendU(b, 2, 1)
+		b = appendU(b, 2, 1)
+		b = appendU(b, 4, uint64(size))
+	}
+	b = appendU(b, 2, filterDeflate)
+	b = appendU(b, 2, 1)
+	b = appendU(b, 2, 1)
+	return appendU(b, 4, 6)
+}
+
+// chunks splits the values data (elements of size size) of a dataset of shape
+// shape into chunks of dimensions dims. The chunks are padded with zeros.
+func chunks(data []byte, size int, shape, dims []int) (offsets [][]int, bufs [][]byte) {
+	rank := len(shape)
+	n := make([]int, rank)
+	total := 1
+	for k := range shape {
+		n[k] = (shape[k] + dims[k] - 1) / dims[k]
+		total *= n[k]
+	}
+
+	csize := size
+	for _, d := range dims {
+		csize *= d
+	}
+
+	for i := 0; i < total; i++ {
+		off := make([]int, rank)
+		r := i
+		for k := rank - 1; k >= 0; k-- {
+			off[k] = r % n[k] * dims[k]
+			r /= n[k]
+		}
+
+		buf := make([]byte, csize)
+		idx := make([]int, rank)
+		for e := 0; e < csize/size; e++ {
+			r, pos, in := e, 0, true
+			for k := rank - 1; k >= 0; k-- {
+				idx[k] = off[k] + r%dims[k]
+				r /= dims[k]
+			}
+			for k := 0; k < rank; k++ {
+				if idx[k] >= shape[k] {
+					in = false
+					break
+				}
+				pos = pos*shape[k] + idx[k]
+			}
+			if in {
+				copy(buf[e*size:(e+1)*size], data[pos*size:])
+			}
+		}
+
+		offsets = append(offsets, off)
+		bufs = append(bufs, buf)
+	}
+	return
+}
+
+// btree writes the chunks of a dataset of shape shape, filtered if shuffle or
+// deflate is true, and their B-tree. It returns its layout message version 3.
+func (w *builder) btree(data []byte, size int, shape, dims []int, shuffle, deflate bool) []byte {
+	offsets, bufs := chunks(data, size, shape, dims)
+
+	var keys []byte
+	for i, b := range bufs {
+		if deflate {
+			b = filters(b, size, shuffle)
+		}
+		addr := w.alloc(b)
+
+		keys = appendU(keys, 4, uint64(len(b)))
+		keys = appendU(keys, 4, 0)
+		for _, o := range offsets[i] {
+			keys = appendU(keys, 8, uint64(o))
+		}
+		keys = appendU(keys, 8, 0)
+		keys = appendU(keys, 8, addr)
+	}
+	keys = appendU(keys, 8, 0)
+	for _, s := range shape {
+		keys = appendU(keys, 8, uint64(s))
+	}
+	keys = appendU(keys, 8, 0)
+
+	b := []byte("TREE")
+	b = append(b, 1, 0)
+	b = appendU(b, 2, uint64(len(bufs)))
+	b = appendU(b, 8, undef)
+	b = appendU(b, 8, undef)
+	tree := w.alloc(append(b, keys...))
+
+	layout := []byte{3, layoutChunked, byte(len(shape) + 1)}
+	layout = appendU(layout, 8, tree)
+	for _, d := range dims {
+		layout = appendU(layout, 4, uint64(d))
+	}
+	return appendU(layout, 4, uint64(size))
+}
+
+// latest writes a file in the latest format (see builder) and returns the
+// values of its datasets.
+func latest(t *testing.T, name string) map[string][]float64 {
+	w := &builder{b: make([]byte, 48)}
+	values := make(map[string][]float64)
+
+	// Positions of 4 atoms in 5 frames (float32)
+	var pos []byte
+	for i := 0; i < 5*4*3; i++ {
+		v := float32(i)/4 - 3
+		pos = appendU(pos, 4, uint64(math.Float32bits(v)))
+		values["position"] = append(values["position"], float64(v))
+	}
+	position := w.object(
+		msg(msgDataspace, space([]int{5, 4, 3})),
+		msg(msgDatatype, float32Type()),
+		msg(msgFillValue, []byte{3, 0x0a}),
+		msg(msgLayout, w.btree(pos, 4, []int{5, 4, 3}, []int{2, 3, 3}, true, true)),
+		msg(msgFilters, pipeline(4, true)),
+	)
+
+	// Steps (int32)
+	var step []byte
+	for i := 0; i < 5; i++ {
+		step = appendU(step, 4, uint64(10*i))
+		values["step"] = append(values["step"], float64(10*i))
+	}
+	steps := w.object(
+		msg(msgDataspace, space([]int{5})),
+		msg(msgDatatype, intType()),
+		msg(msgLayout, w.btree(step, 4, []int{5}, []int{2}, false, true)),
+		msg(msgFilters, pipeline(4, false)),
+	)
+
+	// Single chunk (float64) compressed
+	var single []byte
+	for i := 0; i < 3*2; i++ {
+		single = appendU(single, 8, math.Float64bits(float64(i)*1.5))
+		values["single"] = append(values["single"], float64(i)*1.5)
+	}
+	z := filters(single, 8, false)
+	layout := []byte{4, layoutChunked, 0x02, 3, 4}
+	layout = appendU(layout, 4, 3)
+	layout = appendU(layout, 4, 2)
+	layout = appendU(layout, 4, 8)
+	layout = append(layout, 1)
+	layout = appendU(layout, 8, uint64(len(z)))
+	layout = appendU(layout, 4, 0)
+	layout = appendU(layout, 8, w.alloc(z))
+	singleAddr := w.object(
+		msg(msgDataspace, space([]int{3, 2})),
+		msg(msgDatatype, floatType()),
+		msg(msgLayout, layout),
+		msg(msgFilters, pipeline(8, false)),
+	)
+
+	// Implicit index (big endian int16)
+	var implicit []byte
+	for i := 0; i < 4*2; i++ {
+		implicit = append(implicit, 0, 0)
+		binary.BigEndian.PutUint16(implicit[2*i:], uint16(int16(i-3)))
+		values["implicit"] = append(values["implicit"], float64(i-3))
+	}
+	_, bufs := chunks(implicit, 2, []int{4, 2}, []int{2, 2})
+	layout = []byte{4, layoutChunked, 0, 3, 1, 2, 2, 2, 2}
+	layout = appendU(layout, 8, w.alloc(bytes.Join(bufs, nil)))
+	implicitAddr := w.object(
+		msg(msgDataspace, []byte{2, 2, 0, 1, 4, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0}),
+		msg(msgDatatype, int16BEType()),
+		msg(msgLayout, layout),
+	)
+
+	// Compact scalar
+	compact := []byte{3, layoutCompact, 8, 0}
+	compact = appendU(compact, 8, math.Float64bits(-0.25))
+	values["compact"] = []float64{-0.25}
+	compactAddr := w.object(
+		msg(msgDataspace, space(nil)),
+		msg(msgDatatype, floatType()),
+		msg(msgLayout, compact),
+	)
+
+	var boundary []byte
+	for _, b := range []string{"periodic", "none", "none"} {
+		boundary = append(boundary, make([]byte, 9)...)
+		copy(boundary[len(boundary)-9:], b)
+	}
+	strs := stringType(9)
+	group := w.group(map[string]uint64{"step": steps, "value": position},
+		attr3("boundary", strs, []byte{2, 1, 0, 1, 3, 0, 0, 0, 0, 0, 0, 0}, boundary),
+		attr3("version", intType(), []byte{2, 1, 0, 1, 2, 0, 0, 0, 0, 0, 0, 0}, appendU(appendU(nil, 4, 1), 4, 1)))
+	root := w.group(map[string]uint64{"position": group, "single": singleAddr, "implicit": implicitAddr, "compact": compactAddr})
+
+	b := []byte(signature)
+	b = append(b, 2, 8, 8, 0)
+	b = appendU(b, 8, 0)
+	b = appendU(b, 8, undef)
+	b = appendU(b, 8, uint64(len(w.b)))
+	b = appendU(b, 8, root)
+	b = appendU(b, 4, 0)
+	copy(w.b, b)
+
+	err := ioutil.WriteFile(name, w.b, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return values
+}
+
+func TestLatest(t *testing.T) {
+	name, remove := tempFile(t)
+	defer remove()
+	values := latest(t, name)
+
+	f, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	links, err := f.Links("/")
+	if want := []string{"compact", "implicit", "position", "single"}; err != nil || !reflect.DeepEqual(links, want) {
+		t.Errorf("Links = %v, %v, want %v", links, err, want)
+	}
+
+	for _, tc := range []struct {
+		path, name string
+		shape      []int
+	}{
+		{"/position/value", "position", []int{5, 4, 3}},
+		{"/position/step", "step", []int{5}},
+		{"/single", "single", []int{3, 2}},
+		{"/implicit", "implicit", []int{4, 2}},
+		{"/compact", "compact", []int{}},
+	} {
+		d, err := f.Dataset(tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(d.Shape, tc.shape) {
+			t.Errorf("%s: Shape = %v, want %v", tc.path, d.Shape, tc.shape)
+		}
+
+		v, err := d.Read()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.path, err)
+		}
+		if !reflect.DeepEqual(v, values[tc.name]) {
+			t.Errorf("%s: Read = %v, want %v", tc.path, v, values[tc.name])
+		}
+
+		// Rows across the chunks, backward
+		if len(tc.shape) == 0 {
+			continue
+		}
+		row := len(values[tc.name]) / tc.shape[0]
+		for start := tc.shape[0] - 1; start >= 0; start-- {
+			for n := 1; start+n <= tc.shape[0]; n++ {
+				v, err := d.Rows(start, n)
+				if err != nil {
+					t.Fatalf("%s: Rows(%d, %d): %v", tc.path, start, n, err)
+				}
+				if want := values[tc.name][start*row : (start+n)*row]; !reflect.DeepEqual(v, want) {
+					t.Errorf("%s: Rows(%d, %d) = %v, want %v", tc.path, start, n, v, want)
+				}
+			}
+		}
+	}
+
+	a, err := f.Attr("/position", "boundary")
+	if want := []string{"periodic", "none", "none"}; err != nil || !reflect.DeepEqual(a.Str, want) {
+		t.Errorf("boundary = %v, %v, want %v", a, err, want)
+	}
+	a, err = f.Attr("/position", "version")
+	if want := []float64{1, 1}; err != nil || !reflect.DeepEqual(a.Num, want) {
+		t.Errorf("version = %v, %v, want %v", a, err, want)
+	}
+}
@@ -0,0 +1,380 @@
+package hdf5
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// Parameters of the group B-trees and symbol table nodes written
+const (
+	leafK = 4  // A symbol table node has up to 2*leafK members
+	nodeK = 16 // A B-tree node has up to 2*nodeK children
+)
+
+// Writer writes a HDF5 file readable by any version of the HDF5 library
+// (superblock version 0, groups stored in symbol tables and contiguous
+// datasets of float64). The objects are kept in memory and written by Close.
+type Writer struct {
+	f    *os.File
+	root *Object
+
+	b []byte // Content of the file
+}
+
+// Object is a group or a dataset to be written.
+type Object struct {
+	name    string
+	members []*Object // Members of a group
+	attrs   []attr
+
+	dataset bool
+	shape   []int
+	data    []float64
+}
+
+// attr is an attribute to be written.
+type attr struct {
+	name  string
+	dtype []byte
+	shape []int
+	data  []byte
+}
+
+// Create creates the HDF5 file name.
+func Create(name string) (*Writer, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{f: f, root: &Object{}}, nil
+}
+
+// Root returns the root group.
+func (w *Writer) Root() *Object {
+	return w.root
+}
+
+// Group returns the member name of the group o. It is created if it doesn't
+// exist.
+func (o *Object) Group(name string) *Object {
+	for _, m := range o.members {
+		if m.name == name && !m.dataset {
+			return m
+		}
+	}
+
+	g := &Object{name: name}
+	o.members = append(o.members, g)
+	return g
+}
+
+// Dataset adds the dataset name of shape shape to the group o. The number of
+// values of data must be the product of shape (1 for a scalar).
+func (o *Object) Dataset(name string, shape []int, data []float64) *Object {
+	d := &Object{name: name, dataset: true, shape: shape, data: data}
+	o.members = append(o.members, d)
+	return d
+}
+
+// Attr adds the attribute name to the object o. The value v can be a string,
+// an int, a float64 or a slice of them.
+func (o *Object) Attr(name string, v interface{}) *Object {
+	a := attr{name: name}
+
+	switch v := v.(type) {
+	case string:
+		a.dtype = stringType(len(v) + 1)
+		a.data = append([]byte(v), 0)
+	case []string:
+		n := 1
+		for _, s := range v {
+			if len(s)+1 > n {
+				n = len(s) + 1
+			}
+		}
+		a.dtype = stringType(n)
+		a.shape = []int{len(v)}
+		for _, s := range v {
+			b := make([]byte, n)
+			copy(b, s)
+			a.data = append(a.data, b...)
+		}
+	case int:
+		a.dtype = intType()
+		a.data = appendU(nil, 4, uint64(int32(v)))
+	case []int:
+		a.dtype = intType()
+		a.shape = []int{len(v)}
+		for _, i := range v {
+			a.data = appendU(a.data, 4, uint64(int32(i)))
+		}
+	case float64:
+		a.dtype = floatType()
+		a.data = appendU(nil, 8, math.Float64bits(v))
+	case []float64:
+		a.dtype = floatType()
+		a.shape = []int{len(v)}
+		for _, f := range v {
+			a.data = appendU(a.data, 8, math.Float64bits(f))
+		}
+	default:
+		panic(fmt.Sprintf("hdf5: unsupported type of attribute %T", v))
+	}
+
+	o.attrs = append(o.attrs, a)
+	return o
+}
+
+// Close writes the file and closes it.
+func (w *Writer) Close() error {
+	w.b = make([]byte, 96) // Superblock
+
+	root, stab, err := w.object(w.root)
+	if err != nil {
+		w.f.Close()
+		return err
+	}
+
+	// Superblock (version 0) with the entry of the root group
+	b := []byte(signature)
+	b = append(b, 0, 0, 0, 0, 0, 8, 8, 0)
+	b = appendU(b, 2, leafK)
+	b = appendU(b, 2, nodeK)
+	b = appendU(b, 4, 0)
+	b = appendU(b, 8, 0)
+	b = appendU(b, 8, undef)
+	b = appendU(b, 8, uint64(len(w.b)))
+	b = appendU(b, 8, undef)
+	b = appendU(b, 8, 0)
+	b = appendU(b, 8, root)
+	b = appendU(b, 4, 1) // The B-tree and the heap are cached
+	b = appendU(b, 4, 0)
+	b = appendU(b, 8, stab[0])
+	b = appendU(b, 8, stab[1])
+	copy(w.b, b)
+
+	_, err = w.f.Write(w.b)
+	if err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// alloc appends b to the file and returns its address.
+func (w *Writer) alloc(b []byte) uint64 {
+	addr := uint64(len(w.b))
+	w.b = append(w.b, b...)
+	return addr
+}
+
+// object writes the object o and its members. It returns the address of its
+// header and, for a group, the address of its B-tree and its local heap.
+func (w *Writer) object(o *Object) (uint64, [2]uint64, error) {
+	var msgs [][2]interface{} // Type and data of the messages
+	var stab [2]uint64
+
+	if o.dataset {
+		n := 1
+		for _, s := range o.shape {
+			n *= s
+		}
+		if n != len(o.data) {
+			return 0, stab, fmt.Errorf("hdf5: dataset %s: the number of values doesn't match the shape", o.name)
+		}
+
+		var data []byte
+		for _, v := range o.data {
+			data = appendU(data, 8, math.Float64bits(v))
+		}
+		addr := w.alloc(data)
+
+		layout := []byte{3, layoutContiguous}
+		layout = appendU(layout, 8, addr)
+		layout = appendU(layout, 8, uint64(len(data)))
+
+		msgs = append(msgs,
+			[2]interface{}{msgDataspace, dataspace(o.shape)},
+			[2]interface{}{msgDatatype, floatType()},
+			[2]interface{}{msgFillValue, []byte{2, 1, 2, 0}}, // Undefined
+			[2]interface{}{msgLayout, layout},
+		)
+	} else {
+		var err error
+		stab, err = w.group(o)
+		if err != nil {
+			return 0, stab, err
+		}
+
+		var b []byte
+		b = appendU(b, 8, stab[0])
+		b = appendU(b, 8, stab[1])
+		msgs = append(msgs, [2]interface{}{msgSymbolTable, b})
+	}
+
+	for _, a := range o.attrs {
+		name := append([]byte(a.name), 0)
+		space := dataspace(a.shape)
+
+		b := []byte{1, 0}
+		b = appendU(b, 2, uint64(len(name)))
+		b = appendU(b, 2, uint64(len(a.dtype)))
+		b = appendU(b, 2, uint64(len(space)))
+		b = append(b, pad(name)...)
+		b = append(b, pad(a.dtype)...)
+		b = append(b, pad(space)...)
+		b = append(b, a.data...)
+		msgs = append(msgs, [2]interface{}{msgAttribute, b})
+	}
+
+	// Object header (version 1): the messages are aligned on 8 bytes
+	var body []byte
+	for _, m := range msgs {
+		data := pad(m[1].([]byte))
+		if len(data) > 0xffff {
+			return 0, stab, fmt.Errorf("hdf5: %s: header message too large", o.name)
+		}
+		body = appendU(body, 2, uint64(m[0].(int)))
+		body = appendU(body, 2, uint64(len(data)))
+		body = append(body, 0, 0, 0, 0)
+		body = append(body, data...)
+	}
+
+	h := []byte{1, 0}
+	h = appendU(h, 2, uint64(len(msgs)))
+	h = appendU(h, 4, 1) // Reference count
+	h = appendU(h, 4, uint64(len(body)))
+	h = append(h, 0, 0, 0, 0)
+
+	return w.alloc(append(h, body...)), stab, nil
+}
+
+// group writes the members of the group o, its local heap, its symbol table
+// nodes and its B-tree. It returns the address of the B-tree and of the local
+// heap.
+func (w *Writer) group(o *Object) ([2]uint64, error) {
+	members := append([]*Object(nil), o.members...)
+	sort.SliceStable(members, func(i, j int) bool { return members[i].name < members[j].name })
+
+	for i := 1; i < len(members); i++ {
+		if members[i].name == members[i-1].name {
+			return [2]uint64{}, fmt.Errorf("hdf5: %s: duplicated member %s", o.name, members[i].name)
+		}
+	}
+
+	if len(members) > 2*leafK*2*nodeK {
+		return [2]uint64{}, fmt.Errorf("hdf5: %s: too many members", o.name)
+	}
+
+	addrs := make([]uint64, len(members))
+	for i, m := range members {
+		var err error
+		addrs[i], _, err = w.object(m)
+		if err != nil {
+			return [2]uint64{}, err
+		}
+	}
+
+	// Local heap: the names (the first one is empty)
+	heap := make([]byte, 8)
+	offsets := make([]uint64, len(members))
+	for i, m := range members {
+		offsets[i] = uint64(len(heap))
+		heap = append(heap, pad(append([]byte(m.name), 0))...)
+	}
+
+	h := []byte("HEAP")
+	h = append(h, 0, 0, 0, 0)
+	h = appendU(h, 8, uint64(len(heap)))
+	h = appendU(h, 8, undef) // No free block
+	h = appendU(h, 8, uint64(len(w.b)+len(h)+8))
+	heapAddr := w.alloc(append(h, heap...))
+
+	// Symbol table nodes of up to 2*leafK members
+	var nodes []uint64
+	var keys []uint64
+	for i := 0; i < len(members); i += 2 * leafK {
+		end := i + 2*leafK
+		if end > len(members) {
+			end = len(members)
+		}
+
+		b := []byte("SNOD")
+		b = append(b, 1, 0)
+		b = appendU(b, 2, uint64(end-i))
+		for k := i; k < end; k++ {
+			b = appendU(b, 8, offsets[k])
+			b = appendU(b, 8, addrs[k])
+			b = append(b, make([]byte, 24)...) // Nothing cached
+		}
+		b = append(b, make([]byte, 8+2*leafK*40-len(b))...)
+
+		nodes = append(nodes, w.alloc(b))
+		keys = append(keys, offsets[end-1])
+	}
+
+	// B-tree (one leaf) whose keys are the last name of each node
+	b := []byte("TREE")
+	b = append(b, 0, 0)
+	b = appendU(b, 2, uint64(len(nodes)))
+	b = appendU(b, 8, undef)
+	b = appendU(b, 8, undef)
+	b = appendU(b, 8, 0)
+	for i, n := range nodes {
+		b = appendU(b, 8, n)
+		b = appendU(b, 8, keys[i])
+	}
+	b = append(b, make([]byte, 24+(2*nodeK+1)*8+2*nodeK*8-len(b))...)
+	treeAddr := w.alloc(b)
+
+	return [2]uint64{treeAddr, heapAddr}, nil
+}
+
+// dataspace returns a dataspace message (version 1) of shape shape.
+func dataspace(shape []int) []byte {
+	b := []byte{1, byte(len(shape)), 0, 0, 0, 0, 0, 0}
+	for _, s := range shape {
+		b = appendU(b, 8, uint64(s))
+	}
+	return b
+}
+
+// floatType returns the datatype of a little endian float64.
+func floatType() []byte {
+	b := []byte{0x10 | classFloat, 0x20, 63, 0}
+	b = appendU(b, 4, 8)
+	b = appendU(b, 2, 0)
+	b = appendU(b, 2, 64)
+	b = append(b, 52, 11, 0, 52)
+	return appendU(b, 4, 1023)
+}
+
+// intType returns the datatype of a little endian signed int32.
+func intType() []byte {
+	b := []byte{0x10 | classFixed, 0x08, 0, 0}
+	b = appendU(b, 4, 4)
+	b = appendU(b, 2, 0)
+	return appendU(b, 2, 32)
+}
+
+// stringType returns the datatype of a null-terminated ASCII string of n
+// bytes.
+func stringType(n int) []byte {
+	b := []byte{0x10 | classString, 0, 0, 0}
+	return appendU(b, 4, uint64(n))
+}
+
+// pad pads b with zeros to a multiple of 8 bytes.
+func pad(b []byte) []byte {
+	return append(b, make([]byte, (8-len(b)%8)%8)...)
+}
+
+// appendU appends the unsigned integer v of n bytes (little endian) to b.
+func appendU(b []byte, n int, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:n]...)
+}
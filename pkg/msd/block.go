@@ -0,0 +1,189 @@
+package msd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kpotier/selfdiff/pkg/stat"
+	"github.com/kpotier/selfdiff/pkg/topo"
+)
+
+// Blocks is a structure containing the results of a block averaging. Each
+// block is a MSD performed on a contiguous part of the trajectory. The results
+// of the blocks are averaged for each species.
+type Blocks struct {
+	Blocks []*MSD
+
+	Out    string
+	FitOut string
+	Dt     float64
+
+	FitStart int
+	FitEnd   int
+
+	Curves []*BlockCurve // One for each species
+}
+
+// BlockCurve is the block average of the mean squared displacement of one
+// species. Res and Err are the mean and the standard error of the blocks for
+// each lag. D and DErr are the mean and the standard error of the diffusion
+// coefficients of the blocks, each one being fitted over the same window. Tens
+// and Tensor are the mean displacement tensor and the mean diffusion tensor of
+// the blocks.
+type BlockCurve struct {
+	topo.Species
+
+	Res  []float64
+	Err  []float64
+	Tens [][6]float64
+
+	Start     int // First lag of the fit window
+	End       int // Last lag of the fit window
+	D         float64
+	DErr      float64
+	Tensor    Tensor
+	TensorErr [6]float64
+}
+
+// Perform performs the mean squared displacement of each block and averages
+// them. All the blocks must have the same number of configurations.
+func (b *Blocks) Perform() error {
+	if len(b.Blocks) == 0 {
+		return fmt.Errorf("no block")
+	}
+
+	for i, m := range b.Blocks {
+		fmt.Println("> Block", i+1, "/", len(b.Blocks))
+
+		err := m.Perform()
+		if err != nil {
+			return fmt.Errorf("block %d: %w", i+1, err)
+		}
+	}
+
+	n := len(b.Blocks[0].Curves[0].Res)
+	x := make([]float64, len(b.Blocks))
+
+	b.Curves = nil
+	for s, sp := range b.Blocks[0].Species {
+		cur := &BlockCurve{Species: sp, Res: make([]float64, n), Err: make([]float64, n), Tens: make([][6]float64, n)}
+
+		for i := 0; i < n; i++ {
+			for j, m := range b.Blocks {
+				if len(m.Curves[s].Res) != n {
+					return fmt.Errorf("blocks don't have the same length")
+				}
+				x[j] = m.Curves[s].Res[i]
+			}
+			cur.Res[i], cur.Err[i] = stat.MeanErr(x)
+
+			for c := range Pairs {
+				for j, m := range b.Blocks {
+					x[j] = m.Curves[s].Tens[i][c]
+				}
+				cur.Tens[i][c], _ = stat.MeanErr(x)
+			}
+		}
+
+		b.Curves = append(b.Curves, cur)
+	}
+
+	return nil
+}
+
+// Einstein determines the fit window on the averaged MSD of each species, fits
+// each block over this window and averages the resulting diffusion
+// coefficients. Perform must be called beforehand.
+func (b *Blocks) Einstein() error {
+	d := make([]float64, len(b.Blocks))
+
+	for s, cur := range b.Curves {
+		_, start, end, err := window(cur.Res, b.Dt, b.FitStart, b.FitEnd)
+		if err != nil {
+			if cur.Name != "" {
+				return fmt.Errorf("species %s: %w", cur.Name, err)
+			}
+			return err
+		}
+
+		for i, m := range b.Blocks {
+			err := m.Curves[s].einstein(b.Dt, start+1, end)
+			if err != nil {
+				return fmt.Errorf("block %d: %w", i+1, err)
+			}
+			d[i] = m.Curves[s].Fit.D
+		}
+		cur.Start, cur.End = start+1, end // The window is only kept if every block is fitted
+
+		cur.D, cur.DErr = stat.MeanErr(d)
+
+		for c := range Pairs {
+			for i, m := range b.Blocks {
+				d[i] = m.Curves[s].Fit.Tensor.Comp[c]
+			}
+			cur.Tensor.Comp[c], cur.TensorErr[c] = stat.MeanErr(d)
+		}
+		cur.Tensor.eigen()
+	}
+
+	return nil
+}
+
+// Write writes the mean and the standard error of the blocks into Out. The
+// name of the species is inserted in Out if it isn't empty.
+func (b *Blocks) Write() error {
+	for _, cur := range b.Curves {
+		f, err := os.Create(cur.Out(b.Out))
+		if err != nil {
+			return err
+		}
+
+		for i := range cur.Res {
+			fmt.Fprint(f, float64(i+1)*b.Dt, " ", cur.Res[i], " ", cur.Err[i])
+			for c := range Pairs {
+				fmt.Fprint(f, " ", cur.Tens[i][c])
+			}
+			fmt.Fprintln(f)
+		}
+
+		err = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteFit writes the diffusion coefficient of each block, their mean and
+// their standard error into FitOut. The name of the species is inserted in
+// FitOut if it isn't empty.
+func (b *Blocks) WriteFit() error {
+	for s, cur := range b.Curves {
+		f, err := os.Create(cur.Out(b.FitOut))
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(f, "Window", float64(cur.Start)*b.Dt, float64(cur.End)*b.Dt)
+		for i, m := range b.Blocks {
+			fit := m.Curves[s].Fit
+			fmt.Fprintln(f, "Block", i+1, fit.D, fit.DErr, fit.R2)
+		}
+		fmt.Fprintln(f, "D", cur.D, cur.DErr)
+
+		tens, tensErr := cur.Tensor, cur.TensorErr
+		fmt.Fprintln(f, "Tensor", tens.Comp[0], tens.Comp[1], tens.Comp[2], tens.Comp[3], tens.Comp[4], tens.Comp[5])
+		fmt.Fprintln(f, "TensorErr", tensErr[0], tensErr[1], tensErr[2], tensErr[3], tensErr[4], tensErr[5])
+		for i := 0; i < 3; i++ {
+			fmt.Fprintln(f, "Principal", tens.Eig[i], tens.Axes[i][0], tens.Axes[i][1], tens.Axes[i][2])
+		}
+
+		err = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,13 +1,553 @@
 package msd
 
-// Conv is a structure that will be used by the modules. It contains information
-// like the number of atoms, the number of molecules.
-type Conv struct {
-	Traj string
-	Out  string
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/kpotier/selfdiff/pkg/topo"
+	"github.com/kpotier/selfdiff/pkg/traj"
+)
+
+// Scheme is the unwrapping scheme of Conv
+type Scheme string
+
+// Here are the accepted schemes. Jump moves each atom by one cell vector
+// whenever it moves by more than half of the cell along this vector from one
+// configuration to the next. The corrections being accumulated with the box of
+// the configuration where the jumps occur, it distorts the diffusion if the
+// box fluctuates (NpT). TOR accumulates the displacements between consecutive
+// configurations, each one taken as its minimum image in the box of the
+// current configuration (see Kulke and Vermaas, J. Chem. Theory Comput. 18,
+// 6161 (2022)).
+var (
+	SJump Scheme = "jump"
+	STOR  Scheme = "tor"
+)
+
+// Unwrap is a traj.Reader which unwraps the positions x y z of Reader on the
+// fly into the columns xu yu zu (see Lammps documentation). The frames are
+// unwrapped in order from the first one of Reader, the state of the
+// unwrapping (a few vectors per atom) being saved regularly so that the frames
+// can be read again in any order. At most MaxSaved states are kept: the
+// spacing between them is doubled whenever this number is exceeded.
+type Unwrap struct {
+	Reader traj.Reader
+
+	Species []topo.Species
+	Dist    [3]float64 // Largest distance between two atoms in one molecule
 
-	At    int
-	Mol   int
+	// AtTot is the number of atoms of each frame. If it is equal to 0, it is
+	// the one of the first frame
 	AtTot int
-	Dist  [3]float64
+
+	// Select and ByMol select the atoms and group them into molecules like
+	// traj.COM. Only the molecules of the selected atoms are made whole, the
+	// other atoms being unwrapped one by one
+	Select *topo.Selection
+	ByMol  bool
+
+	// Bonds are the pairs of bonded atoms, given by their index in the order
+	// of the ids. If they are specified, they replace Dist to make the
+	// molecules whole
+	Bonds [][2]int
+
+	// Scheme is the unwrapping scheme. Default is SJump
+	Scheme Scheme
+
+	// Every is the initial number of frames between two saved states.
+	// Default is 100
+	Every int
+
+	// MaxSaved is the largest number of saved states. Default is 64
+	MaxSaved int
+
+	// Disagree is the number of atoms for which Scheme gave at least once
+	// another image than the image flags
+	Disagree int
+
+	cur   int          // Last frame unwrapped (state is the one following it)
+	done  int          // Number of frames unwrapped at least once
+	state state        // State following cur
+	every int          // Current number of frames between two saved states
+	saved []checkpoint // States preceding the frames 0, every, 2*every...
+}
+
+// checkpoint is a saved state preceding the frame frame.
+type checkpoint struct {
+	frame int
+	state state
+}
+
+// state is the state of the unwrapping following a frame.
+type state struct {
+	corr    [][3]float64 // Correction (incrementation)
+	lastXYZ [][3]float64 // Last configuration
+	lastW   [][3]float64 // Last configuration before its unwrapping
+	off     [][3]float64 // Shift between the image flags and Scheme
+	bad     []bool       // Atoms for which they disagree
+}
+
+// copy returns a deep copy of s.
+func (s state) copy() state {
+	vec := func(v [][3]float64) [][3]float64 {
+		if v == nil {
+			return nil
+		}
+		return append([][3]float64{}, v...)
+	}
+
+	c := state{corr: vec(s.corr), lastXYZ: vec(s.lastXYZ), lastW: vec(s.lastW), off: vec(s.off)}
+	if s.bad != nil {
+		c.bad = append([]bool{}, s.bad...)
+	}
+	return c
+}
+
+// Len is part of the traj.Reader interface.
+func (u *Unwrap) Len() (int, error) {
+	return u.Reader.Len()
+}
+
+// Frame is part of the traj.Reader interface. The molecules of the first
+// frame are made whole and the atoms of the following frames are unwrapped
+// according to Scheme, the minimum image being taken in fractional
+// coordinates. The box can be triclinic and vary between frames.
+//
+// If a frame has the image flags ix iy iz, its atoms are instead unwrapped
+// exactly with them (see traj.Frame.Unwrapped) and compared to Scheme. The
+// positions of a frame without cell (see traj.Frame.NoCell) are kept as they
+// are.
+func (u *Unwrap) Frame(i int) (*traj.Frame, error) {
+	if u.Every <= 0 {
+		u.Every = 100
+	}
+	if u.MaxSaved <= 1 {
+		u.MaxSaved = 64
+	}
+	if u.saved == nil {
+		u.cur, u.every, u.saved = -1, u.Every, []checkpoint{{}}
+	}
+
+	// The unwrapping restarts from the last state saved before i
+	if i <= u.cur {
+		k := sort.Search(len(u.saved), func(k int) bool { return u.saved[k].frame > i }) - 1
+		u.cur, u.state = u.saved[k].frame-1, u.saved[k].state.copy()
+	}
+
+	for {
+		f, err := u.next()
+		if err != nil || u.cur >= i {
+			return f, err
+		}
+	}
+}
+
+// Close is part of the traj.Reader interface.
+func (u *Unwrap) Close() error {
+	return u.Reader.Close()
+}
+
+// next unwraps the frame following cur.
+func (u *Unwrap) next() (*traj.Frame, error) {
+	i := u.cur + 1
+	f, err := u.Reader.Frame(i)
+	if err != nil {
+		return nil, err
+	}
+
+	if i == 0 && u.AtTot == 0 {
+		u.AtTot = f.Atoms
+	}
+	if f.Atoms != u.AtTot {
+		return nil, fmt.Errorf("frame %d: number of atoms don't match", i)
+	}
+
+	var xyz [3][]float64
+	for k, name := range [3]string{"x", "y", "z"} {
+		xyz[k] = f.Col(name)
+		if xyz[k] == nil {
+			return nil, fmt.Errorf("cannot find the columns x, y, and z")
+		}
+	}
+
+	// The positions of a frame without cell can't be wrapped
+	cell, nocell := f.Cell(), f.NoCell()
+	if !nocell && (cell[0][0] <= 0 || cell[1][1] <= 0 || cell[2][2] <= 0) {
+		return nil, fmt.Errorf("frame %d: invalid box", i)
+	}
+
+	// The atoms are paired by id if possible
+	index := order(f)
+	st := &u.state
+
+	if nocell {
+		st.corr, st.lastXYZ = make([][3]float64, u.AtTot), make([][3]float64, u.AtTot)
+		for p, a := range index {
+			for k := 0; k < 3; k++ {
+				st.lastXYZ[p][k] = xyz[k][a]
+			}
+		}
+	} else if i == 0 {
+		mols, err := u.molecules(f, index)
+		if err != nil {
+			return nil, err
+		}
+
+		st.corr = make([][3]float64, u.AtTot)
+		if u.Bonds != nil {
+			st.lastXYZ, err = u.walk(xyz, index, mols, cell)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			st.lastXYZ = u.whole(xyz, index, mols, cell)
+		}
+	} else if u.Scheme == STOR {
+		for p, a := range index {
+			var d [3]float64
+			for k := 0; k < 3; k++ {
+				d[k] = xyz[k][a] - st.lastW[p][k]
+			}
+
+			s := frac(d, cell)
+			for v := 0; v < 3; v++ {
+				if math.Abs(s[v]) <= 0.5 {
+					continue
+				}
+
+				n := math.Round(s[v])
+				for k := 0; k < 3; k++ {
+					d[k] -= n * cell[v][k]
+				}
+			}
+
+			for k := 0; k < 3; k++ {
+				st.lastXYZ[p][k] += d[k]
+			}
+		}
+	} else {
+		for p, a := range index {
+			var x, d [3]float64
+			for k := 0; k < 3; k++ {
+				x[k] = xyz[k][a] + st.corr[p][k]
+				d[k] = x[k] - st.lastXYZ[p][k]
+			}
+
+			s := frac(d, cell)
+			for v := 0; v < 3; v++ {
+				if math.Abs(s[v]) <= 0.5 {
+					continue
+				}
+
+				n := math.Round(s[v])
+				for k := 0; k < 3; k++ {
+					st.corr[p][k] -= n * cell[v][k]
+					x[k] -= n * cell[v][k]
+				}
+			}
+			st.lastXYZ[p] = x
+		}
+	}
+
+	// The image flags must be applied to the wrapped positions
+	var img [3][]float64
+	if !nocell {
+		img = f.Unwrapped()
+	}
+
+	if st.lastW == nil {
+		st.lastW = make([][3]float64, u.AtTot)
+	}
+	for p, a := range index {
+		for k := 0; k < 3; k++ {
+			st.lastW[p][k] = xyz[k][a]
+			xyz[k][a] = st.lastXYZ[p][k]
+		}
+	}
+
+	// The shift between the image flags and Scheme must remain the one of
+	// the first frame with image flags (within half of the cell)
+	if img[0] != nil {
+		if st.off == nil {
+			st.off, st.bad = make([][3]float64, u.AtTot), make([]bool, u.AtTot)
+			for p, a := range index {
+				for k := 0; k < 3; k++ {
+					st.off[p][k] = img[k][a] - xyz[k][a]
+				}
+			}
+		}
+
+		for p, a := range index {
+			var d [3]float64
+			for k := 0; k < 3; k++ {
+				d[k] = img[k][a] - xyz[k][a] - st.off[p][k]
+				xyz[k][a] = img[k][a]
+			}
+
+			s := frac(d, cell)
+			if !st.bad[p] && (math.Abs(s[0]) > 0.5 || math.Abs(s[1]) > 0.5 || math.Abs(s[2]) > 0.5) {
+				st.bad[p] = true
+				if i >= u.done {
+					u.Disagree++
+				}
+			}
+		}
+	}
+
+	for k, name := range [3]string{"x", "y", "z"} {
+		f.Rename(name, traj.Pos[k])
+	}
+
+	u.cur = i
+	if i >= u.done {
+		u.done = i + 1
+	}
+	if (i+1)%u.every == 0 && i+1 > u.saved[len(u.saved)-1].frame {
+		u.saved = append(u.saved, checkpoint{i + 1, u.state.copy()})
+		if len(u.saved) > u.MaxSaved {
+			u.thin()
+		}
+	}
+
+	return f, nil
+}
+
+// thin doubles the spacing between the saved states and drops the states which
+// are no longer on it, the state preceding the first frame being kept.
+func (u *Unwrap) thin() {
+	u.every *= 2
+
+	saved := make([]checkpoint, 1, u.MaxSaved+1)
+	saved[0] = u.saved[0]
+	for _, c := range u.saved[1:] {
+		if c.frame%u.every == 0 {
+			saved = append(saved, c)
+		}
+	}
+	u.saved = saved
+}
+
+// Conv is a structure that will be used by the modules. It writes the frames
+// unwrapped by Unwrap into Writer.
+type Conv struct {
+	Unwrap *Unwrap
+	Writer traj.Writer
+}
+
+// Perform performs the conversion.
+func (c *Conv) Perform() error {
+	for i := 0; ; i++ {
+		f, err := c.Unwrap.Frame(i)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		err = c.Writer.Write(f)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// molecules returns the molecules of the first configuration as the index of
+// their atoms in the order of the ids (see order). The selected atoms are
+// grouped into molecules by their order (see Species) or by their mol column
+// (see ByMol), each other atom being a molecule on its own.
+func (u *Unwrap) molecules(f *traj.Frame, index []int) ([][]int, error) {
+	id, typ, mol := f.Col("id"), f.Col("type"), f.Col("mol")
+	if u.Select != nil && f.Col(u.Select.Col) == nil {
+		return nil, fmt.Errorf("cannot find the column %s", u.Select.Col)
+	}
+	if u.ByMol && mol == nil {
+		return nil, fmt.Errorf("cannot find the column mol")
+	}
+
+	var mols, groups [][]int // Groups are the molecules of the selected atoms if ByMol
+	var sel []int            // Selected atoms if not ByMol
+	byMol := make(map[int]int)
+	for p, a := range index {
+		var at topo.Atom
+		if id != nil {
+			at.ID = int(id[a])
+		}
+		if typ != nil {
+			at.Type = int(typ[a])
+		}
+		if mol != nil {
+			at.Mol = int(mol[a])
+		}
+
+		switch {
+		case !u.Select.Match(at):
+			mols = append(mols, []int{p})
+		case u.ByMol:
+			m, ok := byMol[at.Mol]
+			if !ok {
+				m = len(groups)
+				byMol[at.Mol] = m
+				groups = append(groups, nil)
+			}
+			groups[m] = append(groups[m], p)
+		default:
+			sel = append(sel, p)
+		}
+	}
+
+	if u.ByMol {
+		return append(mols, groups...), nil
+	}
+
+	if len(sel) != topo.AtTot(u.Species) {
+		return nil, fmt.Errorf("number of atoms don't match")
+	}
+
+	var i int
+	for _, sp := range u.Species {
+		for m := 0; m < sp.Mol; m++ {
+			mols = append(mols, sel[i:i+sp.At])
+			i += sp.At
+		}
+	}
+
+	return mols, nil
+}
+
+// whole returns the positions of the first configuration in which each
+// molecule of mols is made whole: an atom is moved by one cell vector if it is
+// farther than Dist from the previous atom of its molecule along this vector
+// (the fractional distance multiplied by the length of the box).
+func (u *Unwrap) whole(xyz [3][]float64, index []int, mols [][]int, cell [3][3]float64) [][3]float64 {
+	lastXYZ := make([][3]float64, u.AtTot)
+
+	for _, m := range mols {
+		var lastXYZMol [3]float64
+
+		for at, p := range m {
+			a := index[p]
+			var x, d [3]float64
+			for k := 0; k < 3; k++ {
+				x[k] = xyz[k][a]
+				d[k] = lastXYZMol[k] - x[k]
+			}
+
+			if at != 0 {
+				s := frac(d, cell)
+				for v := 0; v < 3; v++ {
+					dist := s[v] * cell[v][v]
+					if dist > u.Dist[v] {
+						for k := 0; k < 3; k++ {
+							x[k] += cell[v][k]
+						}
+					} else if dist < -u.Dist[v] {
+						for k := 0; k < 3; k++ {
+							x[k] -= cell[v][k]
+						}
+					}
+				}
+			}
+			lastXYZMol = x
+
+			lastXYZ[p] = lastXYZMol
+		}
+	}
+
+	return lastXYZ
+}
+
+// walk returns the positions of the first configuration in which each molecule
+// of mols is made whole by walking its bonds: each atom is moved to the image
+// closest to the atom it is bonded to. An atom which isn't bonded to the
+// previous ones of its molecule is moved to the image closest to the first atom
+// of its molecule.
+func (u *Unwrap) walk(xyz [3][]float64, index []int, mols [][]int, cell [3][3]float64) ([][3]float64, error) {
+	lastXYZ := make([][3]float64, u.AtTot)
+	for p, a := range index {
+		for k := 0; k < 3; k++ {
+			lastXYZ[p][k] = xyz[k][a]
+		}
+	}
+
+	bonds := make([][]int, u.AtTot)
+	for _, b := range u.Bonds {
+		if b[0] < 0 || b[1] < 0 || b[0] >= u.AtTot || b[1] >= u.AtTot {
+			return nil, fmt.Errorf("invalid bond between the atoms %d and %d", b[0], b[1])
+		}
+		bonds[b[0]] = append(bonds[b[0]], b[1])
+		bonds[b[1]] = append(bonds[b[1]], b[0])
+	}
+
+	done := make([]bool, u.AtTot)
+	for _, m := range mols {
+		first := m[0]
+		for _, p := range m {
+			if done[p] {
+				continue
+			}
+
+			lastXYZ[p] = closest(lastXYZ[p], lastXYZ[first], cell)
+			done[p] = true
+
+			for queue := []int{p}; len(queue) > 0; queue = queue[1:] {
+				for _, n := range bonds[queue[0]] {
+					if !done[n] {
+						lastXYZ[n] = closest(lastXYZ[n], lastXYZ[queue[0]], cell)
+						done[n] = true
+						queue = append(queue, n)
+					}
+				}
+			}
+		}
+	}
+
+	return lastXYZ, nil
+}
+
+// closest returns the image of x closest to ref.
+func closest(x, ref [3]float64, cell [3][3]float64) [3]float64 {
+	var d [3]float64
+	for k := 0; k < 3; k++ {
+		d[k] = x[k] - ref[k]
+	}
+
+	s := frac(d, cell)
+	for v := 0; v < 3; v++ {
+		n := math.Round(s[v])
+		for k := 0; k < 3; k++ {
+			x[k] -= n * cell[v][k]
+		}
+	}
+	return x
+}
+
+// frac returns the fractional coordinates of the vector d in the cell whose
+// vectors a, b and c are the rows of cell (see traj.Frame.Cell).
+func frac(d [3]float64, cell [3][3]float64) (s [3]float64) {
+	s[2] = d[2] / cell[2][2]
+	s[1] = (d[1] - s[2]*cell[2][1]) / cell[1][1]
+	s[0] = (d[0] - s[1]*cell[1][0] - s[2]*cell[2][0]) / cell[0][0]
+	return
+}
+
+// order returns the index of the atoms of f sorted by id. The order of the
+// file is kept if there is no id column.
+func order(f *traj.Frame) []int {
+	index := make([]int, f.Atoms)
+	for a := range index {
+		index[a] = a
+	}
+
+	id := f.Col("id")
+	if id != nil {
+		sort.SliceStable(index, func(i, j int) bool { return id[index[i]] < id[index[j]] })
+	}
+
+	return index
 }
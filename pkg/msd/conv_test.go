@@ -0,0 +1,349 @@
+package msd
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/kpotier/selfdiff/pkg/topo"
+	"github.com/kpotier/selfdiff/pkg/traj"
+)
+
+// frames is a traj.Reader whose frames are in memory. Frame returns a copy
+// since Unwrap modifies the frames.
+type frames []*traj.Frame
+
+func (r frames) Len() (int, error) { return len(r), nil }
+func (r frames) Close() error      { return nil }
+
+func (r frames) Frame(i int) (*traj.Frame, error) {
+	if i < 0 || i >= len(r) {
+		return nil, fmt.Errorf("frame %d: %w", i, io.EOF)
+	}
+
+	f := *r[i]
+	f.Names = append([]string{}, f.Names...)
+	f.Cols = make(map[string][]float64)
+	for name, v := range r[i].Cols {
+		f.Cols[name] = append([]float64{}, v...)
+	}
+	return &f, nil
+}
+
+// wrapped returns a frame of the cell whose positions x y z are the ones of xyz
+// wrapped into the cell. The image flags ix iy iz are added if img is true.
+func wrapped(cell [3][3]float64, xyz [][3]float64, img bool) *traj.Frame {
+	f := &traj.Frame{Atoms: len(xyz), Cols: make(map[string][]float64)}
+	f.Box = [3][2]float64{{0, cell[0][0]}, {0, cell[1][1]}, {0, cell[2][2]}}
+	f.Tilt = [3]float64{cell[1][0], cell[2][0], cell[2][1]}
+
+	names := []string{"x", "y", "z"}
+	if img {
+		names = append(names, traj.Image[:]...)
+	}
+	for _, name := range names {
+		f.Cols[name] = make([]float64, len(xyz))
+	}
+	f.Names = names
+
+	for a, x := range xyz {
+		s := frac(x, cell)
+		for v := range s {
+			n := math.Floor(s[v])
+			for k := 0; k < 3; k++ {
+				x[k] -= n * cell[v][k]
+			}
+			if img {
+				f.Cols[traj.Image[v]][a] = n
+			}
+		}
+		for k, name := range [3]string{"x", "y", "z"} {
+			f.Cols[name][a] = x[k]
+		}
+	}
+	return f
+}
+
+// walk returns n configurations of a random walk of atoms atoms, each step
+// being smaller than step along each direction.
+func walk(rng *rand.Rand, n, atoms int, step float64) [][][3]float64 {
+	xyz := make([][][3]float64, n)
+	for i := range xyz {
+		xyz[i] = make([][3]float64, atoms)
+		for a := range xyz[i] {
+			for k := 0; k < 3; k++ {
+				if i == 0 {
+					xyz[i][a][k] = 20 * rng.Float64()
+				} else {
+					xyz[i][a][k] = xyz[i-1][a][k] + step*(2*rng.Float64()-1)
+				}
+			}
+		}
+	}
+	return xyz
+}
+
+// positions returns the unwrapped positions of the frame f.
+func positions(t *testing.T, f *traj.Frame) [][3]float64 {
+	xyz := make([][3]float64, f.Atoms)
+	for k, name := range traj.Pos {
+		v := f.Col(name)
+		if v == nil {
+			t.Fatalf("cannot find the column %s", name)
+		}
+		for a := range xyz {
+			xyz[a][k] = v[a]
+		}
+	}
+	return xyz
+}
+
+func TestUnwrapSeek(t *testing.T) {
+	const n, atoms = 300, 8
+	cell := [3][3]float64{{6, 0, 0}, {1, 5, 0}, {-1, 0.5, 7}}
+
+	rng := rand.New(rand.NewSource(1))
+	xyz := walk(rng, n, atoms, 1)
+	r := make(frames, n)
+	for i := range r {
+		r[i] = wrapped(cell, xyz[i], false)
+		r[i].Step = i
+	}
+
+	u := &Unwrap{Reader: r, Species: []topo.Species{{Mol: atoms, At: 1}}, Every: 3, MaxSaved: 5}
+
+	// The unwrapped positions are the true ones shifted into the first
+	// configuration
+	straight := make([][][3]float64, n)
+	for i := range straight {
+		f, err := u.Frame(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		straight[i] = positions(t, f)
+
+		for a := range xyz[i] {
+			for k := 0; k < 3; k++ {
+				want := xyz[i][a][k] - xyz[0][a][k] + r[0].Cols[[3]string{"x", "y", "z"}[k]][a]
+				if math.Abs(straight[i][a][k]-want) > 1e-9 {
+					t.Fatalf("frame %d: %s of atom %d = %g, want %g", i, traj.Pos[k], a, straight[i][a][k], want)
+				}
+			}
+		}
+	}
+
+	if len(u.saved) > u.MaxSaved {
+		t.Errorf("%d saved states, want at most %d", len(u.saved), u.MaxSaved)
+	}
+
+	for j := 0; j < 200; j++ {
+		i := rng.Intn(n)
+		f, err := u.Frame(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := positions(t, f)
+		for a := range got {
+			if got[a] != straight[i][a] {
+				t.Fatalf("frame %d read again: atom %d at %v, want %v", i, a, got[a], straight[i][a])
+			}
+		}
+	}
+}
+
+// shifted returns the positions xyz shifted by the vector from ref to to.
+func shifted(xyz [][3]float64, ref, to [3]float64) [][3]float64 {
+	s := make([][3]float64, len(xyz))
+	for a := range xyz {
+		for k := 0; k < 3; k++ {
+			s[a][k] = xyz[a][k] - ref[k] + to[k]
+		}
+	}
+	return s
+}
+
+// first returns the wrapped position of the atom a of the frame f.
+func first(f *traj.Frame, a int) (x [3]float64) {
+	for k, name := range [3]string{"x", "y", "z"} {
+		x[k] = f.Cols[name][a]
+	}
+	return
+}
+
+// compare fails if the positions got and want differ.
+func compare(t *testing.T, i int, got, want [][3]float64) {
+	t.Helper()
+	for a := range want {
+		for k := 0; k < 3; k++ {
+			if math.Abs(got[a][k]-want[a][k]) > 1e-9 {
+				t.Fatalf("frame %d: atom %d at %v, want %v", i, a, got[a], want[a])
+			}
+		}
+	}
+}
+
+func TestUnwrapSchemes(t *testing.T) {
+	const n, atoms = 100, 6
+	rect := func(int) [3][3]float64 { return [3][3]float64{{6, 0, 0}, {0, 5, 0}, {0, 0, 7}} }
+	tilted := func(int) [3][3]float64 { return [3][3]float64{{6, 0, 0}, {2, 5, 0}, {-1.5, 1, 7}} }
+	npt := func(i int) [3][3]float64 {
+		l := 1 + 0.05*math.Sin(float64(i))
+		return [3][3]float64{{6 * l, 0, 0}, {0, 5 * l, 0}, {0, 0, 7 * l}}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		scheme Scheme
+		cell   func(int) [3][3]float64
+	}{
+		{"jump", SJump, rect},
+		{"jump triclinic", SJump, tilted},
+		{"tor", STOR, rect},
+		{"tor triclinic", STOR, tilted},
+		{"tor npt", STOR, npt},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(2))
+			xyz := walk(rng, n, atoms, 1.2)
+
+			r := make(frames, n)
+			for i := range r {
+				r[i] = wrapped(tc.cell(i), xyz[i], false)
+			}
+
+			u := &Unwrap{Reader: r, Species: []topo.Species{{Mol: atoms, At: 1}}, Scheme: tc.scheme}
+
+			// With a fluctuating box, TOR sums the minimum images of the
+			// displacements between consecutive frames
+			var want [][3]float64
+			for i := range r {
+				f, err := u.Frame(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				cell := tc.cell(i)
+				switch {
+				case i == 0:
+					want = make([][3]float64, atoms)
+					for a := range want {
+						want[a] = first(r[0], a)
+					}
+				case tc.cell(0) != cell:
+					for a := range want {
+						d := first(r[i], a)
+						prev := first(r[i-1], a)
+						for k := 0; k < 3; k++ {
+							d[k] -= prev[k]
+							want[a][k] += d[k] - cell[k][k]*math.Round(d[k]/cell[k][k])
+						}
+					}
+				default:
+					for a := range want {
+						for k := 0; k < 3; k++ {
+							want[a][k] = xyz[i][a][k] - xyz[0][a][k] + r[0].Cols[[3]string{"x", "y", "z"}[k]][a]
+						}
+					}
+				}
+
+				compare(t, i, positions(t, f), want)
+			}
+		})
+	}
+}
+
+func TestUnwrapImages(t *testing.T) {
+	const n, atoms = 40, 4
+	cell := [3][3]float64{{6, 0, 0}, {2, 5, 0}, {-1.5, 1, 7}}
+
+	rng := rand.New(rand.NewSource(3))
+	xyz := walk(rng, n, atoms, 1)
+
+	// The atom 0 moves by more than half of the cell: the jump scheme misses
+	// it but the image flags don't
+	for i := n / 2; i < n; i++ {
+		xyz[i][0][0] += 4
+	}
+
+	r := make(frames, n)
+	for i := range r {
+		r[i] = wrapped(cell, xyz[i], true)
+	}
+
+	u := &Unwrap{Reader: r, Species: []topo.Species{{Mol: atoms, At: 1}}}
+	for i := range r {
+		f, err := u.Frame(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compare(t, i, positions(t, f), xyz[i])
+	}
+
+	if u.Disagree != 1 {
+		t.Errorf("%d atoms disagree with the image flags, want 1", u.Disagree)
+	}
+
+	// The warning is only counted once
+	_, err := u.Frame(n - 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = u.Frame(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Disagree != 1 {
+		t.Errorf("%d atoms disagree with the image flags after reading again, want 1", u.Disagree)
+	}
+}
+
+func TestUnwrapWhole(t *testing.T) {
+	cell := [3][3]float64{{6, 0, 0}, {2, 5, 0}, {-1.5, 1, 7}}
+
+	// A chain A-B-C-D stored in the order A, C, B, D and split across the
+	// faces of the cell, followed by a single atom. C is farther than Dist
+	// from A, which precedes it, so only the bonds make the chain whole
+	mol := [][3]float64{{5.5, 4.6, 0.2}, {8.3, 5.6, -0.4}, {6.9, 5.1, -0.1}, {9.7, 6.1, -0.7}, {3, 2, 3}}
+	bonds := [][2]int{{0, 2}, {2, 1}, {1, 3}}
+
+	for _, tc := range []struct {
+		name  string
+		mol   [][3]float64
+		dist  [3]float64
+		bonds [][2]int
+	}{
+		{"dist", [][3]float64{mol[0], mol[2], mol[1], mol[3], mol[4]}, [3]float64{1.8, 1.8, 1.8}, nil},
+		{"bonds", mol, [3]float64{1.8, 1.8, 1.8}, bonds},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// The molecule crosses the cell during the trajectory
+			const n = 30
+			xyz := make([][][3]float64, n)
+			r := make(frames, n)
+			for i := range r {
+				xyz[i] = make([][3]float64, len(tc.mol))
+				for a, x := range tc.mol {
+					xyz[i][a] = [3]float64{x[0] + 0.3*float64(i), x[1] - 0.2*float64(i), x[2] + 0.4*float64(i)}
+				}
+				r[i] = wrapped(cell, append([][3]float64{}, xyz[i]...), false)
+			}
+
+			// The first atom stays where it is in the first frame
+
+			u := &Unwrap{Reader: r, Species: []topo.Species{{Mol: 1, At: 4}, {Mol: 1, At: 1}}, Dist: tc.dist, Bonds: tc.bonds}
+			start := first(r[0], 0)
+			for i := range r {
+				f, err := u.Frame(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				got := positions(t, f)
+				compare(t, i, got[:4], shifted(xyz[i], xyz[0][0], start)[:4])
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package msd
+
+import (
+	"fmt"
+
+	"github.com/kpotier/selfdiff/pkg/fft"
+)
+
+// performFFT performs the mean squared displacement using the fast Fourier
+// transform. Each component of the tensor is split into
+// S1(m) = sum a(k+m)*b(k+m) + a(k)*b(k) and
+// S2(m) = sum a(k)*b(k+m) + b(k)*a(k+m), the latter being a correlation
+// computed in O(N log N). The unnormalized results are identical to the direct
+// method.
+func (m *MSD) performFFT() error {
+	// Trajectory of the center of mass of each molecule. pos[mol][k][t]
+	pos := make([][3][]float64, m.MolTot)
+	for mol := range pos {
+		for k := 0; k < 3; k++ {
+			pos[mol][k] = make([]float64, m.Tot)
+		}
+	}
+
+	for i := 0; i < m.Tot; i++ {
+		fmt.Print("\r> Reading ", i+1, "/", m.Tot)
+
+		cfg, err := m.Method.GetCfg(i)
+		if err != nil {
+			return err
+		}
+
+		for mol := 0; mol < m.MolTot; mol++ {
+			for k := 0; k < 3; k++ {
+				pos[mol][k][i] = cfg[mol][k]
+			}
+		}
+	}
+
+	for mol := 0; mol < m.MolTot; mol++ {
+		fmt.Print("\r> Molecule ", mol+1, "/", m.MolTot)
+
+		cur := m.Curves[0]
+		for _, c := range m.Curves {
+			if mol >= c.First {
+				cur = c
+			}
+		}
+
+		var tr [3][]complex128
+		for k := 0; k < 3; k++ {
+			tr[k] = fft.Transform(pos[mol][k])
+		}
+
+		for c, p := range Pairs {
+			x, y := pos[mol][p[0]], pos[mol][p[1]]
+			s2 := fft.CorrSym(tr[p[0]], tr[p[1]], m.Tot)
+
+			var s1 float64
+			for t := range x {
+				s1 += 2 * x[t] * y[t]
+			}
+
+			for lag := 1; lag < m.Tot; lag++ {
+				s1 -= x[lag-1]*y[lag-1] + x[m.Tot-lag]*y[m.Tot-lag]
+				cur.Tens[lag-1][c] += s1 - s2[lag]
+			}
+		}
+	}
+
+	fmt.Print("\033[2K\033[1G")
+	return nil
+}
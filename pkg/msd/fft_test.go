@@ -0,0 +1,72 @@
+package msd
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/kpotier/selfdiff/pkg/topo"
+)
+
+// memory is a traj.Method whose configurations are in memory.
+type memory [][][3]float64
+
+func (m memory) Read() error                        { return nil }
+func (m memory) GetCfg(i int) ([][3]float64, error) { return m[i], nil }
+func (m memory) End() error                         { return nil }
+
+// random returns a random walk of n configurations of mol molecules.
+func random(n, mol int) memory {
+	rng := rand.New(rand.NewSource(1))
+
+	m := make(memory, n)
+	for i := range m {
+		m[i] = make([][3]float64, mol)
+		for j := range m[i] {
+			for k := 0; k < 3; k++ {
+				m[i][j][k] = 10 * rng.Float64()
+				if i > 0 {
+					m[i][j][k] = m[i-1][j][k] + rng.NormFloat64()
+				}
+			}
+		}
+	}
+	return m
+}
+
+func TestFFT(t *testing.T) {
+	const n = 37 // Not a power of 2
+	species := []topo.Species{{Name: "a", Mol: 3, At: 1}, {Name: "b", Mol: 5, At: 1}}
+	cfgs := random(n, topo.MolTot(species))
+
+	for _, dims := range [][]int{nil, {0, 2}} {
+		var res [2]*MSD
+		for k, fft := range []bool{false, true} {
+			res[k] = &MSD{Method: cfgs, End: n, Mem: n, Species: species, Dims: dims, FFT: fft}
+			err := res[k].Perform()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for c, direct := range res[0].Curves {
+			fft := res[1].Curves[c]
+			for i := range direct.Res {
+				if !equal(fft.Res[i], direct.Res[i]) {
+					t.Errorf("dims %v, species %s: Res[%d] = %g (FFT), want %g", dims, direct.Name, i, fft.Res[i], direct.Res[i])
+				}
+				for p := range Pairs {
+					if !equal(fft.Tens[i][p], direct.Tens[i][p]) {
+						t.Errorf("dims %v, species %s: Tens[%d][%d] = %g (FFT), want %g", dims, direct.Name, i, p, fft.Tens[i][p], direct.Tens[i][p])
+					}
+				}
+			}
+		}
+	}
+}
+
+// equal returns true if a and b are equal up to the rounding errors of the
+// fast Fourier transform.
+func equal(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Abs(b))
+}
@@ -0,0 +1,133 @@
+package msd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kpotier/selfdiff/pkg/fit"
+)
+
+// Tol is the tolerance on the local exponent of the MSD (which is equal to 1
+// in the diffusive regime) used to detect the diffusive regime.
+const Tol = 0.1
+
+// Fit contains the result of the Einstein fit. Start and End are the first and
+// last lags of the window. D is the self diffusion coefficient and DErr its
+// standard error, both in unit of length^2/unit of Dt. Tensor is the diffusion
+// tensor fitted over the same window.
+type Fit struct {
+	fit.Line
+
+	Start  int
+	End    int
+	D      float64
+	DErr   float64
+	Tensor Tensor
+}
+
+// Einstein fits a line over the diffusive regime of the mean squared
+// displacement of each species and determines its self diffusion coefficient
+// from the Einstein relation <r^2> = 2*d*D*t, d being the number of dimensions
+// in Dims. Res being already divided by d, D = slope/2. Perform must be called
+// beforehand.
+func (m *MSD) Einstein() error {
+	for _, cur := range m.Curves {
+		err := cur.einstein(m.Dt, m.FitStart, m.FitEnd)
+		if err != nil {
+			if cur.Name != "" {
+				return fmt.Errorf("species %s: %w", cur.Name, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// einstein performs the Einstein fit of the curve (see MSD.Einstein).
+func (c *Curve) einstein(dt float64, fitStart, fitEnd int) error {
+	t, start, end, err := window(c.Res, dt, fitStart, fitEnd)
+	if err != nil {
+		return err
+	}
+
+	l, err := fit.Linear(t[start:end], c.Res[start:end])
+	if err != nil {
+		return fmt.Errorf("Linear: %w", err)
+	}
+
+	res := Fit{Line: l, Start: start + 1, End: end, D: l.Slope / 2., DErr: l.SlopeErr / 2.}
+
+	// Diffusion tensor. <dr_a*dr_b> = 2*D_ab*t
+	y := make([]float64, end-start)
+	for p := range Pairs {
+		for i := range y {
+			y[i] = c.Tens[start+i][p]
+		}
+
+		l, err := fit.Linear(t[start:end], y)
+		if err != nil {
+			return fmt.Errorf("Linear: %w", err)
+		}
+		res.Tensor.Comp[p] = l.Slope / 2.
+	}
+	res.Tensor.eigen()
+
+	// The fit is only kept if it succeeded (see WriteH5MD)
+	c.Fit = res
+	return nil
+}
+
+// window returns the times of the curve res and the window [start, end) of
+// indices used to fit it. If fitStart and fitEnd are equal to 0, the window is
+// the diffusive regime of res. Otherwise, fitStart and fitEnd are the first
+// and last lags of the window.
+func window(res []float64, dt float64, fitStart, fitEnd int) (t []float64, start, end int, err error) {
+	t = make([]float64, len(res))
+	for i := range t {
+		t[i] = float64(i+1) * dt
+	}
+
+	start, end = fitStart-1, fitEnd
+	if fitStart == 0 && fitEnd == 0 {
+		start, end, err = fit.Diffusive(t, res, Tol)
+		if err != nil {
+			err = fmt.Errorf("Diffusive: %w", err)
+			return
+		}
+	}
+
+	if start < 0 || end > len(t) || end <= start {
+		err = fmt.Errorf("invalid fit window")
+	}
+	return
+}
+
+// WriteFit writes the result of the Einstein fit into FitOut. The name of the
+// species is inserted in FitOut if it isn't empty (see topo.Species.Out).
+func (m *MSD) WriteFit() error {
+	for _, cur := range m.Curves {
+		f, err := os.Create(cur.Out(m.FitOut))
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(f, "Window", float64(cur.Fit.Start)*m.Dt, float64(cur.Fit.End)*m.Dt)
+		fmt.Fprintln(f, "Slope", cur.Fit.Slope, cur.Fit.SlopeErr)
+		fmt.Fprintln(f, "Intercept", cur.Fit.Intercept)
+		fmt.Fprintln(f, "R2", cur.Fit.R2)
+		fmt.Fprintln(f, "D", cur.Fit.D, cur.Fit.DErr)
+
+		tens := cur.Fit.Tensor
+		fmt.Fprintln(f, "Tensor", tens.Comp[0], tens.Comp[1], tens.Comp[2], tens.Comp[3], tens.Comp[4], tens.Comp[5])
+		for i := 0; i < 3; i++ {
+			fmt.Fprintln(f, "Principal", tens.Eig[i], tens.Axes[i][0], tens.Axes[i][1], tens.Axes[i][2])
+		}
+
+		err = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package msd
+
+import (
+	"github.com/kpotier/selfdiff/pkg/hdf5"
+	"github.com/kpotier/selfdiff/pkg/traj/h5md"
+)
+
+// components are the names of the components of the tensors (see Pairs).
+var components = []string{"xx", "yy", "zz", "xy", "xz", "yz"}
+
+// WriteH5MD adds the mean squared displacement of each species to the
+// observables of the H5MD file w (see h5md.Create), in the group msd of the
+// species. The datasets lag, value and tensor are the columns of Out and the
+// attributes D, D_error, fit_window, R2 and diffusion_tensor the result of the
+// Einstein fit. The attributes are omitted if the curve isn't fitted (e.g:
+// Einstein failed).
+func (m *MSD) WriteH5MD(w *hdf5.Writer) {
+	for _, cur := range m.Curves {
+		g := h5md.Observables(w, cur.Name).Group("msd")
+		curve(g, m.Dt, cur.Res, cur.Tens)
+		if cur.Fit.End == 0 {
+			continue
+		}
+
+		g.Attr("D", cur.Fit.D).Attr("D_error", cur.Fit.DErr)
+		g.Attr("fit_window", []float64{float64(cur.Fit.Start) * m.Dt, float64(cur.Fit.End) * m.Dt})
+		g.Attr("R2", cur.Fit.R2)
+		g.Attr("diffusion_tensor", cur.Fit.Tensor.Comp[:])
+	}
+}
+
+// WriteH5MD adds the block average of the mean squared displacement of each
+// species to the observables of the H5MD file w (see MSD.WriteH5MD). The
+// dataset error is the standard error of the blocks and the attribute D_blocks
+// the diffusion coefficient of each block. The attributes are omitted if the
+// curve isn't fitted (see MSD.WriteH5MD).
+func (b *Blocks) WriteH5MD(w *hdf5.Writer) {
+	for s, cur := range b.Curves {
+		g := h5md.Observables(w, cur.Name).Group("msd")
+		curve(g, b.Dt, cur.Res, cur.Tens)
+		g.Dataset("error", []int{len(cur.Err)}, cur.Err)
+		if cur.End == 0 {
+			continue
+		}
+
+		d := make([]float64, len(b.Blocks))
+		for i, m := range b.Blocks {
+			d[i] = m.Curves[s].Fit.D
+		}
+
+		g.Attr("D", cur.D).Attr("D_error", cur.DErr).Attr("D_blocks", d)
+		g.Attr("fit_window", []float64{float64(cur.Start) * b.Dt, float64(cur.End) * b.Dt})
+		g.Attr("diffusion_tensor", cur.Tensor.Comp[:]).Attr("diffusion_tensor_error", cur.TensorErr[:])
+	}
+}
+
+// curve adds the lags, the mean squared displacement res and the displacement
+// tensor tens to the group g.
+func curve(g *hdf5.Object, dt float64, res []float64, tens [][6]float64) {
+	lag := make([]float64, len(res))
+	for i := range lag {
+		lag[i] = float64(i+1) * dt
+	}
+
+	t := make([]float64, 0, 6*len(tens))
+	for _, v := range tens {
+		t = append(t, v[:]...)
+	}
+
+	g.Dataset("lag", []int{len(lag)}, lag)
+	g.Dataset("value", []int{len(res)}, res)
+	g.Dataset("tensor", []int{len(tens), 6}, t).Attr("components", components)
+}
@@ -3,23 +3,20 @@ package msd
 import (
 	"fmt"
 	"os"
-)
 
-// Method is an interface that will be used by the modules.
-type Method interface {
-	Read() error
-	GetCfg(int) ([][3]float64, error)
-	End() error
-}
+	"github.com/kpotier/selfdiff/pkg/topo"
+	"github.com/kpotier/selfdiff/pkg/traj"
+)
 
 // MSD structure is a structure containing information that will be used by the
 // modules. It contains the position of the first configuration, the position of
 // the last configuration, etc.
 type MSD struct {
-	Method Method
+	Method traj.Method
 
-	Traj string
-	Out  string
+	Traj   string
+	Out    string
+	FitOut string
 
 	Start int
 	End   int
@@ -28,27 +25,93 @@ type MSD struct {
 	Tot    int
 	MemPos int // Position of the configurations that are in the memory
 	AtTot  int
+	MolTot int
+
+	// Species are the kinds of molecules in one configuration. A mean squared
+	// displacement is calculated for each of them
+	Species []topo.Species
+	Dt      float64
+
+	// Dims are the dimensions (0 for x, 1 for y and 2 for z) included in the
+	// mean squared displacement. Default is all of them
+	Dims []int
+
+	// FFT specifies if the fast Fourier transform algorithm must be used
+	// instead of the direct (quadratic) one
+	FFT bool
+
+	// FitStart and FitEnd are the first and last lags (in number of
+	// configurations) used to fit the diffusion coefficient. If both are
+	// equal to 0, the diffusive regime is detected automatically
+	FitStart int
+	FitEnd   int
+
+	Curves []*Curve // One for each species
+}
+
+// Curve is the mean squared displacement of one species. Res is the mean
+// squared displacement divided by the number of dimensions and Tens the
+// displacement tensor <dr_a*dr_b> (see Pairs) for each lag.
+type Curve struct {
+	topo.Species
 
-	At     int
-	Mol    int
-	Masses []float64
-	Dt     float64
+	First int // Index of the first molecule of the species
 
-	Res []float64
+	Res  []float64
+	Tens [][6]float64
+	Fit  Fit
 }
 
 // Perform performs the mean squared displacement.
 func (m *MSD) Perform() (err error) {
 	m.Tot = m.End - m.Start
-	m.Res = make([]float64, m.Tot-1)
-	m.AtTot = m.At * m.Mol
+	m.AtTot = topo.AtTot(m.Species)
+	m.MolTot = topo.MolTot(m.Species)
 	m.MemPos = m.Tot - m.Mem
+	if len(m.Dims) == 0 {
+		m.Dims = []int{0, 1, 2}
+	}
+
+	m.Curves = nil
+	var first int
+	for _, sp := range m.Species {
+		m.Curves = append(m.Curves, &Curve{Species: sp, First: first, Res: make([]float64, m.Tot-1), Tens: make([][6]float64, m.Tot-1)})
+		first += sp.Mol
+	}
 
+	defer m.Method.End()
 	err = m.Method.Read()
 	if err != nil {
 		return
 	}
 
+	if m.FFT {
+		err = m.performFFT()
+	} else {
+		err = m.performDirect()
+	}
+	if err != nil {
+		return
+	}
+
+	for _, cur := range m.Curves {
+		for i := 0; i < m.Tot-1; i++ {
+			for c := range Pairs {
+				cur.Tens[i][c] /= float64((m.Tot - 1 - i) * cur.Mol)
+			}
+			for _, k := range m.Dims {
+				cur.Res[i] += cur.Tens[i][k]
+			}
+			cur.Res[i] /= float64(len(m.Dims))
+		}
+	}
+
+	return
+}
+
+// performDirect performs the mean squared displacement by looping over every
+// pair of configurations. The results are not normalized.
+func (m *MSD) performDirect() (err error) {
 	for i := 0; i < m.Tot-1; i++ {
 		fmt.Print("\r> Step ", i+1, "/", m.Tot-1)
 
@@ -66,10 +129,16 @@ func (m *MSD) Perform() (err error) {
 				return
 			}
 
-			for mol := 0; mol < m.Mol; mol++ {
-				for k := 0; k < 3; k++ {
-					pow := icfg[mol][k] - tcfg[mol][k]
-					m.Res[j-i-1] += pow * pow
+			for _, cur := range m.Curves {
+				for mol := cur.First; mol < cur.First+cur.Mol; mol++ {
+					var d [3]float64
+					for k := 0; k < 3; k++ {
+						d[k] = icfg[mol][k] - tcfg[mol][k]
+					}
+
+					for c, p := range Pairs {
+						cur.Tens[j-i-1][c] += d[p[0]] * d[p[1]]
+					}
 				}
 			}
 		}
@@ -79,16 +148,27 @@ func (m *MSD) Perform() (err error) {
 	return
 }
 
-// Write writes the results into Out.
+// Write writes the results into Out. The name of the species is inserted in
+// Out if it isn't empty (see topo.Species.Out).
 func (m *MSD) Write() error {
-	f, err := os.Create(m.Out)
-	if err != nil {
-		return err
-	}
+	for _, cur := range m.Curves {
+		f, err := os.Create(cur.Out(m.Out))
+		if err != nil {
+			return err
+		}
 
-	for i := 0; i < m.Tot-1; i++ {
-		m.Res[i] /= float64((m.Tot - 1 - i) * m.Mol * 3)
-		fmt.Fprintln(f, float64(i+1)*m.Dt, m.Res[i])
+		for i := 0; i < m.Tot-1; i++ {
+			fmt.Fprint(f, float64(i+1)*m.Dt, " ", cur.Res[i])
+			for c := range Pairs {
+				fmt.Fprint(f, " ", cur.Tens[i][c])
+			}
+			fmt.Fprintln(f)
+		}
+
+		err = f.Close()
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -0,0 +1,84 @@
+package msd
+
+import "math"
+
+// Pairs are the Cartesian components of the displacement tensor in the order
+// they are stored: xx, yy, zz, xy, xz, yz.
+var Pairs = [6][2]int{{0, 0}, {1, 1}, {2, 2}, {0, 1}, {0, 2}, {1, 2}}
+
+// Tensor is a symmetric 3x3 diffusion tensor. Comp are its components (see
+// Pairs). Eig are the principal diffusivities in ascending order and Axes the
+// corresponding principal axes (Axes[i] is the eigenvector of Eig[i]).
+type Tensor struct {
+	Comp [6]float64
+	Eig  [3]float64
+	Axes [3][3]float64
+}
+
+// eigen performs the eigen decomposition of the tensor using the cyclic Jacobi
+// method.
+func (t *Tensor) eigen() {
+	var a, v [3][3]float64
+	for c, p := range Pairs {
+		a[p[0]][p[1]] = t.Comp[c]
+		a[p[1]][p[0]] = t.Comp[c]
+	}
+	for i := 0; i < 3; i++ {
+		v[i][i] = 1
+	}
+
+	for sweep := 0; sweep < 50; sweep++ {
+		off := a[0][1]*a[0][1] + a[0][2]*a[0][2] + a[1][2]*a[1][2]
+		if off == 0 {
+			break
+		}
+
+		for p := 0; p < 2; p++ {
+			for q := p + 1; q < 3; q++ {
+				if a[p][q] == 0 {
+					continue
+				}
+
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				tan := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					tan = -tan
+				}
+				cos := 1 / math.Sqrt(tan*tan+1)
+				sin := tan * cos
+
+				for k := 0; k < 3; k++ {
+					akp, akq := a[k][p], a[k][q]
+					a[k][p] = cos*akp - sin*akq
+					a[k][q] = sin*akp + cos*akq
+				}
+				for k := 0; k < 3; k++ {
+					apk, aqk := a[p][k], a[q][k]
+					a[p][k] = cos*apk - sin*aqk
+					a[q][k] = sin*apk + cos*aqk
+				}
+				for k := 0; k < 3; k++ {
+					vkp, vkq := v[k][p], v[k][q]
+					v[k][p] = cos*vkp - sin*vkq
+					v[k][q] = sin*vkp + cos*vkq
+				}
+			}
+		}
+	}
+
+	idx := [3]int{0, 1, 2}
+	for i := 0; i < 3; i++ {
+		for j := i + 1; j < 3; j++ {
+			if a[idx[j]][idx[j]] < a[idx[i]][idx[i]] {
+				idx[i], idx[j] = idx[j], idx[i]
+			}
+		}
+	}
+
+	for i, k := range idx {
+		t.Eig[i] = a[k][k]
+		for j := 0; j < 3; j++ {
+			t.Axes[i][j] = v[j][k]
+		}
+	}
+}
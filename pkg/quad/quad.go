@@ -0,0 +1,49 @@
+package quad
+
+// Rule is a quadrature rule
+type Rule string
+
+// Here are the accepted rules. Trapezoid is the trapezoidal rule. Simpson is
+// the composite Simpson's rule (the 3/8 rule is used for the last three
+// intervals if the number of intervals is odd).
+var (
+	Trapezoid Rule = "trapezoid"
+	Simpson   Rule = "simpson"
+)
+
+// Running returns the running integral of y sampled with the step h: the i-th
+// element of the returned slice is the integral of y from 0 to i*h.
+func Running(y []float64, h float64, r Rule) []float64 {
+	if r == Simpson {
+		return runningSimpson(y, h)
+	}
+	return runningTrapezoid(y, h)
+}
+
+// runningTrapezoid returns the running integral using the trapezoidal rule.
+func runningTrapezoid(y []float64, h float64) []float64 {
+	res := make([]float64, len(y))
+	for i := 1; i < len(y); i++ {
+		res[i] = res[i-1] + h*(y[i-1]+y[i])/2.
+	}
+	return res
+}
+
+// runningSimpson returns the running integral using the composite Simpson's
+// rule. For an odd number of intervals i, the integral from 0 to (i-3)*h is
+// computed using the Simpson's rule and the three remaining intervals using
+// the Simpson's 3/8 rule. With a single interval, the trapezoidal rule is used.
+func runningSimpson(y []float64, h float64) []float64 {
+	res := make([]float64, len(y))
+	for i := 1; i < len(y); i++ {
+		switch {
+		case i%2 == 0:
+			res[i] = res[i-2] + h*(y[i-2]+4*y[i-1]+y[i])/3.
+		case i == 1:
+			res[i] = h * (y[0] + y[1]) / 2.
+		default:
+			res[i] = res[i-3] + 3*h*(y[i-3]+3*y[i-2]+3*y[i-1]+y[i])/8.
+		}
+	}
+	return res
+}
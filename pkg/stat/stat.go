@@ -0,0 +1,28 @@
+package stat
+
+import "math"
+
+// MeanErr returns the mean of x and its standard error, i.e. the standard
+// deviation of x divided by the square root of the number of samples. The
+// standard error is equal to 0 if there is less than 2 samples.
+func MeanErr(x []float64) (mean, err float64) {
+	n := float64(len(x))
+	if n == 0 {
+		return
+	}
+
+	for _, v := range x {
+		mean += v
+	}
+	mean /= n
+
+	if n < 2 {
+		return
+	}
+
+	for _, v := range x {
+		err += (v - mean) * (v - mean)
+	}
+	err = math.Sqrt(err / (n - 1) / n)
+	return
+}
@@ -0,0 +1,133 @@
+package topo
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Atom is an atom of a configuration. ID, Type and Mol are equal to 0 if they
+// are not available in the trajectory. XYZ is a vector quantity (position or
+// velocity) used to determine the centers of mass.
+type Atom struct {
+	ID   int
+	Type int
+	Mol  int
+	XYZ  [3]float64
+}
+
+// COM returns the center of mass of each molecule of the species sp. If byMol
+// is false, the atoms are grouped into molecules by their order: the first
+// At atoms form the first molecule and so on. Otherwise, the atoms sharing the
+// same Mol form a molecule, the molecules being sorted by Mol and the atoms of
+// one molecule being kept in their order.
+func COM(sp []Species, atoms []Atom, byMol bool) ([][3]float64, error) {
+	if !byMol {
+		if len(atoms) != AtTot(sp) {
+			return nil, fmt.Errorf("number of atoms don't match")
+		}
+
+		var (
+			xyz [][3]float64
+			i   int
+		)
+		for _, s := range sp {
+			for mol := 0; mol < s.Mol; mol++ {
+				xyz = append(xyz, com(s, atoms[i:i+s.At]))
+				i += s.At
+			}
+		}
+		return xyz, nil
+	}
+
+	mols := make(map[int][]Atom)
+	var ids []int
+	for _, a := range atoms {
+		if _, ok := mols[a.Mol]; !ok {
+			ids = append(ids, a.Mol)
+		}
+		mols[a.Mol] = append(mols[a.Mol], a)
+	}
+	sort.Ints(ids)
+
+	if len(ids) != MolTot(sp) {
+		return nil, fmt.Errorf("number of molecules don't match")
+	}
+
+	var (
+		xyz [][3]float64
+		i   int
+	)
+	for _, s := range sp {
+		for mol := 0; mol < s.Mol; mol++ {
+			at := mols[ids[i]]
+			if len(at) != s.At {
+				return nil, fmt.Errorf("molecule %d: number of atoms don't match", ids[i])
+			}
+
+			xyz = append(xyz, com(s, at))
+			i++
+		}
+	}
+	return xyz, nil
+}
+
+// com returns the center of mass of one molecule of the species s.
+func com(s Species, atoms []Atom) (xyz [3]float64) {
+	var mTot float64
+	for a, at := range atoms {
+		for k := 0; k < 3; k++ {
+			xyz[k] += at.XYZ[k] * s.Masses[a]
+		}
+		mTot += s.Masses[a]
+	}
+
+	for k := 0; k < 3; k++ {
+		xyz[k] /= mTot
+	}
+	return
+}
+
+// Sort sorts the atoms by ID. Lammps writes the atoms in an arbitrary order
+// unless dump_modify sort id is used.
+func Sort(atoms []Atom) {
+	less := func(i, j int) bool { return atoms[i].ID < atoms[j].ID }
+	if !sort.SliceIsSorted(atoms, less) {
+		sort.SliceStable(atoms, less)
+	}
+}
+
+// Order checks that the atoms are in the same order in every configuration
+// when they are not sorted by ID. The ID, Type and Mol of the atoms of the
+// first configuration checked are used as a reference, so a permutation of the
+// IDs is detected directly. A reordering can't be detected if the atoms have
+// none of them, or between atoms sharing the same Type and Mol if they have no
+// ID.
+type Order struct {
+	ref [][3]int
+}
+
+// Check returns an error if the ID, Type or Mol of the atoms differ from the
+// reference.
+func (o *Order) Check(atoms []Atom) error {
+	if o.ref == nil {
+		o.ref = make([][3]int, len(atoms))
+		for i, a := range atoms {
+			o.ref[i] = [3]int{a.ID, a.Type, a.Mol}
+		}
+		return nil
+	}
+
+	if len(atoms) != len(o.ref) {
+		return fmt.Errorf("number of atoms don't match")
+	}
+
+	for i, a := range atoms {
+		if a.ID != o.ref[i][0] {
+			return fmt.Errorf("the order of the atoms changed (atom %d instead of %d)", a.ID, o.ref[i][0])
+		}
+		if o.ref[i] != [3]int{a.ID, a.Type, a.Mol} {
+			return fmt.Errorf("the order of the atoms changed and the column id is missing (use dump_modify sort id)")
+		}
+	}
+	return nil
+}
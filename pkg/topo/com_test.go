@@ -0,0 +1,111 @@
+package topo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCOM(t *testing.T) {
+	// Water (O H H) and argon, the hydrogens being lighter than the oxygen
+	sp := []Species{
+		{Name: "h2o", Mol: 2, At: 3, Masses: []float64{16, 1, 1}},
+		{Name: "ar", Mol: 1, At: 1, Masses: []float64{40}},
+	}
+
+	// The second water molecule and the argon are shuffled when the atoms are
+	// grouped by their mol
+	ordered := []Atom{
+		{Mol: 1, XYZ: [3]float64{0, 0, 0}},
+		{Mol: 1, XYZ: [3]float64{1.8, 0, 0}},
+		{Mol: 1, XYZ: [3]float64{0, 1.8, 0}},
+		{Mol: 2, XYZ: [3]float64{5, 5, 5}},
+		{Mol: 2, XYZ: [3]float64{5, 5, 6.8}},
+		{Mol: 2, XYZ: [3]float64{5, 5, 3.2}},
+		{Mol: 3, XYZ: [3]float64{-1, 2, 3}},
+	}
+	shuffled := []Atom{ordered[3], ordered[6], ordered[0], ordered[4], ordered[1], ordered[2], ordered[5]}
+	want := [][3]float64{{0.1, 0.1, 0}, {5, 5, 5}, {-1, 2, 3}}
+
+	for _, tc := range []struct {
+		name  string
+		atoms []Atom
+		byMol bool
+	}{
+		{"order", ordered, false},
+		{"mol", ordered, true},
+		{"shuffled mol", shuffled, true},
+	} {
+		got, err := COM(sp, tc.atoms, tc.byMol)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s: %d centers of mass, want %d", tc.name, len(got), len(want))
+		}
+		for m := range want {
+			for k := 0; k < 3; k++ {
+				if math.Abs(got[m][k]-want[m][k]) > 1e-12 {
+					t.Errorf("%s: molecule %d at %v, want %v", tc.name, m, got[m], want[m])
+					break
+				}
+			}
+		}
+	}
+
+	// Missing atom, molecule of the wrong size and missing molecule
+	for _, tc := range []struct {
+		name  string
+		atoms []Atom
+		byMol bool
+	}{
+		{"order", ordered[:6], false},
+		{"mol", append([]Atom{{Mol: 3}}, ordered[1:]...), true},
+		{"missing mol", ordered[:6], true},
+	} {
+		_, err := COM(sp, tc.atoms, tc.byMol)
+		if err == nil {
+			t.Errorf("%s: COM accepted %d atoms", tc.name, len(tc.atoms))
+		}
+	}
+}
+
+func TestSort(t *testing.T) {
+	atoms := []Atom{{ID: 3}, {ID: 1, Type: 1}, {ID: 2}, {ID: 1, Type: 2}}
+	Sort(atoms)
+	for k, want := range []Atom{{ID: 1, Type: 1}, {ID: 1, Type: 2}, {ID: 2}, {ID: 3}} {
+		if atoms[k] != want {
+			t.Fatalf("Sort: %v", atoms)
+		}
+	}
+}
+
+func TestOrder(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		ref, next []Atom
+		ok        bool
+	}{
+		{"same", []Atom{{Type: 1}, {Type: 2}}, []Atom{{Type: 1}, {Type: 2}}, true},
+		{"type", []Atom{{Type: 1}, {Type: 2}}, []Atom{{Type: 2}, {Type: 1}}, false},
+		{"mol", []Atom{{Mol: 1}, {Mol: 2}}, []Atom{{Mol: 2}, {Mol: 1}}, false},
+		{"id", []Atom{{ID: 1}, {ID: 2}}, []Atom{{ID: 2}, {ID: 1}}, false},
+		{"id same type", []Atom{{ID: 1, Type: 1}, {ID: 2, Type: 1}}, []Atom{{ID: 2, Type: 1}, {ID: 1, Type: 1}}, false},
+		{"number", []Atom{{Type: 1}, {Type: 2}}, []Atom{{Type: 1}}, false},
+
+		// Undetectable without id, type and mol
+		{"nothing", []Atom{{XYZ: [3]float64{1}}, {}}, []Atom{{}, {XYZ: [3]float64{1}}}, true},
+	} {
+		var o Order
+		err := o.Check(tc.ref)
+		if err != nil {
+			t.Fatalf("%s: reference: %v", tc.name, err)
+		}
+
+		err = o.Check(tc.next)
+		if tc.ok && err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		} else if !tc.ok && err == nil {
+			t.Errorf("%s: the reordering isn't detected", tc.name)
+		}
+	}
+}
@@ -0,0 +1,502 @@
+// Package lammpsdata reads the topology of Lammps data files: the atoms, their
+// masses and the bonds between them.
+package lammpsdata
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kpotier/selfdiff/pkg/topo"
+	"github.com/kpotier/selfdiff/pkg/traj"
+)
+
+// Atom is an atom of a data file. Mol is equal to 0 if the atom style has no
+// molecule (atomic or charge). Mass is equal to 0 if it is unknown (see
+// FromFrame).
+type Atom struct {
+	ID   int
+	Mol  int
+	Type int
+	Mass float64
+}
+
+// Topology is the topology of a data file or of a frame (see FromFrame).
+type Topology struct {
+	Atoms  []Atom          // Sorted by ID
+	Bonds  [][2]int        // IDs of the bonded atoms
+	Masses map[int]float64 // Mass of each type
+}
+
+// styles are the columns of the atom styles which are supported, before the
+// positions. The atom style is given by the comment of the Atoms section
+// (e.g: Atoms # full). Otherwise, it is guessed from the number of columns.
+var styles = map[string][]string{
+	"atomic":    {"id", "type"},
+	"charge":    {"id", "type", "q"},
+	"bond":      {"id", "mol", "type"},
+	"angle":     {"id", "mol", "type"},
+	"molecular": {"id", "mol", "type"},
+	"full":      {"id", "mol", "type", "q"},
+}
+
+// Read reads the data file name. The sections Masses, Atoms and Bonds are
+// read and the other ones are skipped.
+func Read(name string) (*Topology, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	t := &Topology{Masses: make(map[int]float64)}
+	var (
+		section, style string
+		bonds          int // Number of bonds given by the header
+		line           int
+	)
+
+	r := bufio.NewReader(f)
+	for {
+		l, err := r.ReadString('\n')
+		if err == io.EOF && l == "" {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line++
+
+		// The first line is a comment
+		if line == 1 {
+			continue
+		}
+
+		var comment string
+		if i := strings.IndexByte(l, '#'); i >= 0 {
+			l, comment = l[:i], strings.TrimSpace(l[i+1:])
+		}
+		fields := strings.Fields(l)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// A section starts with its name
+		if _, err := strconv.ParseFloat(fields[0], 64); err != nil {
+			section, style = strings.Join(fields, " "), comment
+			continue
+		}
+
+		switch section {
+		case "": // Header
+			if len(fields) == 2 && fields[1] == "bonds" {
+				bonds, _ = strconv.Atoi(fields[0])
+			}
+		case "Masses":
+			err = t.mass(fields)
+		case "Atoms":
+			err = t.atom(fields, style, bonds)
+		case "Bonds":
+			err = t.bond(fields)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: line %d: %s: %w", name, line, section, err)
+		}
+	}
+
+	if len(t.Atoms) == 0 {
+		return nil, fmt.Errorf("%s: no atoms", name)
+	}
+
+	sort.Slice(t.Atoms, func(i, j int) bool { return t.Atoms[i].ID < t.Atoms[j].ID })
+	for k, at := range t.Atoms {
+		if k > 0 && t.Atoms[k-1].ID == at.ID {
+			return nil, fmt.Errorf("%s: atom %d specified twice", name, at.ID)
+		}
+
+		m, ok := t.Masses[at.Type]
+		if !ok {
+			return nil, fmt.Errorf("%s: no mass for the type %d", name, at.Type)
+		}
+		t.Atoms[k].Mass = m
+	}
+
+	return t, nil
+}
+
+// FromFrame returns the topology of the atoms of the frame f: their id, mol and
+// type columns (their index from 1 and 0 if they are missing) and their mass
+// column. If f has no mass column, the masses are given by the masses of the
+// types (0 if they are unknown).
+func FromFrame(f *traj.Frame, masses map[int]float64) *Topology {
+	t := &Topology{Atoms: make([]Atom, f.Atoms), Masses: masses}
+	id, mol, typ, mass := f.Col("id"), f.Col("mol"), f.Col("type"), f.Col("mass")
+	for a := range t.Atoms {
+		at := Atom{ID: a + 1}
+		if id != nil {
+			at.ID = int(id[a])
+		}
+		if mol != nil {
+			at.Mol = int(mol[a])
+		}
+		if typ != nil {
+			at.Type = int(typ[a])
+		}
+
+		if mass != nil {
+			at.Mass = mass[a]
+		} else {
+			at.Mass = masses[at.Type]
+		}
+		t.Atoms[a] = at
+	}
+
+	if id != nil {
+		sort.SliceStable(t.Atoms, func(i, j int) bool { return t.Atoms[i].ID < t.Atoms[j].ID })
+	}
+	return t
+}
+
+// mass reads a line of the section Masses.
+func (t *Topology) mass(fields []string) error {
+	if len(fields) < 2 {
+		return fmt.Errorf("invalid mass")
+	}
+
+	typ, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return fmt.Errorf("invalid type")
+	}
+	m, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || m <= 0 {
+		return fmt.Errorf("invalid mass")
+	}
+
+	t.Masses[typ] = m
+	return nil
+}
+
+// atom reads a line of the section Atoms with the atom style style. If style
+// is empty, it is guessed from the number of columns: 6 columns (and 3 image
+// flags) are a molecular style if the data file has bonds or if its third
+// column is an integer.
+func (t *Topology) atom(fields []string, style string, bonds int) error {
+	cols, ok := styles[style]
+	if style != "" && !ok {
+		return fmt.Errorf("unsupported atom style %s", style)
+	}
+
+	if style == "" {
+		n := len(fields)
+		if n >= 8 {
+			n -= 3 // Image flags
+		}
+
+		switch n {
+		case 5:
+			cols = styles["atomic"]
+		case 6:
+			cols = styles["charge"]
+			if _, err := strconv.Atoi(fields[2]); bonds > 0 || err == nil {
+				cols = styles["molecular"]
+			}
+		case 7:
+			cols = styles["full"]
+		default:
+			return fmt.Errorf("unable to guess the atom style (use Atoms # style)")
+		}
+	}
+
+	if len(fields) < len(cols)+3 {
+		return fmt.Errorf("invalid atom")
+	}
+
+	var at Atom
+	for k, c := range cols {
+		v, err := strconv.Atoi(fields[k])
+		switch c {
+		case "id":
+			at.ID = v
+		case "mol":
+			at.Mol = v
+		case "type":
+			at.Type = v
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s", c)
+		}
+	}
+
+	t.Atoms = append(t.Atoms, at)
+	return nil
+}
+
+// bond reads a line of the section Bonds.
+func (t *Topology) bond(fields []string) error {
+	if len(fields) < 4 {
+		return fmt.Errorf("invalid bond")
+	}
+
+	var b [2]int
+	for k := range b {
+		var err error
+		b[k], err = strconv.Atoi(fields[k+2])
+		if err != nil {
+			return fmt.Errorf("invalid bond")
+		}
+	}
+
+	t.Bonds = append(t.Bonds, b)
+	return nil
+}
+
+// Pairs returns the bonds as the indexes of the bonded atoms in Atoms.
+func (t *Topology) Pairs() ([][2]int, error) {
+	index := t.index()
+
+	pairs := make([][2]int, len(t.Bonds))
+	for k, b := range t.Bonds {
+		for j := range b {
+			i, ok := index[b[j]]
+			if !ok {
+				return nil, fmt.Errorf("bond %d: unknown atom %d", k+1, b[j])
+			}
+			pairs[k][j] = i
+		}
+	}
+	return pairs, nil
+}
+
+// index returns the index in Atoms of each ID.
+func (t *Topology) index() map[int]int {
+	index := make(map[int]int, len(t.Atoms))
+	for i, at := range t.Atoms {
+		index[at.ID] = i
+	}
+	return index
+}
+
+// Species returns the species of the molecules of the atoms selected by sel
+// (every atom if it is nil), which are contiguous kinds of molecules (same
+// types and masses). The masses must be known unless each molecule is a
+// single atom, its mass being then 1. The molecules are sorted by Mol if byMol
+// is true (see topo.COM), otherwise their atoms must be contiguous in the order
+// of the IDs. The atoms without molecule (Mol equal to 0) are molecules of
+// their own. A species is named after the order in which its kind appears,
+// followed by its position among the species if this kind appears several
+// times (e.g: 1, 2 and 1_3). It is unnamed if there is a single species.
+func (t *Topology) Species(byMol bool, sel *topo.Selection) ([]topo.Species, error) {
+	atoms := t.selected(byMol, sel)
+	if len(atoms) == 0 {
+		return nil, fmt.Errorf("no atom selected")
+	}
+
+	// Atoms of each molecule
+	var mols [][]Atom
+	if byMol {
+		for k, at := range atoms {
+			if k == 0 || atoms[k-1].Mol != at.Mol {
+				mols = append(mols, nil)
+			}
+			mols[len(mols)-1] = append(mols[len(mols)-1], at)
+		}
+	} else {
+		seen := make(map[int]bool)
+		for k, at := range atoms {
+			if at.Mol != 0 && k > 0 && atoms[k-1].Mol == at.Mol {
+				mols[len(mols)-1] = append(mols[len(mols)-1], at)
+				continue
+			}
+
+			if at.Mol != 0 && seen[at.Mol] {
+				return nil, fmt.Errorf("the atoms of the molecule %d are not contiguous (use byMol)", at.Mol)
+			}
+			seen[at.Mol] = true
+			mols = append(mols, []Atom{at})
+		}
+	}
+
+	var (
+		sp    []topo.Species
+		kinds []string // Kind of each species
+	)
+	for _, mol := range mols {
+		var kind string
+		masses := make([]float64, len(mol))
+		for k, at := range mol {
+			kind = fmt.Sprint(kind, at.Type, " ")
+			masses[k] = at.Mass
+
+			if at.Mass == 0 && len(mol) > 1 {
+				return nil, fmt.Errorf("unknown mass of the atom %d", at.ID)
+			} else if at.Mass == 0 {
+				masses[k] = 1
+			}
+		}
+
+		if len(sp) > 0 && kinds[len(kinds)-1] == kind {
+			sp[len(sp)-1].Mol++
+			continue
+		}
+		sp = append(sp, topo.Species{Mol: 1, At: len(mol), Masses: masses})
+		kinds = append(kinds, kind)
+	}
+
+	if len(sp) == 1 {
+		return sp, nil
+	}
+
+	names := make(map[string]string) // Name of each kind
+	seen := make(map[string]bool)
+	for k := range sp {
+		name, ok := names[kinds[k]]
+		if !ok {
+			name = strconv.Itoa(len(names) + 1)
+			names[kinds[k]] = name
+		}
+		if seen[name] {
+			name = fmt.Sprint(name, "_", k+1)
+		}
+		seen[name] = true
+		sp[k].Name = name
+	}
+
+	return sp, nil
+}
+
+// Check checks the species sp against the atoms selected by sel (every atom if
+// it is nil), grouped into molecules as Species does: their number, their
+// molecules if the atoms have a Mol and their masses if they are known (within
+// 1%).
+func (t *Topology) Check(sp []topo.Species, byMol bool, sel *topo.Selection) error {
+	atoms := t.selected(byMol, sel)
+	if len(atoms) != topo.AtTot(sp) {
+		return fmt.Errorf("the number of atoms (%d) doesn't match the species (%d)", len(atoms), topo.AtTot(sp))
+	}
+
+	var a int
+	for _, s := range sp {
+		var prefix string
+		if s.Name != "" {
+			prefix = fmt.Sprint("species ", s.Name, ": ")
+		}
+
+		for m := 0; m < s.Mol; m++ {
+			first := atoms[a]
+			if a > 0 && first.Mol != 0 && atoms[a-1].Mol == first.Mol {
+				return fmt.Errorf("%smolecule %d: the atom %d belongs to the previous molecule", prefix, m+1, first.ID)
+			}
+
+			for k := 0; k < s.At; k, a = k+1, a+1 {
+				at := atoms[a]
+				if at.Mol != first.Mol {
+					return fmt.Errorf("%smolecule %d: the atom %d doesn't belong to the molecule %d", prefix, m+1, at.ID, first.Mol)
+				}
+
+				if at.Mass != 0 && math.Abs(s.Masses[k]-at.Mass) > 0.01*at.Mass {
+					return fmt.Errorf("%smolecule %d: the mass of the atom %d (%g) doesn't match %g", prefix, m+1, at.ID, at.Mass, s.Masses[k])
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// selected returns the atoms selected by sel, sorted by Mol if byMol is true.
+func (t *Topology) selected(byMol bool, sel *topo.Selection) []Atom {
+	var atoms []Atom
+	for _, at := range t.Atoms {
+		if sel.Match(topo.Atom{ID: at.ID, Type: at.Type, Mol: at.Mol}) {
+			atoms = append(atoms, at)
+		}
+	}
+
+	if byMol {
+		sort.SliceStable(atoms, func(i, j int) bool { return atoms[i].Mol < atoms[j].Mol })
+	}
+	return atoms
+}
+
+// Reader adds the columns id, mol, type and mass of Topology to the frames of
+// Reader which don't have them. The atoms of the frames are matched with the
+// ones of Topology by id, or by order if the frames have no column id.
+type Reader struct {
+	Reader   traj.Reader
+	Topology *Topology
+
+	index map[int]int
+}
+
+// Len is part of the traj.Reader interface.
+func (r *Reader) Len() (int, error) {
+	return r.Reader.Len()
+}
+
+// Frame is part of the traj.Reader interface.
+func (r *Reader) Frame(i int) (*traj.Frame, error) {
+	f, err := r.Reader.Frame(i)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Atoms != len(r.Topology.Atoms) {
+		return nil, fmt.Errorf("frame %d: number of atoms don't match the topology", i)
+	}
+
+	atoms := r.Topology.Atoms
+	if id := f.Col("id"); id != nil {
+		if r.index == nil {
+			r.index = r.Topology.index()
+		}
+
+		atoms = make([]Atom, f.Atoms)
+		for a := range atoms {
+			k, ok := r.index[int(id[a])]
+			if !ok {
+				return nil, fmt.Errorf("frame %d: atom %d not found in the topology", i, int(id[a]))
+			}
+			atoms[a] = r.Topology.Atoms[k]
+		}
+	}
+
+	for _, name := range [4]string{"id", "mol", "type", "mass"} {
+		if f.Col(name) != nil {
+			continue
+		}
+
+		col := make([]float64, f.Atoms)
+		for a, at := range atoms {
+			switch name {
+			case "id":
+				col[a] = float64(at.ID)
+			case "mol":
+				col[a] = float64(at.Mol)
+			case "type":
+				col[a] = float64(at.Type)
+			case "mass":
+				col[a] = at.Mass
+			}
+		}
+
+		if f.Cols == nil {
+			f.Cols = make(map[string][]float64)
+		}
+		f.Cols[name] = col
+		f.Names = append(f.Names, name)
+	}
+
+	return f, nil
+}
+
+// Close is part of the traj.Reader interface.
+func (r *Reader) Close() error {
+	return r.Reader.Close()
+}
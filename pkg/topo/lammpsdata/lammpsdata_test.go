@@ -0,0 +1,405 @@
+package lammpsdata
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/kpotier/selfdiff/pkg/topo"
+	"github.com/kpotier/selfdiff/pkg/traj"
+)
+
+// write writes the data file data into the directory dir and returns its name.
+func write(t *testing.T, dir, data string) string {
+	name := filepath.Join(dir, "data.lmp")
+	err := ioutil.WriteFile(name, []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// header is the header and the Masses section of the data files: water
+// (types 1 and 2) and argon (type 3).
+const header = `LAMMPS data file
+
+7 atoms
+%s
+3 atom types
+
+0 20 xlo xhi
+0 20 ylo yhi
+0 20 zlo zhi
+
+Masses
+
+1 15.9994 # O
+2 1.008
+3 39.948
+`
+
+func TestRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lammpsdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The atoms are unsorted. The argon is between the two water molecules
+	// in the order of the IDs
+	full := []Atom{
+		{1, 1, 1, 15.9994}, {2, 1, 2, 1.008}, {3, 1, 2, 1.008},
+		{4, 3, 3, 39.948},
+		{5, 2, 1, 15.9994}, {6, 2, 2, 1.008}, {7, 2, 2, 1.008},
+	}
+	atomic := make([]Atom, len(full))
+	for k, at := range full {
+		at.Mol = 0
+		atomic[k] = at
+	}
+
+	for _, tc := range []struct {
+		name, header, data string
+		atoms              []Atom
+		bonds              [][2]int
+	}{
+		{"full", "4 bonds", `
+Atoms # full
+
+5 2 1 -0.8 10 10 10
+1 1 1 -0.8 1 1 1
+2 1 2 0.4 1.5 1 1
+3 1 2 0.4 1 1.5 1 0 0 0
+4 3 3 0 5 5 5
+6 2 2 0.4 10.5 10 10
+7 2 2 0.4 10 10.5 10
+
+Bonds
+
+1 1 1 2
+2 1 1 3
+3 1 5 6
+4 1 5 7
+`, full, [][2]int{{1, 2}, {1, 3}, {5, 6}, {5, 7}}},
+
+		// The style is guessed: full, molecular (image flags or bonds) and
+		// atomic or charge (no bonds)
+		{"full guessed", "0 bonds", `
+Atoms
+
+1 1 1 -0.8 1 1 1 0 1 0
+2 1 2 0.4 1.5 1 1
+3 1 2 0.4 1 1.5 1
+4 3 3 0 5 5 5
+5 2 1 -0.8 10 10 10
+6 2 2 0.4 10.5 10 10
+7 2 2 0.4 10 10.5 10
+`, full, nil},
+		{"molecular", "4 bonds", `
+Atoms # molecular
+
+1 1 1 1 1 1
+2 1 2 1.5 1 1
+3 1 2 1 1.5 1
+4 3 3 5 5 5
+5 2 1 10 10 10
+6 2 2 10.5 10 10
+7 2 2 10 10.5 10 -1 0 2
+`, full, nil},
+		{"molecular guessed", "4 bonds", `
+Atoms
+
+1 1 1 1 1 1
+2 1 2 1.5 1 1
+3 1 2 1 1.5 1 0 0 0
+4 3 3 5 5 5
+5 2 1 10 10 10
+6 2 2 10.5 10 10
+7 2 2 10 10.5 10
+`, full, nil},
+		{"atomic", "", `
+Atoms # atomic
+
+1 1 1 1 1
+2 2 1.5 1 1
+3 2 1 1.5 1
+4 3 5 5 5
+5 1 10 10 10 1 1 1
+6 2 10.5 10 10
+7 2 10 10.5 10
+`, atomic, nil},
+		{"charge guessed", "0 bonds", `
+Atoms
+
+1 1 -0.8 1 1 1
+2 2 0.4 1.5 1 1
+3 2 0.4 1 1.5 1
+4 3 0.0 5 5 5
+5 1 -0.8 10 10 10
+6 2 0.4 10.5 10 10
+7 2 0.4 10 10.5 10
+`, atomic, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			top, err := Read(write(t, dir, fmt.Sprintf(header, tc.header)+tc.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(top.Atoms, tc.atoms) {
+				t.Errorf("Atoms = %v, want %v", top.Atoms, tc.atoms)
+			}
+			if !reflect.DeepEqual(top.Bonds, tc.bonds) {
+				t.Errorf("Bonds = %v, want %v", top.Bonds, tc.bonds)
+			}
+			if m := map[int]float64{1: 15.9994, 2: 1.008, 3: 39.948}; !reflect.DeepEqual(top.Masses, m) {
+				t.Errorf("Masses = %v, want %v", top.Masses, m)
+			}
+		})
+	}
+
+	for _, tc := range []struct{ name, data string }{
+		{"style", "\nAtoms # sphere\n\n1 1 1 1 1 1 1\n"},
+		{"guess", "\nAtoms\n\n1 1 1 1\n"},
+		{"id", "\nAtoms # atomic\n\na 1 1 1 1\n"},
+		{"mass", "\nAtoms # atomic\n\n1 4 1 1 1\n"},
+		{"twice", "\nAtoms # atomic\n\n1 1 1 1 1\n1 2 1 1 1\n"},
+		{"no atoms", ""},
+	} {
+		_, err := Read(write(t, dir, fmt.Sprintf(header, "")+tc.data))
+		if err == nil {
+			t.Errorf("%s: Read accepted the data file", tc.name)
+		}
+	}
+}
+
+// topology returns a topology of two water molecules around an argon atom
+// (in the order of the IDs) followed by a third water molecule whose atoms
+// are interleaved with the ones of the second one.
+func topology() *Topology {
+	o, h, ar := 15.9994, 1.008, 39.948
+	return &Topology{
+		Atoms: []Atom{
+			{1, 1, 1, o}, {2, 1, 2, h}, {3, 1, 2, h},
+			{4, 3, 3, ar},
+			{5, 2, 1, o}, {6, 2, 2, h}, {7, 2, 2, h},
+			{8, 4, 1, o}, {9, 5, 1, o}, {10, 4, 2, h}, {11, 5, 2, h}, {12, 4, 2, h}, {13, 5, 2, h},
+		},
+		Bonds:  [][2]int{{1, 2}, {1, 3}, {5, 7}},
+		Masses: map[int]float64{1: o, 2: h, 3: ar},
+	}
+}
+
+func TestSpecies(t *testing.T) {
+	o, h, ar := 15.9994, 1.008, 39.948
+	water := []float64{o, h, h}
+
+	mol, err := topo.ParseSelection("mol 1 2 4 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	typ, err := topo.ParseSelection("type 1 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := topo.ParseSelection("id 1-7")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		byMol bool
+		sel   *topo.Selection
+		want  []topo.Species
+	}{
+		{"by mol", true, nil, []topo.Species{
+			{Name: "1", Mol: 2, At: 3, Masses: water},
+			{Name: "2", Mol: 1, At: 1, Masses: []float64{ar}},
+			{Name: "1_3", Mol: 2, At: 3, Masses: water},
+		}},
+		{"by order", false, ids, []topo.Species{
+			{Name: "1", Mol: 1, At: 3, Masses: water},
+			{Name: "2", Mol: 1, At: 1, Masses: []float64{ar}},
+			{Name: "1_3", Mol: 1, At: 3, Masses: water},
+		}},
+		{"water", true, mol, []topo.Species{{Mol: 4, At: 3, Masses: water}}},
+		{"atoms", false, typ, []topo.Species{
+			{Name: "1", Mol: 1, At: 1, Masses: []float64{o}},
+			{Name: "2", Mol: 1, At: 1, Masses: []float64{ar}},
+			{Name: "1_3", Mol: 3, At: 1, Masses: []float64{o}},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			top := topology()
+			sp, err := top.Species(tc.byMol, tc.sel)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(sp, tc.want) {
+				t.Fatalf("Species = %v, want %v", sp, tc.want)
+			}
+
+			err = top.Check(sp, tc.byMol, tc.sel)
+			if err != nil {
+				t.Errorf("Check: %v", err)
+			}
+		})
+	}
+
+	// The atoms of the molecules 4 and 5 are interleaved
+	_, err = topology().Species(false, nil)
+	if err == nil {
+		t.Errorf("Species accepted the interleaved molecules without byMol")
+	}
+
+	// The masses are unknown
+	top := topology()
+	for k := range top.Atoms {
+		top.Atoms[k].Mass = 0
+	}
+	_, err = top.Species(true, nil)
+	if err == nil {
+		t.Errorf("Species accepted the molecules of unknown masses")
+	}
+	sp, err := top.Species(false, typ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sp[0].Masses[0] != 1 {
+		t.Errorf("single atom of unknown mass: Masses = %v, want [1]", sp[0].Masses)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	o, h, ar := 15.9994, 1.008, 39.948
+	water := []float64{o, h, h}
+
+	for _, tc := range []struct {
+		name string
+		sp   []topo.Species
+		ok   bool
+	}{
+		{"ok", []topo.Species{
+			{Name: "a", Mol: 2, At: 3, Masses: water},
+			{Name: "b", Mol: 1, At: 1, Masses: []float64{ar}},
+			{Name: "c", Mol: 2, At: 3, Masses: []float64{16, 1, 1}}, // Within 1%
+		}, true},
+		{"number", []topo.Species{{Mol: 4, At: 3, Masses: water}}, false},
+		{"split", []topo.Species{
+			{Name: "a", Mol: 1, At: 2, Masses: []float64{o, h}},
+			{Name: "b", Mol: 1, At: 1, Masses: []float64{h}},
+			{Name: "c", Mol: 1, At: 3, Masses: water},
+			{Name: "d", Mol: 1, At: 1, Masses: []float64{ar}},
+			{Name: "e", Mol: 2, At: 3, Masses: water},
+		}, false},
+		{"merged", []topo.Species{
+			{Name: "a", Mol: 1, At: 4, Masses: []float64{o, h, h, ar}},
+			{Name: "b", Mol: 3, At: 3, Masses: water},
+		}, false},
+		{"mass", []topo.Species{
+			{Name: "a", Mol: 2, At: 3, Masses: []float64{h, h, o}},
+			{Name: "b", Mol: 1, At: 1, Masses: []float64{ar}},
+			{Name: "c", Mol: 2, At: 3, Masses: water},
+		}, false},
+	} {
+		err := topology().Check(tc.sp, true, nil)
+		if tc.ok && err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		} else if !tc.ok && err == nil {
+			t.Errorf("%s: Check accepted the species", tc.name)
+		}
+	}
+}
+
+func TestPairs(t *testing.T) {
+	top := topology()
+	pairs, err := top.Pairs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][2]int{{0, 1}, {0, 2}, {4, 6}}; !reflect.DeepEqual(pairs, want) {
+		t.Errorf("Pairs = %v, want %v", pairs, want)
+	}
+
+	top.Bonds = append(top.Bonds, [2]int{1, 20})
+	_, err = top.Pairs()
+	if err == nil {
+		t.Errorf("Pairs accepted a bond with an unknown atom")
+	}
+}
+
+// frame returns a frame of the atoms of IDs ids (none if it is nil) and of the
+// other columns cols.
+func frame(ids []float64, cols map[string][]float64) *traj.Frame {
+	f := &traj.Frame{Atoms: 3, Cols: make(map[string][]float64)}
+	if ids != nil {
+		f.Cols["id"] = ids
+		f.Names = append(f.Names, "id")
+	}
+	for name, v := range cols {
+		f.Cols[name] = v
+		f.Names = append(f.Names, name)
+	}
+	return f
+}
+
+// frames is a traj.Reader of frames in memory.
+type frames []*traj.Frame
+
+func (r frames) Len() (int, error)                { return len(r), nil }
+func (r frames) Frame(i int) (*traj.Frame, error) { return r[i], nil }
+func (r frames) Close() error                     { return nil }
+
+func TestFromFrame(t *testing.T) {
+	masses := map[int]float64{1: 12, 2: 1}
+
+	f := frame([]float64{3, 1, 2}, map[string][]float64{"type": {2, 1, 2}, "mol": {1, 1, 1}})
+	top := FromFrame(f, masses)
+	if want := []Atom{{1, 1, 1, 12}, {2, 1, 2, 1}, {3, 1, 2, 1}}; !reflect.DeepEqual(top.Atoms, want) {
+		t.Errorf("Atoms = %v, want %v", top.Atoms, want)
+	}
+
+	// The mass column is preferred, the IDs are the indexes
+	f = frame(nil, map[string][]float64{"type": {2, 1, 2}, "mass": {2, 14, 2}})
+	top = FromFrame(f, masses)
+	if want := []Atom{{1, 0, 2, 2}, {2, 0, 1, 14}, {3, 0, 2, 2}}; !reflect.DeepEqual(top.Atoms, want) {
+		t.Errorf("Atoms = %v, want %v", top.Atoms, want)
+	}
+}
+
+func TestReader(t *testing.T) {
+	top := &Topology{Atoms: []Atom{{1, 1, 1, 12}, {2, 1, 2, 1}, {3, 2, 3, 40}}}
+
+	r := &Reader{Reader: frames{
+		frame([]float64{3, 1, 2}, nil),
+		frame(nil, nil),
+		frame([]float64{2, 3, 1}, map[string][]float64{"type": {7, 8, 9}}),
+		frame([]float64{1, 2, 4}, nil),
+	}, Topology: top}
+
+	for i, want := range []map[string][]float64{
+		{"id": {3, 1, 2}, "mol": {2, 1, 1}, "type": {3, 1, 2}, "mass": {40, 12, 1}},
+		{"id": {1, 2, 3}, "mol": {1, 1, 2}, "type": {1, 2, 3}, "mass": {12, 1, 40}},
+		{"id": {2, 3, 1}, "mol": {1, 2, 1}, "type": {7, 8, 9}, "mass": {1, 40, 12}},
+	} {
+		f, err := r.Frame(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(f.Cols, want) {
+			t.Errorf("frame %d: Cols = %v, want %v", i, f.Cols, want)
+		}
+		if len(f.Names) != 4 {
+			t.Errorf("frame %d: Names = %v, want 4 columns", i, f.Names)
+		}
+	}
+
+	_, err := r.Frame(3)
+	if err == nil {
+		t.Errorf("Frame accepted an atom missing from the topology")
+	}
+}
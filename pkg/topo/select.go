@@ -0,0 +1,74 @@
+package topo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Selection selects atoms according to the value of one of their integer
+// columns (id, type or mol). An atom is selected if the value of the column Col
+// is inside one of the ranges Ranges (bounds included).
+type Selection struct {
+	Col    string
+	Ranges [][2]int
+}
+
+// ParseSelection parses a selection such as "type 1 2" or "mol 1-500 600". The
+// first word is the column (id, type or mol) and the following ones are
+// values or ranges of values.
+func ParseSelection(s string) (*Selection, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("a column and at least one value are required")
+	}
+
+	sel := &Selection{Col: fields[0]}
+	if sel.Col != "id" && sel.Col != "type" && sel.Col != "mol" {
+		return nil, fmt.Errorf("unsupported column %s", sel.Col)
+	}
+
+	for _, f := range fields[1:] {
+		bounds := strings.SplitN(f, "-", 2)
+
+		lo, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %s", f)
+		}
+
+		hi := lo
+		if len(bounds) == 2 {
+			hi, err = strconv.Atoi(bounds[1])
+			if err != nil || hi < lo {
+				return nil, fmt.Errorf("invalid range %s", f)
+			}
+		}
+
+		sel.Ranges = append(sel.Ranges, [2]int{lo, hi})
+	}
+
+	return sel, nil
+}
+
+// Match returns true if the atom a is selected. A nil selection selects every
+// atom.
+func (s *Selection) Match(a Atom) bool {
+	if s == nil {
+		return true
+	}
+
+	v := a.Type
+	switch s.Col {
+	case "id":
+		v = a.ID
+	case "mol":
+		v = a.Mol
+	}
+
+	for _, r := range s.Ranges {
+		if v >= r[0] && v <= r[1] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package topo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSelection(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want *Selection
+	}{
+		{"type 1 2", &Selection{"type", [][2]int{{1, 1}, {2, 2}}}},
+		{"mol 1-500 600", &Selection{"mol", [][2]int{{1, 500}, {600, 600}}}},
+		{"id  3-3\t7-9 ", &Selection{"id", [][2]int{{3, 3}, {7, 9}}}},
+		{"type", nil},
+		{"", nil},
+		{"element 1", nil},
+		{"type a", nil},
+		{"mol 5-2", nil},
+		{"mol 1-b", nil},
+	} {
+		got, err := ParseSelection(tc.s)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("ParseSelection(%q) = %v, want an error", tc.s, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseSelection(%q): %v", tc.s, err)
+		} else if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ParseSelection(%q) = %v, want %v", tc.s, got, tc.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	at := Atom{ID: 12, Type: 2, Mol: 4}
+	for _, tc := range []struct {
+		s    string
+		want bool
+	}{
+		{"id 12", true},
+		{"id 1-11 13-20", false},
+		{"id 10-15", true},
+		{"type 1 3", false},
+		{"type 2", true},
+		{"mol 1-3", false},
+		{"mol 4-4", true},
+	} {
+		sel, err := ParseSelection(tc.s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sel.Match(at); got != tc.want {
+			t.Errorf("%q: Match(%+v) = %v, want %v", tc.s, at, got, tc.want)
+		}
+	}
+
+	var sel *Selection
+	if !sel.Match(at) {
+		t.Errorf("a nil selection doesn't select %+v", at)
+	}
+}
@@ -0,0 +1,84 @@
+package topo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Species is a kind of molecule. The Mol molecules of a species are contiguous
+// in the trajectory and each one is made of At atoms of masses Masses.
+type Species struct {
+	// Name is the name of the species. It is appended to the output files if
+	// it isn't empty
+	Name string `yaml:"name"`
+
+	// Mol is the number of molecules of this species in one configuration
+	Mol int `yaml:"mol"`
+
+	// At is the number of atoms in one molecule
+	At int `yaml:"at"`
+
+	// Masses are the masses of each atoms in one molecule
+	Masses []float64 `yaml:"masses"`
+}
+
+// AtTot returns the number of atoms of all the species.
+func AtTot(sp []Species) (n int) {
+	for _, s := range sp {
+		n += s.Mol * s.At
+	}
+	return
+}
+
+// MolTot returns the number of molecules of all the species.
+func MolTot(sp []Species) (n int) {
+	for _, s := range sp {
+		n += s.Mol
+	}
+	return
+}
+
+// Check checks if the species are correct. It returns an error if a field
+// doesn't meet the requirements.
+func Check(sp []Species) error {
+	if len(sp) == 0 {
+		return fmt.Errorf("no species")
+	}
+
+	names := make(map[string]bool)
+	for _, s := range sp {
+		if s.Mol <= 0 || s.At <= 0 {
+			return fmt.Errorf("species %s: Mol or At cannot be lower or equal to 0", s.Name)
+		}
+
+		if len(s.Masses) != s.At {
+			return fmt.Errorf("species %s: the length of the masses slice is not equal to At", s.Name)
+		}
+
+		if len(sp) > 1 && s.Name == "" {
+			return fmt.Errorf("a name is required when there are several species")
+		}
+
+		if names[s.Name] {
+			return fmt.Errorf("species %s specified twice", s.Name)
+		}
+		names[s.Name] = true
+	}
+
+	return nil
+}
+
+// Out returns the name of the output file of the species s from the name of
+// the output file out. The name of the species is inserted before the
+// extension of out.
+func (s Species) Out(out string) string {
+	if s.Name == "" {
+		return out
+	}
+
+	i := strings.LastIndex(out, ".")
+	if i < 0 {
+		return fmt.Sprint(out, "_", s.Name)
+	}
+	return fmt.Sprint(out[:i], "_", s.Name, out[i:])
+}
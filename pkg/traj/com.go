@@ -0,0 +1,142 @@
+package traj
+
+import (
+	"fmt"
+
+	"github.com/kpotier/selfdiff/pkg/topo"
+)
+
+// Here are the columns used by the modules. Pos are the unwrapped positions,
+// Vel the velocities and Image the image flags of the wrapped positions.
+var (
+	Pos   = [3]string{"xu", "yu", "zu"}
+	Vel   = [3]string{"vx", "vy", "vz"}
+	Image = [3]string{"ix", "iy", "iz"}
+)
+
+// COM implements the Method interface for any Reader. It returns the center of
+// mass of each molecule computed from the columns Cols. If Cols are Pos, the
+// positions unwrapped with the image flags are preferred (see
+// Frame.Unwrapped). The last Mem configurations are put into memory, the other
+// ones being read when needed.
+type COM struct {
+	Reader Reader
+	Cols   [3]string
+
+	Start int // First frame read
+	Tot   int // Number of frames read
+	Mem   int
+
+	Species []topo.Species
+
+	// Select selects the atoms that are read. If it is nil, every atom is read
+	Select *topo.Selection
+
+	// ByMol specifies if the atoms are grouped into molecules by their mol
+	// column instead of their order
+	ByMol bool
+
+	memPos int // Position of the configurations that are in the memory
+	order  topo.Order
+	xyz    [][][3]float64
+}
+
+// Read is part of the Method interface. It puts the last configurations into
+// memory.
+func (c *COM) Read() error {
+	c.memPos = c.Tot - c.Mem
+	c.xyz = nil
+
+	for i := c.memPos; i < c.Tot; i++ {
+		xyz, err := c.cfg(i)
+		if err != nil {
+			return err
+		}
+		c.xyz = append(c.xyz, xyz)
+	}
+
+	return nil
+}
+
+// GetCfg is part of the Method interface. It returns the center of mass of
+// each molecule for the configuration i (starting from Start).
+func (c *COM) GetCfg(i int) ([][3]float64, error) {
+	if i >= c.memPos {
+		return c.xyz[i-c.memPos], nil
+	}
+	return c.cfg(i)
+}
+
+// End is part of the Method interface. It closes the Reader.
+func (c *COM) End() error {
+	return c.Reader.Close()
+}
+
+// cfg reads the configuration i, keeps the selected atoms, sorts them by id and
+// returns the center of mass of each molecule.
+func (c *COM) cfg(i int) ([][3]float64, error) {
+	f, err := c.Reader.Frame(c.Start + i)
+	if err != nil {
+		return nil, err
+	}
+
+	// The image flags give the exact unwrapped positions
+	var xyz [3][]float64
+	if c.Cols == Pos {
+		xyz = f.Unwrapped()
+	}
+	for k, name := range c.Cols {
+		if xyz[k] == nil {
+			xyz[k] = f.Col(name)
+		}
+		if xyz[k] == nil && c.Cols == Pos && f.Col("x") != nil {
+			return nil, fmt.Errorf("cannot find the column %s (the positions x y z may be wrapped, set pbc to true)", name)
+		}
+		if xyz[k] == nil {
+			return nil, fmt.Errorf("cannot find the column %s", name)
+		}
+	}
+
+	id, typ, mol := f.Col("id"), f.Col("type"), f.Col("mol")
+	if c.Select != nil && f.Col(c.Select.Col) == nil {
+		return nil, fmt.Errorf("cannot find the column %s", c.Select.Col)
+	}
+	if c.ByMol && mol == nil {
+		return nil, fmt.Errorf("cannot find the column mol")
+	}
+
+	atoms := make([]topo.Atom, 0, f.Atoms)
+	for a := 0; a < f.Atoms; a++ {
+		var at topo.Atom
+		if id != nil {
+			at.ID = int(id[a])
+		}
+		if typ != nil {
+			at.Type = int(typ[a])
+		}
+		if mol != nil {
+			at.Mol = int(mol[a])
+		}
+
+		if !c.Select.Match(at) {
+			continue
+		}
+
+		for k := 0; k < 3; k++ {
+			at.XYZ[k] = xyz[k][a]
+		}
+		atoms = append(atoms, at)
+	}
+
+	// The atoms are paired by their index across configurations
+	if id != nil {
+		topo.Sort(atoms)
+	} else {
+		err = c.order.Check(atoms)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return topo.COM(c.Species, atoms, c.ByMol)
+}
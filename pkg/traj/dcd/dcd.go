@@ -0,0 +1,325 @@
+package dcd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/kpotier/selfdiff/pkg/traj"
+)
+
+// Reader is a reader of DCD files (CHARMM, NAMD, OpenMM or Lammps). It
+// implements the traj.Reader interface. Every frame but the first one has the
+// same size, so the frames are found without reading the file.
+//
+// The file can be little or big endian, with Fortran record markers of 4 or 8
+// bytes. The positions give the columns x y z.
+// DCD files are usually wrapped, so the trajectory must be unwrapped (pbc),
+// unless the file has no unit cell, in which case they also give xu yu zu.
+// The positions of the fixed atoms are only stored in the first frame.
+//
+// The malformed frames return a traj.ParseError. A truncated frame at the end
+// of the file is ignored (e.g: the simulation has been interrupted).
+type Reader struct {
+	name  string
+	f     *os.File
+	order binary.ByteOrder
+	mark  int64 // Size of the record markers (4 or 8 bytes)
+
+	atoms int
+	free  []int // Indices of the free atoms. Nil if no atom is fixed
+	cell  bool  // Each frame starts with a unit cell block
+	dim4  bool  // Each frame ends with a fourth dimension block
+
+	start, step int // First step and number of steps between two frames
+
+	first int64 // Position of the first frame
+	size1 int64 // Size of the first frame
+	size  int64 // Size of the other frames
+	n     int   // Number of frames
+
+	fixed [3][]float64 // Positions of the first frame
+}
+
+// Open opens the DCD file name and reads its header.
+func Open(name string) (*Reader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reader{name: name, f: f}
+	err = r.header()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// header reads the header of the file: the control record (CORD), the title
+// and the number of atoms followed by the free atoms if some are fixed.
+func (r *Reader) header() error {
+	var b [100]byte
+	_, err := r.f.ReadAt(b[:], 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	// The first record has a size of 84 bytes and starts with CORD
+	switch {
+	case string(b[4:8]) == "CORD":
+		r.mark = 4
+	case string(b[8:12]) == "CORD":
+		r.mark = 8
+	default:
+		return fmt.Errorf("not a DCD file")
+	}
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		r.order = order
+		if r.marker(b[:]) == 84 {
+			break
+		}
+	}
+	if r.marker(b[:]) != 84 {
+		return fmt.Errorf("not a DCD file")
+	}
+
+	icntrl := make([]int, 20)
+	for i := range icntrl {
+		icntrl[i] = int(int32(r.order.Uint32(b[r.mark+4+4*int64(i):])))
+	}
+	r.start, r.step = icntrl[1], icntrl[2]
+	fixed := icntrl[8]
+	charmm := icntrl[19] != 0
+	r.cell = charmm && icntrl[10] != 0
+	r.dim4 = charmm && icntrl[11] != 0
+
+	// Title
+	off := 84 + 2*r.mark
+	title, err := r.record(off)
+	if err != nil {
+		return err
+	}
+	off += int64(len(title)) + 2*r.mark
+
+	// Number of atoms
+	natom, err := r.record(off)
+	if err != nil {
+		return err
+	}
+	if len(natom) != 4 {
+		return fmt.Errorf("unable to get the number of atoms")
+	}
+	r.atoms = int(int32(r.order.Uint32(natom)))
+	off += 4 + 2*r.mark
+
+	if fixed < 0 || fixed >= r.atoms {
+		return fmt.Errorf("invalid number of fixed atoms")
+	}
+
+	if fixed > 0 {
+		free, err := r.record(off)
+		if err != nil {
+			return err
+		}
+		if len(free) != 4*(r.atoms-fixed) {
+			return fmt.Errorf("unable to get the free atoms")
+		}
+
+		r.free = make([]int, r.atoms-fixed)
+		for i := range r.free {
+			r.free[i] = int(int32(r.order.Uint32(free[4*i:]))) - 1
+			if r.free[i] < 0 || r.free[i] >= r.atoms {
+				return fmt.Errorf("invalid free atom %d", r.free[i]+1)
+			}
+		}
+		off += int64(len(free)) + 2*r.mark
+	}
+
+	r.first = off
+
+	// Size of the frames: the blocks of the cell, x, y, z and w
+	size := func(atoms int) int64 {
+		var n int64
+		if r.cell {
+			n += 48 + 2*r.mark
+		}
+		n += 3 * (4*int64(atoms) + 2*r.mark)
+		if r.dim4 {
+			n += 4*int64(atoms) + 2*r.mark
+		}
+		return n
+	}
+	r.size1, r.size = size(r.atoms), size(r.atoms)
+	if r.free != nil {
+		r.size = size(len(r.free))
+	}
+
+	// The number of frames of the header isn't reliable if the simulation
+	// has been interrupted
+	st, err := r.f.Stat()
+	if err != nil {
+		return err
+	}
+	if rest := st.Size() - r.first; rest >= r.size1 {
+		r.n = 1 + int((rest-r.size1)/r.size)
+	}
+
+	return nil
+}
+
+// record reads the Fortran record at the position off: its size, its content
+// and its size again.
+func (r *Reader) record(off int64) ([]byte, error) {
+	b := make([]byte, r.mark)
+	_, err := r.f.ReadAt(b, off)
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	n := r.marker(b)
+	if n < 0 || n > 1<<30 {
+		return nil, fmt.Errorf("invalid record at %d", off)
+	}
+
+	rec := make([]byte, n+r.mark)
+	_, err = r.f.ReadAt(rec, off+r.mark)
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if r.marker(rec[n:]) != n {
+		return nil, fmt.Errorf("invalid record at %d", off)
+	}
+
+	return rec[:n], nil
+}
+
+// marker returns the size of a record given by the marker at the start of b.
+func (r *Reader) marker(b []byte) int64 {
+	if r.mark == 8 {
+		return int64(r.order.Uint64(b))
+	}
+	return int64(int32(r.order.Uint32(b)))
+}
+
+// Len is part of the traj.Reader interface.
+func (r *Reader) Len() (int, error) {
+	return r.n, nil
+}
+
+// Frame is part of the traj.Reader interface. The positions must be finite
+// numbers.
+func (r *Reader) Frame(i int) (*traj.Frame, error) {
+	if i < 0 || i >= r.n {
+		return nil, fmt.Errorf("frame %d: %w", i, io.EOF)
+	}
+
+	// The first frame contains the positions of the fixed atoms
+	if r.free != nil && i != 0 && r.fixed[0] == nil {
+		_, err := r.Frame(0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	off := r.first
+	if i > 0 {
+		off += r.size1 + int64(i-1)*r.size
+	}
+
+	f := &traj.Frame{Step: r.start + i*r.step, Atoms: r.atoms, Cols: make(map[string][]float64)}
+
+	if r.cell {
+		b, err := r.record(off)
+		if err != nil {
+			return nil, r.error(i, "", err)
+		}
+		if len(b) != 48 {
+			return nil, r.error(i, "", fmt.Errorf("unable to get the unit cell"))
+		}
+
+		var uc [6]float64
+		for k := range uc {
+			uc[k] = math.Float64frombits(r.order.Uint64(b[8*k:]))
+		}
+		off += 48 + 2*r.mark
+
+		err = cell(f, uc)
+		if err != nil {
+			return nil, r.error(i, "", err)
+		}
+	}
+
+	for k, name := range [3]string{"x", "y", "z"} {
+		b, err := r.record(off)
+		if err != nil {
+			return nil, r.error(i, name, err)
+		}
+		off += int64(len(b)) + 2*r.mark
+
+		col := make([]float64, r.atoms)
+		switch {
+		case len(b) == 4*r.atoms:
+			for a := range col {
+				col[a] = float64(math.Float32frombits(r.order.Uint32(b[4*a:])))
+			}
+		case r.free != nil && len(b) == 4*len(r.free):
+			copy(col, r.fixed[k])
+			for j, a := range r.free {
+				col[a] = float64(math.Float32frombits(r.order.Uint32(b[4*j:])))
+			}
+		default:
+			return nil, r.error(i, name, fmt.Errorf("number of atoms don't match"))
+		}
+
+		for a, v := range col {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return nil, r.error(i, name, fmt.Errorf("invalid value %g (atom %d)", v, a+1))
+			}
+		}
+
+		if i == 0 && r.free != nil {
+			r.fixed[k] = append([]float64(nil), col...)
+		}
+
+		f.Cols[name] = col
+		f.Names = append(f.Names, name)
+	}
+
+	// The positions can't be wrapped without cell
+	if f.NoCell() {
+		f.SetUnwrapped()
+	}
+
+	return f, nil
+}
+
+// Close is part of the traj.Reader interface.
+func (r *Reader) Close() error {
+	return r.f.Close()
+}
+
+// error returns the traj.ParseError of the frame i about the column col.
+func (r *Reader) error(i int, col string, err error) error {
+	return &traj.ParseError{File: r.name, Frame: i, Col: col, Err: err}
+}
+
+// cell sets the box of f from the unit cell A, gamma, B, beta, alpha, C. The
+// angles are in degrees or given by their cosine (NAMD and Lammps).
+func cell(f *traj.Frame, uc [6]float64) error {
+	return f.SetLengths(uc[0], uc[2], uc[5], cos(uc[4]), cos(uc[3]), cos(uc[1]))
+}
+
+// cos returns the cosine of the angle v (in degrees) or v if it is already a
+// cosine.
+func cos(v float64) float64 {
+	if math.Abs(v) <= 1 {
+		return v
+	}
+	return traj.Cos(v)
+}
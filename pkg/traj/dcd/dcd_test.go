@@ -0,0 +1,309 @@
+package dcd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kpotier/selfdiff/pkg/traj"
+)
+
+const (
+	testAtoms  = 5
+	testFrames = 4
+	testStart  = 100
+	testStep   = 20
+)
+
+// testCoord returns the coordinate k of the atom a in the frame i.
+func testCoord(i, a, k int) float64 {
+	return float64(a) + 0.25*float64(k) + 0.5*float64(i)
+}
+
+// testCell returns the unit cell of the frame i: A, gamma, B, beta, alpha, C,
+// the angles being in degrees or given by their cosine.
+func testCell(i int, cosine bool) [6]float64 {
+	if cosine {
+		return [6]float64{10 + float64(i), 0.1, 11, -0.2, 0.05, 12}
+	}
+	return [6]float64{10 + float64(i), 90, 11, 100, 85, 12}
+}
+
+// testDCD describes a DCD file written by write.
+type testDCD struct {
+	order  binary.ByteOrder
+	mark   int   // Size of the record markers
+	cell   bool  // Unit cell block
+	cosine bool  // Angles of the unit cell given by their cosine
+	dim4   bool  // Fourth dimension block
+	free   []int // Free atoms (from 1) if some are fixed
+}
+
+// encoder writes the records of a DCD file.
+type encoder struct {
+	bytes.Buffer
+	order binary.ByteOrder
+	mark  int
+}
+
+func (e *encoder) record(data ...interface{}) {
+	var b bytes.Buffer
+	for _, v := range data {
+		binary.Write(&b, e.order, v)
+	}
+
+	for k := 0; k < 2; k++ {
+		if e.mark == 8 {
+			binary.Write(e, e.order, int64(b.Len()))
+		} else {
+			binary.Write(e, e.order, int32(b.Len()))
+		}
+		if k == 0 {
+			e.Write(b.Bytes())
+		}
+	}
+}
+
+// write writes the DCD file name of testFrames frames followed by half of a
+// frame.
+func (d testDCD) write(t *testing.T, name string) {
+	e := &encoder{order: d.order, mark: d.mark}
+
+	icntrl := make([]int32, 20)
+	icntrl[0], icntrl[1], icntrl[2] = testFrames, testStart, testStep
+	icntrl[8] = int32(testAtoms - len(d.free))
+	if d.free == nil {
+		icntrl[8] = 0
+	}
+	if d.cell {
+		icntrl[10] = 1
+	}
+	if d.dim4 {
+		icntrl[11] = 1
+	}
+	icntrl[19] = 24 // CHARMM
+	e.record([]byte("CORD"), icntrl)
+
+	title := make([]byte, 160)
+	copy(title, "REMARKS selfdiff")
+	e.record(int32(2), title)
+	e.record(int32(testAtoms))
+	if d.free != nil {
+		free := make([]int32, len(d.free))
+		for j, a := range d.free {
+			free[j] = int32(a)
+		}
+		e.record(free)
+	}
+
+	var last int // Start of the last frame
+	for i := 0; i <= testFrames; i++ {
+		last = e.Len()
+		if d.cell {
+			e.record(testCell(i, d.cosine))
+		}
+
+		var v []float32
+		for k := 0; k < 3; k++ {
+			v = nil
+			if i == 0 || d.free == nil {
+				for a := 0; a < testAtoms; a++ {
+					v = append(v, float32(testCoord(i, a, k)))
+				}
+			} else {
+				for _, a := range d.free {
+					v = append(v, float32(testCoord(i, a-1, k)))
+				}
+			}
+			e.record(v)
+		}
+
+		if d.dim4 {
+			e.record(make([]float32, len(v)))
+		}
+	}
+
+	// The last frame is truncated
+	b := e.Bytes()
+	b = b[:(len(b)+last)/2]
+
+	err := ioutil.WriteFile(name, b, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		name string
+		dcd  testDCD
+	}{
+		{"little endian", testDCD{order: binary.LittleEndian, mark: 4, cell: true}},
+		{"big endian", testDCD{order: binary.BigEndian, mark: 4, cell: true, cosine: true}},
+		{"no cell", testDCD{order: binary.LittleEndian, mark: 4}},
+		{"8-byte markers", testDCD{order: binary.LittleEndian, mark: 8, cell: true, dim4: true}},
+		{"8-byte markers big endian", testDCD{order: binary.BigEndian, mark: 8, cell: true}},
+		{"fixed atoms", testDCD{order: binary.LittleEndian, mark: 4, cell: true, free: []int{2, 4, 5}}},
+		{"fixed atoms big endian", testDCD{order: binary.BigEndian, mark: 8, dim4: true, free: []int{1, 3}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			name := filepath.Join(dir, "traj.dcd")
+			tc.dcd.write(t, name)
+
+			r, err := Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			n, err := r.Len()
+			if err != nil || n != testFrames {
+				t.Fatalf("Len() = %d, %v, want %d", n, err, testFrames)
+			}
+
+			free := make(map[int]bool)
+			for _, a := range tc.dcd.free {
+				free[a-1] = true
+			}
+
+			// Backward to read the fixed atoms from the first frame when needed
+			for i := n - 1; i >= 0; i-- {
+				f, err := r.Frame(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if f.Step != testStart+i*testStep {
+					t.Errorf("frame %d: Step = %d, want %d", i, f.Step, testStart+i*testStep)
+				}
+				if f.Atoms != testAtoms {
+					t.Fatalf("frame %d: Atoms = %d, want %d", i, f.Atoms, testAtoms)
+				}
+
+				for a := 0; a < testAtoms; a++ {
+					j := i
+					if tc.dcd.free != nil && !free[a] {
+						j = 0
+					}
+					for k, name := range [3]string{"x", "y", "z"} {
+						if v, want := f.Col(name)[a], testCoord(j, a, k); v != want {
+							t.Errorf("frame %d: %s of atom %d = %g, want %g", i, name, a, v, want)
+						}
+					}
+				}
+
+				// The positions are unwrapped without cell
+				if f.NoCell() == tc.dcd.cell {
+					t.Fatalf("frame %d: NoCell() = %v with a cell %v", i, f.NoCell(), tc.dcd.cell)
+				}
+				if !tc.dcd.cell {
+					if f.Col(traj.Pos[0]) == nil {
+						t.Errorf("frame %d: no column %s without cell", i, traj.Pos[0])
+					}
+					continue
+				}
+				if f.Col(traj.Pos[0]) != nil {
+					t.Errorf("frame %d: column %s with a cell", i, traj.Pos[0])
+				}
+
+				// Lengths and angles of the cell
+				uc := testCell(i, tc.dcd.cosine)
+				cell := f.Cell()
+				for k, l := range [3]float64{uc[0], uc[2], uc[5]} {
+					if math.Abs(norm(cell[k])-l) > 1e-9 {
+						t.Errorf("frame %d: cell %v, want the lengths %g %g %g", i, cell, uc[0], uc[2], uc[5])
+					}
+				}
+				for _, c := range []struct {
+					u, v int
+					cos  float64
+				}{{1, 2, uc[4]}, {0, 2, uc[3]}, {0, 1, uc[1]}} {
+					want := c.cos
+					if !tc.dcd.cosine {
+						want = math.Cos(c.cos * math.Pi / 180)
+					}
+					got := dot(cell[c.u], cell[c.v]) / norm(cell[c.u]) / norm(cell[c.v])
+					if math.Abs(got-want) > 1e-9 {
+						t.Errorf("frame %d: cosine between the vectors %d and %d = %g, want %g", i, c.u, c.v, got, want)
+					}
+				}
+			}
+
+			_, err = r.Frame(n)
+			if err == nil {
+				t.Errorf("Frame(%d) read the truncated frame", n)
+			}
+		})
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "traj.dcd")
+	d := testDCD{order: binary.LittleEndian, mark: 4, cell: true}
+	d.write(t, name)
+
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// NaN as the last y of the second frame
+	frame := 56 + 3*(4*testAtoms+8)
+	off := 92 + (4 + 160 + 8) + 12 + frame + 56 + (4*testAtoms + 8) + 4 + 4*(testAtoms-1)
+	binary.LittleEndian.PutUint32(b[off:], math.Float32bits(float32(math.NaN())))
+	err = ioutil.WriteFile(name, b, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	_, err = r.Frame(1)
+	var perr *traj.ParseError
+	if !errors.As(err, &perr) || perr.Frame != 1 || perr.Col != "y" {
+		t.Errorf("Frame(1) = %v, want a traj.ParseError about y", err)
+	}
+	_, err = r.Frame(2)
+	if err != nil {
+		t.Errorf("Frame(2): %v", err)
+	}
+
+	// Not a DCD file
+	err = ioutil.WriteFile(name, b[4:], 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Open(name)
+	if err == nil {
+		t.Errorf("Open accepted a file without control record")
+	}
+}
+
+func norm(v [3]float64) float64 {
+	return math.Sqrt(dot(v, v))
+}
+
+func dot(u, v [3]float64) float64 {
+	return u[0]*v[0] + u[1]*v[1] + u[2]*v[2]
+}
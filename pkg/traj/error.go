@@ -0,0 +1,33 @@
+package traj
+
+import "fmt"
+
+// ParseError is the error returned by a reader when a frame is malformed (e.g:
+// truncated frame or value which isn't a finite number). The frames returning
+// such an error can be skipped (see Lenient).
+type ParseError struct {
+	File  string
+	Frame int    // Index of the frame (from 0)
+	Line  int    // Line of the file (from 1). It is 0 for binary files
+	Col   string // Column of the value, empty if the error isn't about a value
+	Err   error
+}
+
+// Error returns the error with its location (e.g: dump.lammpstrj:1234: frame
+// 12: column xu: invalid value "nan").
+func (e *ParseError) Error() string {
+	s := e.File
+	if e.Line > 0 {
+		s = fmt.Sprint(s, ":", e.Line)
+	}
+	s = fmt.Sprint(s, ": frame ", e.Frame)
+	if e.Col != "" {
+		s = fmt.Sprint(s, ": column ", e.Col)
+	}
+	return fmt.Sprint(s, ": ", e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
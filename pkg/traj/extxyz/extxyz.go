@@ -0,0 +1,435 @@
+package extxyz
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/kpotier/selfdiff/pkg/traj"
+)
+
+// Reader is a reader of extended XYZ files. It implements the traj.Reader
+// interface. The position of each frame in the file is recorded the first time
+// the file is read up to this frame.
+//
+// The comment line of each frame gives the cell (Lattice), the columns
+// (Properties) and the timestep (step). The property pos gives the columns x y
+// z. The file doesn't specify whether they are wrapped, so the trajectory must
+// be unwrapped (pbc), which keeps unwrapped positions as they are. Without
+// Lattice, the positions can't be wrapped and also give the columns xu yu zu.
+// The properties species, vel (or velo) and forces give the columns element,
+// vx vy vz and fx fy fz. The other properties of 3 components give name_1
+// name_2 name_3. A cell which isn't in the orientation of Lammps is rotated
+// with the positions, the velocities and the forces (see traj.Frame.SetCell).
+//
+// The malformed frames return a traj.ParseError. A frame whose header is
+// malformed or which is truncated ends the trajectory.
+type Reader struct {
+	name string
+	f    traj.File
+	r    *bufio.Reader
+
+	offsets []int64       // Position of the frames found so far
+	lines   []int         // First line of the frames found so far
+	errs    map[int]error // Malformed frames found so far
+	end     int64         // Position of the end of the last frame found
+	line    int           // Line of the end of the last frame found
+	eof     bool
+}
+
+// property is one of the properties of the comment line.
+type property struct {
+	name string
+	typ  byte // S (string), R (real), I (integer) or L (logical)
+	n    int  // Number of columns
+}
+
+// Open opens the extended XYZ file name. The file can be compressed with gzip
+// (see traj.OpenFile).
+func Open(name string) (*Reader, error) {
+	f, err := traj.OpenFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{name: name, f: f, r: bufio.NewReader(f), line: 1}, nil
+}
+
+// Len is part of the traj.Reader interface.
+func (r *Reader) Len() (int, error) {
+	for !r.eof {
+		err := r.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(r.offsets), nil
+}
+
+// Frame is part of the traj.Reader interface. The step is the index of the
+// frame if it isn't in the comment line. The real values must be finite
+// numbers.
+func (r *Reader) Frame(i int) (*traj.Frame, error) {
+	for len(r.offsets) <= i && !r.eof {
+		err := r.next()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if i < 0 || i >= len(r.offsets) {
+		return nil, fmt.Errorf("frame %d: %w", i, io.EOF)
+	}
+
+	if err := r.errs[i]; err != nil {
+		return nil, err
+	}
+
+	err := r.seek(r.offsets[i])
+	if err != nil {
+		return nil, err
+	}
+
+	f, props, cell, _, h, err := r.header()
+	if err != nil {
+		return nil, r.error(i, r.lines[i]+h-1, "", err)
+	}
+	if f.Step < 0 {
+		f.Step = i
+	}
+
+	// Columns of each field of a line
+	var (
+		data  [][]float64
+		strs  [][]string
+		logic []bool
+		names []string
+	)
+	f.Cols = make(map[string][]float64)
+	f.Strs = make(map[string][]string)
+
+	for _, p := range props {
+		for _, name := range p.names() {
+			f.Names = append(f.Names, name)
+
+			if p.typ == 'S' {
+				strs = append(strs, make([]string, f.Atoms))
+				data = append(data, nil)
+				f.Strs[name] = strs[len(strs)-1]
+			} else {
+				data = append(data, make([]float64, f.Atoms))
+				strs = append(strs, nil)
+				f.Cols[name] = data[len(data)-1]
+			}
+			logic = append(logic, p.typ == 'L')
+			names = append(names, name)
+		}
+	}
+
+	for a := 0; a < f.Atoms; a++ {
+		line := r.lines[i] + 2 + a
+		l, err := traj.ReadLine(r.r)
+		if err != nil && !(err == io.EOF && len(l) != 0) {
+			return nil, r.error(i, line, "", io.ErrUnexpectedEOF)
+		}
+
+		fields := strings.Fields(string(l))
+		if len(fields) != len(data) {
+			return nil, r.error(i, line, "", fmt.Errorf("%d columns instead of %d", len(fields), len(data)))
+		}
+
+		for k, v := range fields {
+			switch {
+			case strs[k] != nil:
+				strs[k][a] = v
+			case logic[k]:
+				switch v {
+				case "T", "True", "true":
+					data[k][a] = 1
+				case "F", "False", "false":
+				default:
+					return nil, r.error(i, line, names[k], fmt.Errorf("invalid value %q", v))
+				}
+			default:
+				data[k][a], err = strconv.ParseFloat(v, 64)
+				if err != nil || math.IsNaN(data[k][a]) || math.IsInf(data[k][a], 0) {
+					return nil, r.error(i, line, names[k], fmt.Errorf("invalid value %q", v))
+				}
+			}
+		}
+	}
+
+	// The positions are rotated with the cell
+	if cell != nil {
+		err = f.SetCell(cell[0], cell[1], cell[2])
+		if err != nil {
+			return nil, r.error(i, r.lines[i]+1, "", err)
+		}
+	}
+
+	// The positions can't be wrapped without cell
+	if f.NoCell() {
+		f.SetUnwrapped()
+	}
+
+	return f, nil
+}
+
+// Close is part of the traj.Reader interface.
+func (r *Reader) Close() error {
+	return r.f.Close()
+}
+
+// seek moves to the position off of the file.
+func (r *Reader) seek(off int64) error {
+	_, err := r.f.Seek(off, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	r.r.Reset(r.f)
+	return nil
+}
+
+// next finds the position of the frame following the last one found. A
+// malformed frame is recorded with its error and ends the trajectory, the
+// next frame being unknown.
+func (r *Reader) next() error {
+	err := r.seek(r.end)
+	if err != nil {
+		return err
+	}
+
+	// Blank lines at the end of the file are ignored
+	for {
+		b, err := r.r.Peek(1)
+		if err == io.EOF {
+			r.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if b[0] != '\n' && b[0] != '\r' && b[0] != ' ' && b[0] != '\t' {
+			break
+		}
+		if b[0] == '\n' {
+			r.line++
+		}
+		r.r.ReadByte()
+		r.end++
+	}
+
+	i := len(r.offsets)
+	r.offsets = append(r.offsets, r.end)
+	r.lines = append(r.lines, r.line)
+
+	f, _, _, n, h, err := r.header()
+	if err != nil {
+		return r.stop(r.error(i, r.line+h-1, "", err))
+	}
+
+	for a := 0; a < f.Atoms; a++ {
+		l, err := traj.ReadLine(r.r)
+		if err != nil && !(err == io.EOF && len(l) != 0) {
+			return r.stop(r.error(i, r.line+2+a, "", io.ErrUnexpectedEOF))
+		}
+		n += int64(len(l))
+	}
+
+	r.end += n
+	r.line += 2 + f.Atoms
+	return nil
+}
+
+// stop records the error of the last frame found and ends the trajectory.
+func (r *Reader) stop(perr error) error {
+	if r.errs == nil {
+		r.errs = make(map[int]error)
+	}
+	r.errs[len(r.offsets)-1] = perr
+	r.eof = true
+	return nil
+}
+
+// error returns the traj.ParseError of the frame i at the line line of the file
+// about the column col.
+func (r *Reader) error(i, line int, col string, err error) error {
+	return &traj.ParseError{File: r.name, Frame: i, Line: line, Col: col, Err: err}
+}
+
+// header reads the number of atoms and the comment line of a frame. It returns
+// the frame (without its columns and its box, and with a step equal to -1 if it
+// isn't specified), the properties, the cell (nil if it isn't specified), the
+// number of bytes read and the number of lines read.
+func (r *Reader) header() (f *traj.Frame, props []property, cell *[3][3]float64, n int64, h int, err error) {
+	f = &traj.Frame{Step: -1}
+
+	var b [2]string
+	for l := 0; l < 2; l++ {
+		b[l], err = r.r.ReadString('\n')
+		n += int64(len(b[l]))
+		h++
+		if err != nil {
+			err = io.ErrUnexpectedEOF
+			return
+		}
+	}
+
+	f.Atoms, err = strconv.Atoi(strings.TrimSpace(b[0]))
+	if err != nil || f.Atoms < 0 {
+		err = fmt.Errorf("unable to get the number of atoms")
+		h = 1
+		return
+	}
+
+	kv := comment(b[1])
+
+	props, err = properties(kv["properties"])
+	if err != nil {
+		return
+	}
+
+	if v, ok := kv["lattice"]; ok {
+		cell, err = lattice(v)
+		if err != nil {
+			return
+		}
+	}
+
+	if v, ok := kv["step"]; ok {
+		f.Step, err = strconv.Atoi(v)
+		if err != nil {
+			err = fmt.Errorf("unable to get the timestep")
+			return
+		}
+	}
+
+	return
+}
+
+// comment parses the key=value pairs of a comment line. The values can be
+// quoted. The keys are in lower case and a key without value is equal to T.
+func comment(s string) map[string]string {
+	kv := make(map[string]string)
+
+	s = strings.TrimSpace(s)
+	for len(s) > 0 {
+		var key, value string
+
+		i := strings.IndexAny(s, "= \t")
+		if i < 0 {
+			i = len(s)
+		}
+		key, s = s[:i], s[i:]
+
+		if len(s) > 0 && s[0] == '=' {
+			s = s[1:]
+			if len(s) > 0 && (s[0] == '"' || s[0] == '\'') {
+				j := strings.IndexByte(s[1:], s[0])
+				if j < 0 {
+					value, s = s[1:], ""
+				} else {
+					value, s = s[1:j+1], s[j+2:]
+				}
+			} else {
+				j := strings.IndexAny(s, " \t")
+				if j < 0 {
+					j = len(s)
+				}
+				value, s = s[:j], s[j:]
+			}
+		} else {
+			value = "T"
+		}
+
+		if key != "" {
+			kv[strings.ToLower(key)] = value
+		}
+		s = strings.TrimLeft(s, " \t")
+	}
+
+	return kv
+}
+
+// properties parses the value of Properties (e.g:
+// species:S:1:pos:R:3:vel:R:3). The default is species:S:1:pos:R:3.
+func properties(s string) ([]property, error) {
+	if s == "" {
+		s = "species:S:1:pos:R:3"
+	}
+
+	fields := strings.Split(s, ":")
+	if len(fields)%3 != 0 {
+		return nil, fmt.Errorf("invalid properties %s", s)
+	}
+
+	var props []property
+	for i := 0; i < len(fields); i += 3 {
+		p := property{name: fields[i]}
+
+		if len(fields[i+1]) != 1 || !strings.Contains("SRIL", fields[i+1]) {
+			return nil, fmt.Errorf("invalid type of the property %s", p.name)
+		}
+		p.typ = fields[i+1][0]
+
+		var err error
+		p.n, err = strconv.Atoi(fields[i+2])
+		if err != nil || p.n <= 0 {
+			return nil, fmt.Errorf("invalid number of columns of the property %s", p.name)
+		}
+
+		props = append(props, p)
+	}
+
+	return props, nil
+}
+
+// names returns the names of the columns of the property p.
+func (p property) names() []string {
+	if p.n == 1 {
+		// The chemical symbols are the column element of Lammps
+		if p.name == "species" && p.typ == 'S' {
+			return []string{"element"}
+		}
+		return []string{p.name}
+	}
+
+	if p.n == 3 {
+		switch p.name {
+		case "pos":
+			return []string{"x", "y", "z"}
+		case "vel", "velo", "velocities":
+			return []string{"vx", "vy", "vz"}
+		case "forces":
+			return []string{"fx", "fy", "fz"}
+		}
+	}
+
+	names := make([]string, p.n)
+	for k := range names {
+		names[k] = fmt.Sprint(p.name, "_", k+1)
+	}
+	return names
+}
+
+// lattice returns the vectors a, b and c of the cell given by the value of
+// Lattice (see traj.Frame.SetCell).
+func lattice(s string) (*[3][3]float64, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 9 {
+		return nil, fmt.Errorf("unable to get the lattice")
+	}
+
+	var cell [3][3]float64
+	for i, field := range fields {
+		var err error
+		cell[i/3][i%3], err = strconv.ParseFloat(field, 64)
+		if err != nil || math.IsNaN(cell[i/3][i%3]) || math.IsInf(cell[i/3][i%3], 0) {
+			return nil, fmt.Errorf("unable to get the lattice")
+		}
+	}
+
+	return &cell, nil
+}
@@ -0,0 +1,52 @@
+package traj
+
+import (
+	"bufio"
+	"io"
+	"os"
+
+	"github.com/kpotier/selfdiff/pkg/gz"
+)
+
+// File is a trajectory file which can be read from any position.
+type File interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// OpenFile opens the file name. A gzip-compressed file (e.g: written by the
+// dump styles */gz of Lammps) is recognized by its first bytes and is
+// decompressed on the fly. Seeking backward in such a file is slower since the
+// decompression restarts from the last recorded point (see gz package).
+func OpenFile(name string) (File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var b [2]byte
+	n, _ := f.ReadAt(b[:], 0)
+	if n != 2 || b[0] != 0x1f || b[1] != 0x8b {
+		return f, nil
+	}
+
+	f.Close()
+	return gz.Open(name)
+}
+
+// ReadLine reads a line of r, even if it is longer than the buffer of r. The
+// line is only valid until the next read of r, unless it is longer than the
+// buffer.
+func ReadLine(r *bufio.Reader) ([]byte, error) {
+	l, err := r.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return l, err
+	}
+
+	b := append([]byte{}, l...)
+	for err == bufio.ErrBufferFull {
+		l, err = r.ReadSlice('\n')
+		b = append(b, l...)
+	}
+	return b, err
+}
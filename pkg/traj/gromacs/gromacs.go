@@ -0,0 +1,179 @@
+package gromacs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/kpotier/selfdiff/pkg/traj"
+)
+
+// Reader is a reader of GROMACS XTC and TRR files. It implements the
+// traj.Reader interface. The position of each frame in the file is recorded
+// the first time the file is read up to this frame.
+//
+// The values are in the units of GROMACS (nm and nm/ps). The positions give
+// the columns x y z. GROMACS doesn't specify whether they are wrapped, so the
+// trajectory must be unwrapped (pbc), which keeps unwrapped positions as they
+// are. Without box, the positions can't be wrapped and also give the columns
+// xu yu zu.
+//
+// The malformed frames return a traj.ParseError. A frame whose header is
+// malformed or which is truncated ends the trajectory.
+type Reader struct {
+	name string
+	f    traj.File
+	r    *bufio.Reader
+
+	// frame reads one frame. Only its size is needed if skip is true
+	frame func(x *xdr, skip bool) (*traj.Frame, error)
+
+	offsets []int64       // Position of the frames found so far
+	errs    map[int]error // Malformed frames found so far
+	end     int64         // Position of the end of the last frame found
+	eof     bool
+}
+
+// OpenXTC opens the XTC file name (compressed positions).
+func OpenXTC(name string) (*Reader, error) {
+	return open(name, xtc)
+}
+
+// OpenTRR opens the TRR file name (positions, velocities and forces).
+func OpenTRR(name string) (*Reader, error) {
+	return open(name, trr)
+}
+
+// open opens the file name whose frames are read by frame.
+func open(name string, frame func(x *xdr, skip bool) (*traj.Frame, error)) (*Reader, error) {
+	f, err := traj.OpenFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{name: name, f: f, r: bufio.NewReader(f), frame: frame}, nil
+}
+
+// Len is part of the traj.Reader interface.
+func (r *Reader) Len() (int, error) {
+	for !r.eof {
+		err := r.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(r.offsets), nil
+}
+
+// Frame is part of the traj.Reader interface. The values must be finite
+// numbers.
+func (r *Reader) Frame(i int) (*traj.Frame, error) {
+	for len(r.offsets) <= i && !r.eof {
+		err := r.next()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if i < 0 || i >= len(r.offsets) {
+		return nil, fmt.Errorf("frame %d: %w", i, io.EOF)
+	}
+
+	if err := r.errs[i]; err != nil {
+		return nil, err
+	}
+
+	err := r.seek(r.offsets[i])
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := r.frame(&xdr{r: r.r}, false)
+	if err != nil {
+		return nil, r.error(i, "", err)
+	}
+
+	for _, name := range f.Names {
+		for a, v := range f.Cols[name] {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return nil, r.error(i, name, fmt.Errorf("invalid value %g (atom %d)", v, a+1))
+			}
+		}
+	}
+
+	// The positions can't be wrapped without cell
+	if f.NoCell() {
+		f.SetUnwrapped()
+	}
+
+	return f, nil
+}
+
+// Close is part of the traj.Reader interface.
+func (r *Reader) Close() error {
+	return r.f.Close()
+}
+
+// seek moves to the position off of the file.
+func (r *Reader) seek(off int64) error {
+	_, err := r.f.Seek(off, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	r.r.Reset(r.f)
+	return nil
+}
+
+// next finds the position of the frame following the last one found. A
+// malformed frame is recorded with its error and ends the trajectory.
+func (r *Reader) next() error {
+	err := r.seek(r.end)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.r.Peek(1)
+	if err == io.EOF {
+		r.eof = true
+		return nil
+	}
+
+	i := len(r.offsets)
+	r.offsets = append(r.offsets, r.end)
+
+	x := &xdr{r: r.r}
+	_, err = r.frame(x, true)
+	if err != nil {
+		if r.errs == nil {
+			r.errs = make(map[int]error)
+		}
+		r.errs[i] = r.error(i, "", err)
+		r.eof = true
+		return nil
+	}
+
+	r.end += x.n
+	return nil
+}
+
+// error returns the traj.ParseError of the frame i about the column col.
+func (r *Reader) error(i int, col string, err error) error {
+	return &traj.ParseError{File: r.name, Frame: i, Col: col, Err: err}
+}
+
+// cols adds the columns names (e.g: x y z) to the frame f. They are filled
+// with the vectors v (x1 y1 z1 x2 y2 z2...).
+func cols(f *traj.Frame, names [3]string, v []float64) {
+	if f.Cols == nil {
+		f.Cols = make(map[string][]float64)
+	}
+
+	for k, name := range names {
+		col := make([]float64, f.Atoms)
+		for a := range col {
+			col[a] = v[3*a+k]
+		}
+		f.Cols[name] = col
+		f.Names = append(f.Names, name)
+	}
+}
@@ -0,0 +1,154 @@
+package gromacs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kpotier/selfdiff/pkg/traj"
+)
+
+const (
+	testAtoms  = 5
+	testFrames = 4
+)
+
+// testCoord returns the coordinate k of the atom a in the frame i.
+func testCoord(i, a, k int) float64 {
+	return float64(a) + 0.25*float64(k) + 0.5*float64(i)
+}
+
+// testStep returns the step of the frame i.
+func testStep(i int) int {
+	return 100 * i
+}
+
+// testTime returns the time of the frame i.
+func testTime(i int) float64 {
+	return 0.5*float64(i) + 0.25
+}
+
+// testBox returns the box of the frame i: the vectors of a triclinic cell in
+// the restricted orientation.
+func testBox(i int) [3][3]float64 {
+	return [3][3]float64{{4 + 0.5*float64(i), 0, 0}, {0.25, 5, 0}, {-0.5, 0.75, 6}}
+}
+
+// encoder writes the XDR values of a GROMACS file.
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) int(v int) {
+	binary.Write(e, binary.BigEndian, int32(v))
+}
+
+func (e *encoder) float(v float64) {
+	binary.Write(e, binary.BigEndian, float32(v))
+}
+
+func (e *encoder) real(v float64, size int) {
+	if size == 8 {
+		binary.Write(e, binary.BigEndian, v)
+		return
+	}
+	e.float(v)
+}
+
+// opaque writes b padded to a multiple of 4 bytes.
+func (e *encoder) opaque(b []byte) {
+	e.Write(b)
+	e.Write(make([]byte, (len(b)+3)/4*4-len(b)))
+}
+
+// checkFrame fails if the step, the time or the box of the frame i differ
+// from the ones written.
+func checkFrame(t *testing.T, i int, f *traj.Frame, step int, time float64, box [3][3]float64) {
+	t.Helper()
+	if f.Step != step {
+		t.Errorf("frame %d: Step = %d, want %d", i, f.Step, step)
+	}
+	if f.Time != time {
+		t.Errorf("frame %d: Time = %g, want %g", i, f.Time, time)
+	}
+
+	lo := [3][2]float64{{0, box[0][0]}, {0, box[1][1]}, {0, box[2][2]}}
+	tilt := [3]float64{box[1][0], box[2][0], box[2][1]}
+	if f.Box != lo || f.Tilt != tilt {
+		t.Errorf("frame %d: Box = %v and Tilt = %v, want %v and %v", i, f.Box, f.Tilt, lo, tilt)
+	}
+
+	// The positions are unwrapped without box
+	if f.NoCell() != (box == [3][3]float64{}) {
+		t.Fatalf("frame %d: NoCell() = %v with the box %v", i, f.NoCell(), box)
+	}
+	want := f.NoCell() && f.Col("x") != nil
+	if (f.Col(traj.Pos[0]) != nil) != want {
+		t.Errorf("frame %d: column %s present = %v, want %v", i, traj.Pos[0], f.Col(traj.Pos[0]) != nil, want)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gromacs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		name  string
+		write func(e *encoder, i int)
+		open  func(name string) (*Reader, error)
+		col   string
+		off   int // Position of the last y (or vy) in a frame
+	}{
+		{"xtc", func(e *encoder, i int) { testXTC{}.frame(e, i) }, OpenXTC, "y", 56 + 4*(3*(testAtoms-1)+1)},
+		{"trr", testTRR{real: 4, box: true, x: true, v: true}.frame, OpenTRR, "vy", 120 + 12*testAtoms + 12*(testAtoms-1) + 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// NaN as the last y (or vy) of the second frame, and a truncated
+			// last frame
+			e := &encoder{}
+			var start [testFrames + 1]int
+			for i := range start {
+				start[i] = e.Len()
+				tc.write(e, i)
+			}
+			b := e.Bytes()
+			binary.BigEndian.PutUint32(b[start[1]+tc.off:], math.Float32bits(float32(math.NaN())))
+			b = b[:(start[testFrames]+len(b))/2]
+
+			name := filepath.Join(dir, "traj")
+			err := ioutil.WriteFile(name, b, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := tc.open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			_, err = r.Frame(1)
+			var perr *traj.ParseError
+			if !errors.As(err, &perr) || perr.Frame != 1 || perr.Col != tc.col {
+				t.Errorf("Frame(1) = %v, want a traj.ParseError about %s", err, tc.col)
+			}
+			_, err = r.Frame(2)
+			if err != nil {
+				t.Errorf("Frame(2): %v", err)
+			}
+
+			_, err = r.Frame(testFrames)
+			if !errors.As(err, &perr) || perr.Frame != testFrames {
+				t.Errorf("Frame(%d) = %v, want a traj.ParseError about the truncated frame", testFrames, err)
+			}
+		})
+	}
+}
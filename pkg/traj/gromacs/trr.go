@@ -0,0 +1,100 @@
+package gromacs
+
+import (
+	"fmt"
+
+	"github.com/kpotier/selfdiff/pkg/traj"
+)
+
+// trrMagic is the first integer of each frame of a TRR file.
+const trrMagic = 1993
+
+// trr reads one frame of a TRR file: a header giving the size of each block,
+// the box, the virial, the pressure, the positions, the velocities and the
+// forces. The blocks are in single or double precision.
+func trr(x *xdr, skip bool) (*traj.Frame, error) {
+	if x.int() != trrMagic && x.err == nil {
+		return nil, fmt.Errorf("not a TRR file")
+	}
+
+	// Version (GMX_trn_file)
+	x.int()
+	n := x.int()
+	if n < 0 || n > 128 {
+		return nil, fmt.Errorf("not a TRR file")
+	}
+	x.opaque(n)
+
+	// Sizes of the blocks: ir, e, box, vir, pres, top, sym, x, v and f
+	var sizes [10]int
+	for i := range sizes {
+		sizes[i] = x.int()
+	}
+	boxSize, virSize, presSize := sizes[2], sizes[3], sizes[4]
+	xSize, vSize, fSize := sizes[7], sizes[8], sizes[9]
+
+	f := &traj.Frame{Atoms: x.int(), Step: x.int()}
+	x.int() // Number of energies
+	if x.err != nil {
+		return nil, x.err
+	}
+
+	// Size of a real (4 or 8 bytes)
+	real := 4
+	switch {
+	case boxSize != 0:
+		real = boxSize / 9
+	case xSize != 0 && f.Atoms != 0:
+		real = xSize / (3 * f.Atoms)
+	case vSize != 0 && f.Atoms != 0:
+		real = vSize / (3 * f.Atoms)
+	case fSize != 0 && f.Atoms != 0:
+		real = fSize / (3 * f.Atoms)
+	}
+	if real != 4 && real != 8 {
+		return nil, fmt.Errorf("unable to get the precision")
+	}
+
+	f.Time = x.real(real)
+	x.real(real) // Lambda
+
+	if skip {
+		x.skip(int64(boxSize + virSize + presSize + xSize + vSize + fSize))
+		return f, x.err
+	}
+
+	var box [3][3]float64
+	if boxSize != 0 {
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				box[i][j] = x.real(real)
+			}
+		}
+	}
+	x.skip(int64(virSize + presSize))
+
+	for _, b := range []struct {
+		size  int
+		names [3]string
+	}{
+		{xSize, [3]string{"x", "y", "z"}},
+		{vSize, traj.Vel},
+		{fSize, [3]string{"fx", "fy", "fz"}},
+	} {
+		if b.size == 0 {
+			continue
+		}
+
+		v := make([]float64, 3*f.Atoms)
+		for i := range v {
+			v[i] = x.real(real)
+		}
+		cols(f, b.names, v)
+	}
+
+	if x.err != nil {
+		return nil, x.err
+	}
+
+	return f, f.SetCell(box[0], box[1], box[2])
+}
@@ -0,0 +1,158 @@
+package gromacs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kpotier/selfdiff/pkg/traj"
+)
+
+// testTRR describes the frames of a TRR file written by frame.
+type testTRR struct {
+	real       int // Size of the reals (4 or 8 bytes)
+	box        bool
+	x, v, f    bool // Blocks of the positions, velocities and forces
+	virAndPres bool // Blocks of the virial and the pressure
+}
+
+// trrBlocks are the columns of the blocks x, v and f and the factor of their
+// values (testCoord).
+var trrBlocks = []struct {
+	names  [3]string
+	factor float64
+}{
+	{[3]string{"x", "y", "z"}, 1},
+	{traj.Vel, -1},
+	{[3]string{"fx", "fy", "fz"}, 2},
+}
+
+// frame writes the frame i.
+func (d testTRR) frame(e *encoder, i int) {
+	e.int(trrMagic)
+	e.int(13)
+	e.int(12)
+	e.opaque([]byte("GMX_trn_file"))
+
+	size := func(ok bool, n int) int {
+		if ok {
+			return n * d.real
+		}
+		return 0
+	}
+	for _, s := range []int{
+		0, 0, // ir, e
+		size(d.box, 9), size(d.virAndPres, 9), size(d.virAndPres, 9),
+		0, 0, // top, sym
+		size(d.x, 3*testAtoms), size(d.v, 3*testAtoms), size(d.f, 3*testAtoms),
+	} {
+		e.int(s)
+	}
+
+	e.int(testAtoms)
+	e.int(testStep(i))
+	e.int(0) // Number of energies
+	e.real(testTime(i), d.real)
+	e.real(0.5, d.real) // Lambda
+
+	if d.box {
+		for _, u := range testBox(i) {
+			for _, x := range u {
+				e.real(x, d.real)
+			}
+		}
+	}
+	if d.virAndPres {
+		for j := 0; j < 18; j++ {
+			e.real(1e3, d.real)
+		}
+	}
+
+	for j, ok := range []bool{d.x, d.v, d.f} {
+		if !ok {
+			continue
+		}
+		for a := 0; a < testAtoms; a++ {
+			for k := 0; k < 3; k++ {
+				e.real(trrBlocks[j].factor*testCoord(i, a, k), d.real)
+			}
+		}
+	}
+}
+
+func TestTRR(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gromacs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The size of the reals is found from the first block present
+	for _, tc := range []struct {
+		name string
+		trr  testTRR
+	}{
+		{"single", testTRR{real: 4, box: true, x: true, v: true, f: true}},
+		{"double", testTRR{real: 8, box: true, x: true, v: true, f: true, virAndPres: true}},
+		{"without box", testTRR{real: 8, x: true, v: true}},
+		{"positions", testTRR{real: 4, box: true, x: true, virAndPres: true}},
+		{"velocities", testTRR{real: 8, v: true}},
+		{"forces", testTRR{real: 8, f: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := &encoder{}
+			for i := 0; i < testFrames; i++ {
+				tc.trr.frame(e, i)
+			}
+
+			name := filepath.Join(dir, "traj.trr")
+			err := ioutil.WriteFile(name, e.Bytes(), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := OpenTRR(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			n, err := r.Len()
+			if err != nil || n != testFrames {
+				t.Fatalf("Len() = %d, %v, want %d", n, err, testFrames)
+			}
+
+			for i := n - 1; i >= 0; i-- {
+				f, err := r.Frame(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var box [3][3]float64
+				if tc.trr.box {
+					box = testBox(i)
+				}
+				checkFrame(t, i, f, testStep(i), testTime(i), box)
+
+				if f.Atoms != testAtoms {
+					t.Fatalf("frame %d: Atoms = %d, want %d", i, f.Atoms, testAtoms)
+				}
+
+				for j, ok := range []bool{tc.trr.x, tc.trr.v, tc.trr.f} {
+					for k, name := range trrBlocks[j].names {
+						col := f.Col(name)
+						if (col != nil) != ok {
+							t.Fatalf("frame %d: column %s present = %v, want %v", i, name, col != nil, ok)
+						}
+						for a := range col {
+							if want := trrBlocks[j].factor * testCoord(i, a, k); col[a] != want {
+								t.Errorf("frame %d: %s of atom %d = %g, want %g", i, name, a, col[a], want)
+							}
+						}
+					}
+				}
+			}
+		})
+	}
+}
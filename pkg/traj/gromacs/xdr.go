@@ -0,0 +1,87 @@
+package gromacs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// xdr reads the XDR (big endian) values of a GROMACS file. The first error is
+// kept and the following reads do nothing.
+type xdr struct {
+	r   *bufio.Reader
+	n   int64 // Number of bytes read
+	err error
+	buf [8]byte
+}
+
+// read reads len(b) bytes.
+func (x *xdr) read(b []byte) {
+	if x.err != nil {
+		return
+	}
+
+	n, err := io.ReadFull(x.r, b)
+	x.n += int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	x.err = err
+}
+
+// int reads a 32-bit integer.
+func (x *xdr) int() int {
+	x.read(x.buf[:4])
+	if x.err != nil {
+		return 0
+	}
+	return int(int32(binary.BigEndian.Uint32(x.buf[:4])))
+}
+
+// float reads a 32-bit float.
+func (x *xdr) float() float64 {
+	x.read(x.buf[:4])
+	if x.err != nil {
+		return 0
+	}
+	return float64(math.Float32frombits(binary.BigEndian.Uint32(x.buf[:4])))
+}
+
+// double reads a 64-bit float.
+func (x *xdr) double() float64 {
+	x.read(x.buf[:8])
+	if x.err != nil {
+		return 0
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(x.buf[:8]))
+}
+
+// real reads a float of size bytes (4 or 8).
+func (x *xdr) real(size int) float64 {
+	if size == 8 {
+		return x.double()
+	}
+	return x.float()
+}
+
+// opaque reads n bytes padded to a multiple of 4 bytes.
+func (x *xdr) opaque(n int) []byte {
+	b := make([]byte, (n+3)/4*4)
+	x.read(b)
+	return b[:n]
+}
+
+// skip discards n bytes.
+func (x *xdr) skip(n int64) {
+	if x.err != nil {
+		return
+	}
+
+	m, err := x.r.Discard(int(n))
+	x.n += int64(m)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	x.err = err
+}
@@ -0,0 +1,337 @@
+package gromacs
+
+import (
+	"fmt"
+
+	"github.com/kpotier/selfdiff/pkg/traj"
+)
+
+// xtcMagic is the first integer of each frame of a XTC file.
+const xtcMagic = 1995
+
+// magicInts are the sizes used by the compression of the XTC files (see
+// xdrfile.c of GROMACS).
+var magicInts = [...]int{
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 8, 10, 12, 16, 20, 25, 32, 40, 50, 64,
+	80, 101, 128, 161, 203, 256, 322, 406, 512, 645, 812, 1024, 1290,
+	1625, 2048, 2580, 3250, 4096, 5060, 6501, 8192, 10321, 13003,
+	16384, 20642, 26007, 32768, 41285, 52015, 65536, 82570, 104031,
+	131072, 165140, 208063, 262144, 330280, 416127, 524287, 660561,
+	832255, 1048576, 1321122, 1664510, 2097152, 2642245, 3329021,
+	4194304, 5284491, 6658042, 8388607, 10568983, 13316085, 16777216,
+}
+
+// firstIdx is the first index of magicInts which isn't equal to 0.
+const firstIdx = 9
+
+// xtc reads one frame of a XTC file: a header (magic, number of atoms, step
+// and time), the box and the compressed positions.
+func xtc(x *xdr, skip bool) (*traj.Frame, error) {
+	if x.int() != xtcMagic && x.err == nil {
+		return nil, fmt.Errorf("not a XTC file")
+	}
+
+	f := &traj.Frame{Atoms: x.int(), Step: x.int()}
+	f.Time = x.float()
+
+	var box [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			box[i][j] = x.float()
+		}
+	}
+
+	if x.int() != f.Atoms && x.err == nil {
+		return nil, fmt.Errorf("number of atoms don't match")
+	}
+
+	// A few atoms aren't compressed
+	if f.Atoms <= 9 {
+		if skip {
+			x.skip(int64(12 * f.Atoms))
+			return f, x.err
+		}
+
+		v := make([]float64, 3*f.Atoms)
+		for i := range v {
+			v[i] = x.float()
+		}
+		if x.err != nil {
+			return nil, x.err
+		}
+
+		cols(f, [3]string{"x", "y", "z"}, v)
+		return f, f.SetCell(box[0], box[1], box[2])
+	}
+
+	prec := x.float()
+
+	var minInt, maxInt [3]int
+	for k := 0; k < 3; k++ {
+		minInt[k] = x.int()
+	}
+	for k := 0; k < 3; k++ {
+		maxInt[k] = x.int()
+	}
+	smallIdx := x.int()
+
+	n := x.int()
+	if x.err != nil {
+		return nil, x.err
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("invalid size of the compressed positions")
+	}
+
+	if skip {
+		x.skip(int64((n + 3) / 4 * 4))
+		return f, x.err
+	}
+
+	b := x.opaque(n)
+	if x.err != nil {
+		return nil, x.err
+	}
+
+	v, err := decompress(b, f.Atoms, prec, minInt, maxInt, smallIdx)
+	if err != nil {
+		return nil, err
+	}
+
+	cols(f, [3]string{"x", "y", "z"}, v)
+	return f, f.SetCell(box[0], box[1], box[2])
+}
+
+// decompress decompresses the positions of n atoms (see
+// xdrfile_decompress_coord_float in xdrfile.c of GROMACS).
+func decompress(b []byte, n int, prec float64, minInt, maxInt [3]int, smallIdx int) ([]float64, error) {
+	var sizeInt, bitSizeInt [3]int
+	for k := 0; k < 3; k++ {
+		sizeInt[k] = maxInt[k] - minInt[k] + 1
+	}
+
+	// Large coordinates are read one by one
+	bitSize := 0
+	if (sizeInt[0] | sizeInt[1] | sizeInt[2]) > 0xffffff {
+		for k := 0; k < 3; k++ {
+			bitSizeInt[k] = sizeOfInt(sizeInt[k])
+		}
+	} else {
+		bitSize = sizeOfInts(sizeInt[:])
+	}
+
+	if smallIdx < firstIdx || smallIdx >= len(magicInts) {
+		return nil, fmt.Errorf("invalid compression of the positions")
+	}
+
+	smaller := magicInts[firstIdx] / 2
+	if smallIdx-1 > firstIdx {
+		smaller = magicInts[smallIdx-1] / 2
+	}
+	smallNum := magicInts[smallIdx] / 2
+	sizeSmall := [3]int{magicInts[smallIdx], magicInts[smallIdx], magicInts[smallIdx]}
+
+	var (
+		bits      = &bits{b: b}
+		v         = make([]float64, 0, 3*n)
+		run       int
+		thisCoord [3]int
+		prevCoord [3]int
+	)
+
+	for i := 0; i < n; {
+		if bitSize == 0 {
+			for k := 0; k < 3; k++ {
+				thisCoord[k] = bits.receive(bitSizeInt[k])
+			}
+		} else {
+			bits.receiveInts(bitSize, sizeInt, &thisCoord)
+		}
+		i++
+
+		for k := 0; k < 3; k++ {
+			thisCoord[k] += minInt[k]
+		}
+		prevCoord = thisCoord
+
+		isSmaller := 0
+		if bits.receive(1) == 1 {
+			run = bits.receive(5)
+			isSmaller = run % 3
+			run -= isSmaller
+			isSmaller--
+		}
+
+		if run > 0 {
+			if i+run/3 > n {
+				return nil, fmt.Errorf("invalid compression of the positions")
+			}
+
+			for r := 0; r < run; r += 3 {
+				bits.receiveInts(smallIdx, sizeSmall, &thisCoord)
+				i++
+
+				for k := 0; k < 3; k++ {
+					thisCoord[k] += prevCoord[k] - smallNum
+				}
+
+				// The first two atoms are interchanged for a better
+				// compression of the water molecules
+				if r == 0 {
+					thisCoord, prevCoord = prevCoord, thisCoord
+					for k := 0; k < 3; k++ {
+						v = append(v, float64(prevCoord[k])/prec)
+					}
+				} else {
+					prevCoord = thisCoord
+				}
+
+				for k := 0; k < 3; k++ {
+					v = append(v, float64(thisCoord[k])/prec)
+				}
+			}
+		} else {
+			for k := 0; k < 3; k++ {
+				v = append(v, float64(thisCoord[k])/prec)
+			}
+		}
+
+		smallIdx += isSmaller
+		if smallIdx < firstIdx || smallIdx >= len(magicInts) {
+			return nil, fmt.Errorf("invalid compression of the positions")
+		}
+
+		if isSmaller < 0 {
+			smallNum = smaller
+			if smallIdx > firstIdx {
+				smaller = magicInts[smallIdx-1] / 2
+			} else {
+				smaller = 0
+			}
+		} else if isSmaller > 0 {
+			smaller = smallNum
+			smallNum = magicInts[smallIdx] / 2
+		}
+		sizeSmall = [3]int{magicInts[smallIdx], magicInts[smallIdx], magicInts[smallIdx]}
+
+		if bits.err != nil {
+			return nil, bits.err
+		}
+	}
+
+	return v, nil
+}
+
+// bits reads the compressed positions bit by bit.
+type bits struct {
+	b        []byte
+	cnt      int
+	lastBits uint
+	lastByte uint32
+	err      error
+}
+
+// receive returns the next n bits.
+func (b *bits) receive(n int) int {
+	mask := uint32(1)<<uint(n) - 1
+	var num uint32
+
+	for n >= 8 {
+		b.lastByte = b.lastByte<<8 | uint32(b.byte())
+		num |= (b.lastByte >> b.lastBits) << uint(n-8)
+		n -= 8
+	}
+
+	if n > 0 {
+		if b.lastBits < uint(n) {
+			b.lastBits += 8
+			b.lastByte = b.lastByte<<8 | uint32(b.byte())
+		}
+		b.lastBits -= uint(n)
+		num |= (b.lastByte >> b.lastBits) & (uint32(1)<<uint(n) - 1)
+	}
+
+	return int(num & mask)
+}
+
+// byte returns the next byte.
+func (b *bits) byte() byte {
+	if b.cnt >= len(b.b) {
+		b.err = fmt.Errorf("invalid compression of the positions")
+		return 0
+	}
+	b.cnt++
+	return b.b[b.cnt-1]
+}
+
+// receiveInts reads 3 integers stored in n bits. Their sizes (upper bounds)
+// are sizes.
+func (b *bits) receiveInts(n int, sizes [3]int, nums *[3]int) {
+	var bytes [32]byte
+	nBytes := 0
+
+	for n > 8 {
+		bytes[nBytes] = byte(b.receive(8))
+		nBytes++
+		n -= 8
+	}
+	if n > 0 {
+		bytes[nBytes] = byte(b.receive(n))
+		nBytes++
+	}
+
+	for k := 2; k > 0; k-- {
+		num := 0
+		for j := nBytes - 1; j >= 0; j-- {
+			num = num<<8 | int(bytes[j])
+			p := num / sizes[k]
+			bytes[j] = byte(p)
+			num -= p * sizes[k]
+		}
+		nums[k] = num
+	}
+
+	nums[0] = int(bytes[0]) | int(bytes[1])<<8 | int(bytes[2])<<16 | int(bytes[3])<<24
+}
+
+// sizeOfInt returns the number of bits needed to store an integer lower than
+// size.
+func sizeOfInt(size int) int {
+	num, n := 1, 0
+	for size >= num && n < 32 {
+		n++
+		num <<= 1
+	}
+	return n
+}
+
+// sizeOfInts returns the number of bits needed to store integers lower than
+// sizes.
+func sizeOfInts(sizes []int) int {
+	var bytes [32]int
+	bytes[0] = 1
+	nBytes := 1
+
+	for _, size := range sizes {
+		tmp, j := 0, 0
+		for ; j < nBytes; j++ {
+			tmp += bytes[j] * size
+			bytes[j] = tmp & 0xff
+			tmp >>= 8
+		}
+		for tmp != 0 {
+			bytes[j] = tmp & 0xff
+			tmp >>= 8
+			j++
+		}
+		nBytes = j
+	}
+
+	num, n := 1, 0
+	nBytes--
+	for bytes[nBytes] >= num {
+		n++
+		num *= 2
+	}
+	return n + nBytes*8
+}
@@ -0,0 +1,498 @@
+package gromacs
+
+import (
+	"io/ioutil"
+	"math"
+	"math/big"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testXTC describes the frames of a XTC file written by frame.
+type testXTC struct {
+	box  bool
+	prec float64
+	pos  [][]float64 // Positions of each frame (x1 y1 z1 x2...), testCoord if nil
+}
+
+// frame writes the frame i. It returns the positions read back and the paths
+// taken by the compression.
+func (d testXTC) frame(e *encoder, i int) ([]float64, xtcPaths) {
+	var v []float64
+	if d.pos != nil {
+		v = d.pos[i]
+	} else {
+		for a := 0; a < testAtoms; a++ {
+			for k := 0; k < 3; k++ {
+				v = append(v, testCoord(i, a, k))
+			}
+		}
+	}
+
+	var box [3][3]float64
+	if d.box {
+		box = testBox(i)
+	}
+
+	e.int(xtcMagic)
+	e.int(len(v) / 3)
+	e.int(testStep(i))
+	e.float(testTime(i))
+	for _, u := range box {
+		for _, x := range u {
+			e.float(x)
+		}
+	}
+	e.int(len(v) / 3)
+
+	// A few atoms aren't compressed
+	if len(v) <= 3*9 {
+		want := make([]float64, len(v))
+		for j, x := range v {
+			e.float(x)
+			want[j] = float64(float32(x))
+		}
+		return want, xtcPaths{}
+	}
+
+	return compress(e, v, d.prec)
+}
+
+// xtcPaths counts the paths taken by compress.
+type xtcPaths struct {
+	swaps   int // First two atoms of a run interchanged
+	same    int // Run lengths which didn't change
+	smaller int // Decreases of the size of the small integers
+	larger  int // Increases of the size of the small integers
+}
+
+// bitWriter writes the compressed positions bit by bit.
+type bitWriter struct {
+	b        []byte
+	lastBits uint
+	lastByte uint32
+}
+
+// send writes the n lowest bits of num.
+func (w *bitWriter) send(n int, num int) {
+	for n >= 8 {
+		w.lastByte = w.lastByte<<8 | uint32(num>>uint(n-8))&0xff
+		w.b = append(w.b, byte(w.lastByte>>w.lastBits))
+		n -= 8
+	}
+	if n > 0 {
+		w.lastByte = w.lastByte<<uint(n) | uint32(num)&(1<<uint(n)-1)
+		w.lastBits += uint(n)
+		if w.lastBits >= 8 {
+			w.lastBits -= 8
+			w.b = append(w.b, byte(w.lastByte>>w.lastBits))
+		}
+	}
+}
+
+// sendInts writes 3 integers lower than sizes in n bits.
+func (w *bitWriter) sendInts(n int, sizes, nums [3]int) {
+	bytes := []int{}
+	tmp := nums[0]
+	for {
+		bytes = append(bytes, tmp&0xff)
+		tmp >>= 8
+		if tmp == 0 {
+			break
+		}
+	}
+
+	for k := 1; k < 3; k++ {
+		tmp = nums[k]
+		for j := range bytes {
+			tmp += bytes[j] * sizes[k]
+			bytes[j] = tmp & 0xff
+			tmp >>= 8
+		}
+		for tmp != 0 {
+			bytes = append(bytes, tmp&0xff)
+			tmp >>= 8
+		}
+	}
+
+	if n >= 8*len(bytes) {
+		for _, b := range bytes {
+			w.send(8, b)
+		}
+		w.send(n-8*len(bytes), 0)
+		return
+	}
+	for _, b := range bytes[:len(bytes)-1] {
+		w.send(8, b)
+	}
+	w.send(n-8*(len(bytes)-1), bytes[len(bytes)-1])
+}
+
+// bytes returns the written bytes, the last one being completed with zeros.
+func (w *bitWriter) bytes() []byte {
+	if w.lastBits > 0 {
+		return append(w.b, byte(w.lastByte<<(8-w.lastBits)))
+	}
+	return w.b
+}
+
+// compress writes the positions v (x1 y1 z1 x2...) compressed with the
+// precision prec (see xdrfile_compress_coord_float in xdrfile.c of GROMACS).
+// It returns the positions read back and the paths taken by the compression.
+func compress(e *encoder, v []float64, prec float64) ([]float64, xtcPaths) {
+	var p xtcPaths
+	n := len(v) / 3
+
+	ints := make([]int, len(v))
+	want := make([]float64, len(v))
+	minInt := [3]int{math.MaxInt32, math.MaxInt32, math.MaxInt32}
+	maxInt := [3]int{math.MinInt32, math.MinInt32, math.MinInt32}
+	for j, x := range v {
+		ints[j] = int(math.Round(x * prec))
+		want[j] = float64(ints[j]) / float64(float32(prec))
+		if ints[j] < minInt[j%3] {
+			minInt[j%3] = ints[j]
+		}
+		if ints[j] > maxInt[j%3] {
+			maxInt[j%3] = ints[j]
+		}
+	}
+
+	minDiff := math.MaxInt32
+	for a := 1; a < n; a++ {
+		diff := 0
+		for k := 0; k < 3; k++ {
+			diff += abs(ints[3*a+k] - ints[3*a-3+k])
+		}
+		if diff < minDiff {
+			minDiff = diff
+		}
+	}
+
+	e.float(prec)
+	for _, m := range [][3]int{minInt, maxInt} {
+		for k := 0; k < 3; k++ {
+			e.int(m[k])
+		}
+	}
+
+	var sizeInt, bitSizeInt [3]int
+	for k := 0; k < 3; k++ {
+		sizeInt[k] = maxInt[k] - minInt[k] + 1
+	}
+	bitSize := 0
+	if (sizeInt[0] | sizeInt[1] | sizeInt[2]) > 0xffffff {
+		for k := 0; k < 3; k++ {
+			bitSizeInt[k] = sizeOfInt(sizeInt[k])
+		}
+	} else {
+		bitSize = sizeOfInts(sizeInt[:])
+	}
+
+	last := len(magicInts) - 1
+	smallIdx := firstIdx
+	for smallIdx < last && magicInts[smallIdx] < minDiff {
+		smallIdx++
+	}
+	e.int(smallIdx)
+
+	maxIdx := smallIdx + 8
+	if maxIdx > last {
+		maxIdx = last
+	}
+	minIdx := maxIdx - 8
+	smaller := magicInts[firstIdx] / 2
+	if smallIdx-1 > firstIdx {
+		smaller = magicInts[smallIdx-1] / 2
+	}
+	smallNum := magicInts[smallIdx] / 2
+	sizeSmall := [3]int{magicInts[smallIdx], magicInts[smallIdx], magicInts[smallIdx]}
+	larger := magicInts[maxIdx]
+
+	// near returns true if the atom a is closer than d to prev
+	near := func(a int, prev []int, d int) bool {
+		return abs(ints[3*a]-prev[0]) < d && abs(ints[3*a+1]-prev[1]) < d && abs(ints[3*a+2]-prev[2]) < d
+	}
+
+	w := &bitWriter{}
+	prevRun := -1
+	var prevCoord [3]int
+	for i := 0; i < n; {
+		thisCoord := ints[3*i : 3*i+3]
+
+		isSmaller := 0
+		if smallIdx < maxIdx && i >= 1 && near(i, prevCoord[:], larger) {
+			isSmaller = 1
+		} else if smallIdx > minIdx {
+			isSmaller = -1
+		}
+
+		// The first two atoms are interchanged for a better compression of
+		// the water molecules
+		isSmall := false
+		if i+1 < n && near(i+1, thisCoord, smallNum) {
+			for k := 0; k < 3; k++ {
+				ints[3*i+k], ints[3*i+3+k] = ints[3*i+3+k], ints[3*i+k]
+			}
+			isSmall = true
+			p.swaps++
+		}
+
+		var tmp [3]int
+		for k := 0; k < 3; k++ {
+			tmp[k] = thisCoord[k] - minInt[k]
+		}
+		if bitSize == 0 {
+			for k := 0; k < 3; k++ {
+				w.send(bitSizeInt[k], tmp[k])
+			}
+		} else {
+			w.sendInts(bitSize, sizeInt, tmp)
+		}
+		copy(prevCoord[:], thisCoord)
+		i++
+
+		if !isSmall && isSmaller == -1 {
+			isSmaller = 0
+		}
+
+		var small [][3]int
+		for isSmall && len(small) < 8 {
+			thisCoord = ints[3*i : 3*i+3]
+			sum := 0
+			for k := 0; k < 3; k++ {
+				d := thisCoord[k] - prevCoord[k]
+				sum += d * d
+				tmp[k] = d + smallNum
+			}
+			if isSmaller == -1 && sum >= smaller*smaller {
+				isSmaller = 0
+			}
+			small = append(small, tmp)
+
+			copy(prevCoord[:], thisCoord)
+			i++
+			isSmall = i < n && near(i, prevCoord[:], smallNum)
+		}
+
+		run := 3 * len(small)
+		if run != prevRun || isSmaller != 0 {
+			prevRun = run
+			w.send(1, 1)
+			w.send(5, run+isSmaller+1)
+		} else {
+			w.send(1, 0)
+			p.same++
+		}
+		for _, s := range small {
+			w.sendInts(smallIdx, sizeSmall, s)
+		}
+
+		smallIdx += isSmaller
+		if isSmaller < 0 {
+			smallNum = smaller
+			smaller = magicInts[smallIdx-1] / 2
+			p.smaller++
+		} else if isSmaller > 0 {
+			smaller = smallNum
+			smallNum = magicInts[smallIdx] / 2
+			p.larger++
+		}
+		sizeSmall = [3]int{magicInts[smallIdx], magicInts[smallIdx], magicInts[smallIdx]}
+	}
+
+	b := w.bytes()
+	e.int(len(b))
+	e.opaque(b)
+	return want, p
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// water returns the positions of mols water molecules in a box of length l,
+// an ion following every fourth molecule, and of a chain of closely spaced
+// atoms.
+func water(rng *rand.Rand, mols int, l float64) []float64 {
+	var v []float64
+	for m := 0; m < mols; m++ {
+		var o [3]float64
+		for k := range o {
+			o[k] = l * rng.Float64()
+			v = append(v, o[k])
+		}
+		for h := 0; h < 2; h++ {
+			for k := range o {
+				v = append(v, o[k]+0.2*rng.Float64()-0.1)
+			}
+		}
+		if m%4 == 3 {
+			for k := 0; k < 3; k++ {
+				v = append(v, l*rng.Float64())
+			}
+		}
+	}
+
+	x := [3]float64{l * rng.Float64(), l * rng.Float64(), l * rng.Float64()}
+	for a := 0; a < 30; a++ {
+		for k := range x {
+			v = append(v, x[k]+0.002*float64(a*(k+1)))
+		}
+	}
+	return v
+}
+
+func TestXTC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gromacs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rng := rand.New(rand.NewSource(1))
+	configs := func(mols int, l float64) [][]float64 {
+		pos := make([][]float64, testFrames)
+		for i := range pos {
+			pos[i] = water(rng, mols, l)
+		}
+		return pos
+	}
+
+	// The sizes of the coordinates with a precision of 10^6 are too large to
+	// be compressed together
+	for _, tc := range []struct {
+		name string
+		xtc  testXTC
+	}{
+		{"uncompressed", testXTC{box: true}},
+		{"uncompressed without box", testXTC{}},
+		{"water", testXTC{box: true, prec: 1000, pos: configs(200, 3)}},
+		{"large", testXTC{box: true, prec: 1e6, pos: configs(50, 25)}},
+		{"without box", testXTC{prec: 100, pos: configs(20, 30)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := &encoder{}
+			var want [][]float64
+			var p xtcPaths
+			for i := 0; i < testFrames; i++ {
+				v, pi := tc.xtc.frame(e, i)
+				want = append(want, v)
+				p.swaps += pi.swaps
+				p.same += pi.same
+				p.smaller += pi.smaller
+				p.larger += pi.larger
+			}
+			if tc.xtc.pos != nil && (p.swaps == 0 || p.same == 0 || p.smaller == 0 || p.larger == 0) {
+				t.Fatalf("the positions don't take every path of the compression: %+v", p)
+			}
+
+			name := filepath.Join(dir, "traj.xtc")
+			err := ioutil.WriteFile(name, e.Bytes(), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := OpenXTC(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			n, err := r.Len()
+			if err != nil || n != testFrames {
+				t.Fatalf("Len() = %d, %v, want %d", n, err, testFrames)
+			}
+
+			// Backward once the frames are found
+			for i := n - 1; i >= 0; i-- {
+				f, err := r.Frame(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var box [3][3]float64
+				if tc.xtc.box {
+					box = testBox(i)
+				}
+				checkFrame(t, i, f, testStep(i), testTime(i), box)
+
+				if f.Atoms != len(want[i])/3 {
+					t.Fatalf("frame %d: Atoms = %d, want %d", i, f.Atoms, len(want[i])/3)
+				}
+				for a := 0; a < f.Atoms; a++ {
+					for k, name := range [3]string{"x", "y", "z"} {
+						if v := f.Col(name)[a]; v != want[i][3*a+k] {
+							t.Fatalf("frame %d: %s of atom %d = %g, want %g", i, name, a, v, want[i][3*a+k])
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBits(t *testing.T) {
+	// 10110101 00111100
+	b := &bits{b: []byte{0xb5, 0x3c}}
+	for _, c := range []struct{ n, want int }{{3, 5}, {6, 42}, {7, 60}} {
+		if v := b.receive(c.n); v != c.want || b.err != nil {
+			t.Fatalf("receive(%d) = %d, %v, want %d", c.n, v, b.err, c.want)
+		}
+	}
+	b.receive(1)
+	if b.err == nil {
+		t.Errorf("receive read past the end")
+	}
+
+	// (7*20 + 13)*30 + 29 = 0x120b in 13 bits, the lowest byte first
+	b = &bits{b: []byte{0x0b, 0x90}}
+	var nums [3]int
+	b.receiveInts(13, [3]int{10, 20, 30}, &nums)
+	if nums != [3]int{7, 13, 29} || b.err != nil {
+		t.Errorf("receiveInts = %v, %v, want [7 13 29]", nums, b.err)
+	}
+
+	for _, c := range []struct{ size, want int }{{1, 1}, {255, 8}, {256, 9}, {0x1000000, 25}} {
+		if n := sizeOfInt(c.size); n != c.want {
+			t.Errorf("sizeOfInt(%d) = %d, want %d", c.size, n, c.want)
+		}
+	}
+	for _, c := range []struct {
+		sizes []int
+		want  int
+	}{{[]int{10, 20, 30}, 13}, {[]int{255, 1, 1}, 8}, {[]int{256, 256, 256}, 25}} {
+		if n := sizeOfInts(c.sizes); n != c.want {
+			t.Errorf("sizeOfInts(%v) = %d, want %d", c.sizes, n, c.want)
+		}
+	}
+}
+
+func TestMagicInts(t *testing.T) {
+	if len(magicInts) != 73 {
+		t.Fatalf("%d magic integers, want 73", len(magicInts))
+	}
+
+	// Three integers lower than magicInts[i] are stored in i bits
+	for i, m := range magicInts {
+		if (i < firstIdx) != (m == 0) {
+			t.Errorf("magicInts[%d] = %d with the first index %d", i, m, firstIdx)
+		}
+		if i < firstIdx {
+			continue
+		}
+		if i > firstIdx && m <= magicInts[i-1] {
+			t.Errorf("magicInts[%d] = %d isn't greater than magicInts[%d] = %d", i, m, i-1, magicInts[i-1])
+		}
+
+		cube := new(big.Int).Exp(big.NewInt(int64(m)), big.NewInt(3), nil)
+		if cube.Cmp(new(big.Int).Lsh(big.NewInt(1), uint(i))) > 0 {
+			t.Errorf("magicInts[%d] = %d doesn't fit in %d bits", i, m, i)
+		}
+	}
+}
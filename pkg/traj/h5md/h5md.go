@@ -0,0 +1,420 @@
+// Package h5md reads the trajectories of H5MD files (e.g: LAMMPS, ESPResSo,
+// HOOMD-blue or MDAnalysis) and writes the results of the calculations into
+// H5MD files.
+package h5md
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/kpotier/selfdiff/pkg/hdf5"
+	"github.com/kpotier/selfdiff/pkg/traj"
+)
+
+// element is a time-dependent or time-independent element of a particles
+// group (e.g: position or mass).
+type element struct {
+	value *hdf5.Dataset
+
+	// Step and time of each row of a time-dependent element. The rows are
+	// found by their step
+	steps []int
+	times []float64
+	rows  map[int]int
+
+	fixed []float64 // Values of a time-independent element
+}
+
+// column is an element of a particles group and the columns it gives.
+type column struct {
+	name  string
+	names []string
+}
+
+// columns are the elements of a particles group read in addition to the
+// position.
+var columns = []column{
+	{"velocity", traj.Vel[:]},
+	{"force", []string{"fx", "fy", "fz"}},
+	{"image", []string{"ix", "iy", "iz"}},
+	{"id", []string{"id"}},
+	{"species", []string{"type"}},
+	{"mass", []string{"mass"}},
+}
+
+// Reader is a reader of H5MD trajectories. It implements the traj.Reader
+// interface. The particles group all is read if it exists, otherwise the file
+// must contain a single particles group.
+//
+// The element position gives the columns x y z, and xu yu zu if there is no
+// box or if it has no periodic boundary (its attribute boundary is none in each
+// dimension).
+// Otherwise, the trajectory must be unwrapped (pbc). The elements velocity,
+// force and image give the columns vx vy vz, fx fy fz and ix iy iz, and the
+// elements id, species and mass the columns id, type and mass. They can be
+// time-dependent, in which case they are read for the steps of the positions,
+// or time-independent. The box is given by box/edges (and box/offset). The
+// step and the time of each frame are the ones of the positions.
+//
+// The malformed frames return a traj.ParseError.
+type Reader struct {
+	name  string
+	f     *hdf5.File
+	group string
+
+	atoms int
+	n     int // Number of frames
+
+	pos   *element
+	elems []*element // One for each of columns (nil if absent)
+	edges *element
+	off   *element
+	nopbc bool // No periodic boundary in any dimension
+}
+
+// Open opens the H5MD file name and finds the elements of its particles group.
+func Open(name string) (*Reader, error) {
+	f, err := hdf5.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reader{name: name, f: f}
+	err = r.header()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// header finds the particles group and its elements.
+func (r *Reader) header() error {
+	if !r.f.Exists("/h5md") {
+		return fmt.Errorf("not a H5MD file")
+	}
+
+	groups, err := r.f.Links("/particles")
+	if err != nil {
+		return fmt.Errorf("unable to get the particles groups: %w", err)
+	}
+
+	switch {
+	case contains(groups, "all"):
+		r.group = "/particles/all"
+	case len(groups) == 1:
+		r.group = "/particles/" + groups[0]
+	default:
+		return fmt.Errorf("several particles groups (%s) and none of them is all", strings.Join(groups, ", "))
+	}
+
+	r.pos, err = r.element("position")
+	if err != nil {
+		return err
+	}
+	if r.pos == nil || r.pos.steps == nil {
+		return fmt.Errorf("%s: unable to get the positions", r.group)
+	}
+
+	shape := r.pos.value.Shape
+	if len(shape) != 3 || shape[2] != 3 {
+		return fmt.Errorf("%s: only the positions in 3 dimensions are supported", r.group)
+	}
+	r.n, r.atoms = len(r.pos.steps), shape[1]
+
+	for _, c := range columns {
+		e, err := r.element(c.name)
+		if err != nil {
+			return err
+		}
+
+		// Each atom has one value for each column
+		size := len(c.names) * r.atoms
+		if e != nil && ((e.value == nil && len(e.fixed) != size) || (e.value != nil && rowSize(e.value) != size)) {
+			return fmt.Errorf("%s/%s: invalid shape", r.group, c.name)
+		}
+		r.elems = append(r.elems, e)
+	}
+
+	r.edges, err = r.element("box/edges")
+	if err != nil {
+		return err
+	}
+	r.off, err = r.element("box/offset")
+	if err != nil {
+		return err
+	}
+
+	// The boundary is periodic if it can't be read
+	a, err := r.f.Attr(r.group+"/box", "boundary")
+	if err == nil && len(a.Str) == 3 {
+		r.nopbc = a.Str[0] == "none" && a.Str[1] == "none" && a.Str[2] == "none"
+	}
+	return nil
+}
+
+// element reads the element name of the particles group. It returns nil if
+// the element doesn't exist.
+func (r *Reader) element(name string) (*element, error) {
+	p := r.group + "/" + name
+	if !r.f.Exists(p) {
+		return nil, nil
+	}
+
+	// A time-independent element is a dataset
+	if !r.f.Exists(p + "/value") {
+		d, err := r.f.Dataset(p)
+		if err != nil {
+			return nil, err
+		}
+		v, err := d.Read()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		return &element{fixed: v}, nil
+	}
+
+	d, err := r.f.Dataset(p + "/value")
+	if err != nil {
+		return nil, err
+	}
+	if len(d.Shape) == 0 {
+		return nil, fmt.Errorf("%s: invalid shape", p)
+	}
+	e := &element{value: d, rows: make(map[int]int)}
+
+	steps, err := r.series(p+"/step", d.Shape[0])
+	if err != nil {
+		return nil, err
+	}
+	e.steps = make([]int, len(steps))
+	for i, s := range steps {
+		e.steps[i] = int(s)
+		e.rows[e.steps[i]] = i
+	}
+
+	// The time is optional in H5MD 1.1
+	if r.f.Exists(p + "/time") {
+		e.times, err = r.series(p+"/time", len(e.steps))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
+
+// series returns the n values of the dataset name (step or time) of a
+// time-dependent element. A scalar dataset is the interval between two rows,
+// the first one being given by its attribute offset. If the dataset has fewer
+// values than n (e.g: the simulation has been interrupted), they are all
+// returned.
+func (r *Reader) series(name string, n int) ([]float64, error) {
+	d, err := r.f.Dataset(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(d.Shape) == 0 {
+		v, err := d.Read()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		var offset float64
+		a, err := r.f.Attr(name, "offset")
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if err == nil && len(a.Num) == 1 {
+			offset = a.Num[0]
+		}
+
+		s := make([]float64, n)
+		for i := range s {
+			s[i] = offset + float64(i)*v[0]
+		}
+		return s, nil
+	}
+
+	if len(d.Shape) != 1 {
+		return nil, fmt.Errorf("%s: invalid shape", name)
+	}
+	if d.Shape[0] < n {
+		n = d.Shape[0]
+	}
+
+	v, err := d.Rows(0, n)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return v, nil
+}
+
+// values returns the values of the element e at the step step. It returns nil
+// if e doesn't exist or if it has no row for this step.
+func (r *Reader) values(e *element, step int) ([]float64, error) {
+	if e == nil {
+		return nil, nil
+	}
+	if e.value == nil {
+		return e.fixed, nil
+	}
+
+	row, ok := e.rows[step]
+	if !ok {
+		return nil, nil
+	}
+	return e.value.Rows(row, 1)
+}
+
+// Len is part of the traj.Reader interface.
+func (r *Reader) Len() (int, error) {
+	return r.n, nil
+}
+
+// Frame is part of the traj.Reader interface. The box and the values must be
+// finite numbers.
+func (r *Reader) Frame(i int) (*traj.Frame, error) {
+	if i < 0 || i >= r.n {
+		return nil, fmt.Errorf("frame %d: %w", i, io.EOF)
+	}
+
+	f := &traj.Frame{Step: r.pos.steps[i], Atoms: r.atoms, Cols: make(map[string][]float64)}
+	if i < len(r.pos.times) {
+		f.Time = r.pos.times[i]
+	}
+
+	pos, err := r.pos.value.Rows(i, 1)
+	if err != nil {
+		return nil, r.error(i, "", fmt.Errorf("position: %w", err))
+	}
+	err = r.add(i, f, []string{"x", "y", "z"}, pos)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, c := range columns {
+		v, err := r.values(r.elems[k], f.Step)
+		if err != nil {
+			return nil, r.error(i, "", fmt.Errorf("%s: %w", c.name, err))
+		}
+		if v == nil {
+			continue
+		}
+
+		err = r.add(i, f, c.names, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The positions are rotated with a triclinic box (see traj.Frame.SetCell)
+	err = r.box(f)
+	if err != nil {
+		return nil, r.error(i, "", err)
+	}
+
+	// The positions can't be wrapped without periodic boundary
+	if r.nopbc || f.NoCell() {
+		f.SetUnwrapped()
+	}
+
+	return f, nil
+}
+
+// add adds the columns names of the values v (one row per atom) to the frame
+// f (index i). The values must be finite.
+func (r *Reader) add(i int, f *traj.Frame, names []string, v []float64) error {
+	for k, name := range names {
+		col := make([]float64, r.atoms)
+		for a := range col {
+			col[a] = v[len(names)*a+k]
+			if math.IsNaN(col[a]) || math.IsInf(col[a], 0) {
+				return r.error(i, name, fmt.Errorf("invalid value %g (atom %d)", col[a], a+1))
+			}
+		}
+		f.Cols[name] = col
+		f.Names = append(f.Names, name)
+	}
+	return nil
+}
+
+// box sets the box of the frame f. The edges are either the lengths of a
+// rectangular box or the vectors of a triclinic one.
+func (r *Reader) box(f *traj.Frame) error {
+	edges, err := r.values(r.edges, f.Step)
+	if err != nil {
+		return fmt.Errorf("box: %w", err)
+	}
+	off, err := r.values(r.off, f.Step)
+	if err != nil {
+		return fmt.Errorf("box: %w", err)
+	}
+
+	for _, v := range append(append([]float64{}, edges...), off...) {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("box: invalid value %g", v)
+		}
+	}
+
+	switch len(edges) {
+	case 0: // No box (e.g: boundary none)
+	case 3:
+		f.Box = [3][2]float64{{0, edges[0]}, {0, edges[1]}, {0, edges[2]}}
+	case 9:
+		var cell [3][3]float64
+		for k := range cell {
+			copy(cell[k][:], edges[3*k:])
+		}
+		err = f.SetCell(cell[0], cell[1], cell[2])
+		if err != nil {
+			return fmt.Errorf("box: %w", err)
+		}
+	default:
+		return fmt.Errorf("box: only the boxes in 3 dimensions are supported")
+	}
+
+	if len(off) == 3 {
+		for k := 0; k < 3; k++ {
+			f.Box[k][0] += off[k]
+			f.Box[k][1] += off[k]
+		}
+	}
+
+	return nil
+}
+
+// Close is part of the traj.Reader interface.
+func (r *Reader) Close() error {
+	return r.f.Close()
+}
+
+// error returns the traj.ParseError of the frame i about the column col.
+func (r *Reader) error(i int, col string, err error) error {
+	return &traj.ParseError{File: r.name, Frame: i, Col: col, Err: err}
+}
+
+// rowSize returns the number of values of a row of the dataset d.
+func rowSize(d *hdf5.Dataset) int {
+	n := 1
+	for _, s := range d.Shape[1:] {
+		n *= s
+	}
+	return n
+}
+
+// contains returns true if s is in list.
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
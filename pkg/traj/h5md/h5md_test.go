@@ -0,0 +1,288 @@
+package h5md
+
+import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/kpotier/selfdiff/pkg/hdf5"
+	"github.com/kpotier/selfdiff/pkg/traj"
+)
+
+const (
+	testAtoms  = 3
+	testFrames = 4
+)
+
+// testCoord returns the coordinate k of the atom a in the frame i.
+func testCoord(i, a, k int) float64 {
+	return float64(a) + 0.25*float64(k) + 0.5*float64(i)
+}
+
+// testCell returns the cell of the frame i (a along x and b in the xy plane).
+func testCell(i int) [3][3]float64 {
+	return [3][3]float64{{10 + float64(i), 0, 0}, {1, 11, 0}, {-2, 0.5, 12}}
+}
+
+// testH5MD describes a H5MD file written by write.
+type testH5MD struct {
+	box      string // No box, fixed, time-dependent or triclinic
+	boundary string
+	scalar   bool // Scalar step and time datasets
+}
+
+// element adds the time-dependent element name of the rows rows (one per
+// frame of the steps steps) to the group g.
+func (h testH5MD) element(g *hdf5.Object, name string, steps []int, shape []int, rows [][]float64) {
+	e := g.Group(name)
+
+	var value []float64
+	for _, r := range rows {
+		value = append(value, r...)
+	}
+	e.Dataset("value", append([]int{len(rows)}, shape...), value)
+
+	if h.scalar {
+		e.Dataset("step", nil, []float64{float64(steps[1] - steps[0])}).Attr("offset", steps[0])
+		e.Dataset("time", nil, []float64{0.5 * float64(steps[1]-steps[0])}).Attr("offset", 0.5*float64(steps[0]))
+		return
+	}
+
+	var step, time []float64
+	for _, s := range steps {
+		step = append(step, float64(s))
+		time = append(time, 0.5*float64(s))
+	}
+	e.Dataset("step", []int{len(steps)}, step)
+	e.Dataset("time", []int{len(steps)}, time)
+}
+
+// write writes the H5MD file name. The velocities are only given every other
+// frame.
+func (h testH5MD) write(t *testing.T, name string) {
+	w, err := Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all := w.Root().Group("particles").Group("all")
+
+	var steps, vsteps []int
+	var pos, vel, img [][]float64
+	for i := 0; i < testFrames; i++ {
+		steps = append(steps, 100+10*i)
+
+		var p, v, m []float64
+		for a := 0; a < testAtoms; a++ {
+			for k := 0; k < 3; k++ {
+				p = append(p, testCoord(i, a, k))
+				v = append(v, -testCoord(i, a, k))
+				m = append(m, float64(i-a))
+			}
+		}
+		pos, img = append(pos, p), append(img, m)
+		if i%2 == 0 {
+			vsteps, vel = append(vsteps, 100+10*i), append(vel, v)
+		}
+	}
+
+	h.element(all, "position", steps, []int{testAtoms, 3}, pos)
+	h.element(all, "velocity", vsteps, []int{testAtoms, 3}, vel)
+	h.element(all, "image", steps, []int{testAtoms, 3}, img)
+	all.Dataset("species", []int{testAtoms}, []float64{1, 2, 2})
+
+	if h.box != "" {
+		box := all.Group("box").Attr("dimension", 3).Attr("boundary", []string{h.boundary, h.boundary, h.boundary})
+		switch h.box {
+		case "fixed":
+			box.Dataset("edges", []int{3}, []float64{10, 11, 12})
+		case "time":
+			var edges [][]float64
+			for i := 0; i < testFrames; i++ {
+				edges = append(edges, []float64{10 + float64(i), 11, 12})
+			}
+			h.element(box, "edges", steps, []int{3}, edges)
+		case "triclinic":
+			var edges [][]float64
+			for i := 0; i < testFrames; i++ {
+				c := testCell(i)
+				edges = append(edges, append(append(c[0][:], c[1][:]...), c[2][:]...))
+			}
+			h.element(box, "edges", steps, []int{3, 3}, edges)
+			box.Dataset("offset", []int{3}, []float64{-1, -2, -3})
+		}
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "h5md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		name string
+		h    testH5MD
+	}{
+		{"fixed box", testH5MD{box: "fixed", boundary: "periodic"}},
+		{"time-dependent box", testH5MD{box: "time", boundary: "periodic", scalar: true}},
+		{"triclinic box", testH5MD{box: "triclinic", boundary: "periodic"}},
+		{"no boundary", testH5MD{box: "fixed", boundary: "none"}},
+		{"no box", testH5MD{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			name := filepath.Join(dir, "traj.h5")
+			tc.h.write(t, name)
+
+			r, err := Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			n, err := r.Len()
+			if err != nil || n != testFrames {
+				t.Fatalf("Len() = %d, %v, want %d", n, err, testFrames)
+			}
+
+			for i := n - 1; i >= 0; i-- {
+				f, err := r.Frame(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if step := 100 + 10*i; f.Step != step || f.Time != 0.5*float64(step) {
+					t.Errorf("frame %d: step %d and time %g, want %d and %g", i, f.Step, f.Time, step, 0.5*float64(step))
+				}
+
+				for a := 0; a < testAtoms; a++ {
+					for k, name := range [3]string{"x", "y", "z"} {
+						if v := f.Col(name)[a]; v != testCoord(i, a, k) {
+							t.Errorf("frame %d: %s of atom %d = %g, want %g", i, name, a, v, testCoord(i, a, k))
+						}
+						if v := f.Col(traj.Image[k])[a]; v != float64(i-a) {
+							t.Errorf("frame %d: %s of atom %d = %g, want %d", i, traj.Image[k], a, v, i-a)
+						}
+					}
+				}
+
+				if v := f.Col(traj.Vel[0]); (i%2 == 0) != (v != nil) || (v != nil && v[1] != -testCoord(i, 1, 0)) {
+					t.Errorf("frame %d: %s = %v", i, traj.Vel[0], v)
+				}
+				if v := f.Col("type"); !reflect.DeepEqual(v, []float64{1, 2, 2}) {
+					t.Errorf("frame %d: type = %v, want [1 2 2]", i, v)
+				}
+
+				// The positions are unwrapped without periodic boundary
+				if nopbc := tc.h.box == "" || tc.h.boundary == "none"; nopbc != (f.Col(traj.Pos[0]) != nil) {
+					t.Errorf("frame %d: %s = %v without periodic boundary %v", i, traj.Pos[0], f.Col(traj.Pos[0]), nopbc)
+				}
+
+				var box [3][2]float64
+				var tilt [3]float64
+				switch tc.h.box {
+				case "fixed":
+					box = [3][2]float64{{0, 10}, {0, 11}, {0, 12}}
+				case "time":
+					box = [3][2]float64{{0, 10 + float64(i)}, {0, 11}, {0, 12}}
+				case "triclinic":
+					c := testCell(i)
+					box = [3][2]float64{{-1, c[0][0] - 1}, {-2, c[1][1] - 2}, {-3, c[2][2] - 3}}
+					tilt = [3]float64{c[1][0], c[2][0], c[2][1]}
+				}
+				if f.Box != box || f.Tilt != tilt {
+					t.Errorf("frame %d: box %v and tilt %v, want %v and %v", i, f.Box, f.Tilt, box, tilt)
+				}
+			}
+		})
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "h5md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "msd.h5")
+	w, err := Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Observables(w, "water").Dataset("msd", []int{2}, []float64{0, 1})
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := hdf5.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	a, err := f.Attr("/h5md", "version")
+	if err != nil || !reflect.DeepEqual(a.Num, []float64{1, 1}) {
+		t.Errorf("version = %v, %v, want [1 1]", a, err)
+	}
+	a, err = f.Attr("/h5md/creator", "name")
+	if err != nil || !reflect.DeepEqual(a.Str, []string{"selfdiff"}) {
+		t.Errorf("creator = %v, %v, want selfdiff", a, err)
+	}
+	if !f.Exists("/h5md/author") || !f.Exists("/observables/water/msd") {
+		t.Errorf("missing author or observables")
+	}
+
+	// Not a H5MD file
+	_, err = Open(name)
+	if err == nil {
+		t.Errorf("Open accepted a file without particles")
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "h5md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "traj.h5")
+	w, err := Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := w.Root().Group("particles").Group("all")
+	h := testH5MD{}
+	h.element(all, "position", []int{0, 1}, []int{2, 3}, [][]float64{{0, 1, 2, 3, 4, 5}, {0, 1, 2, 3, math.NaN(), 5}})
+	h.element(all.Group("box"), "edges", []int{0, 1}, []int{3}, [][]float64{{1, math.Inf(1), 1}, {1, 1, 1}})
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for i, col := range []string{"", "y"} {
+		_, err = r.Frame(i)
+		var perr *traj.ParseError
+		if !errors.As(err, &perr) || perr.Frame != i || perr.Col != col {
+			t.Errorf("Frame(%d) = %v, want a traj.ParseError about the column %q", i, err, col)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package h5md
+
+import (
+	"os/user"
+	"runtime/debug"
+
+	"github.com/kpotier/selfdiff/pkg/hdf5"
+)
+
+// Create creates the H5MD file name (version 1.1) and adds its metadata: the
+// author is the current user and the creator selfdiff. The results are added
+// to the group returned by Observables and written when the file is closed.
+func Create(name string) (*hdf5.Writer, error) {
+	w, err := hdf5.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	author := "unknown"
+	if u, err := user.Current(); err == nil {
+		author = u.Username
+		if u.Name != "" {
+			author = u.Name
+		}
+	}
+
+	version := "(devel)"
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		version = info.Main.Version
+	}
+
+	h := w.Root().Group("h5md").Attr("version", []int{1, 1})
+	h.Group("author").Attr("name", author)
+	h.Group("creator").Attr("name", "selfdiff").Attr("version", version)
+
+	return w, nil
+}
+
+// Observables returns the group of the observables of the particles group
+// name. It returns the group observables itself if name is empty.
+func Observables(w *hdf5.Writer, name string) *hdf5.Object {
+	g := w.Root().Group("observables")
+	if name == "" {
+		return g
+	}
+	return g.Group(name)
+}
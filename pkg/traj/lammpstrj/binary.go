@@ -0,0 +1,333 @@
+package lammpstrj
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/kpotier/selfdiff/pkg/traj"
+)
+
+// Binary is a reader of the binary dumps of Lammps (dump atom or custom with
+// the .bin extension). It implements the traj.Reader interface. The position
+// of each frame in the file is recorded the first time the file is read up to
+// this frame.
+//
+// Each frame has a header (timestep, number of atoms, box, number of columns)
+// followed by chunks of values (one per processor). Only the files written by
+// Lammps since 2021 give the names of the columns and can be read. The file
+// must be little endian and written into a single file.
+//
+// The malformed frames return a traj.ParseError. A frame whose header is
+// malformed or which is truncated ends the trajectory.
+type Binary struct {
+	name string
+	f    traj.File
+	r    *bufio.Reader
+
+	offsets []int64       // Position of the frames found so far
+	errs    map[int]error // Malformed frames found so far
+	end     int64         // Position of the end of the last frame found
+	eof     bool
+}
+
+// Len is part of the traj.Reader interface.
+func (r *Binary) Len() (int, error) {
+	for !r.eof {
+		err := r.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(r.offsets), nil
+}
+
+// Frame is part of the traj.Reader interface. All the columns are numerical
+// and their values must be finite numbers.
+func (r *Binary) Frame(i int) (*traj.Frame, error) {
+	for len(r.offsets) <= i && !r.eof {
+		err := r.next()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if i < 0 || i >= len(r.offsets) {
+		return nil, fmt.Errorf("frame %d: %w", i, io.EOF)
+	}
+
+	if err := r.errs[i]; err != nil {
+		return nil, err
+	}
+
+	err := r.seek(r.offsets[i])
+	if err != nil {
+		return nil, err
+	}
+
+	d := &decoder{r: r.r}
+	f, cols, chunks, err := r.header(d)
+	if err != nil {
+		return nil, r.error(i, "", err)
+	}
+
+	f.Names = cols
+	f.Cols = make(map[string][]float64, len(cols))
+	f.Strs = make(map[string][]string)
+
+	data := make([][]float64, len(cols))
+	for k, name := range cols {
+		data[k] = make([]float64, f.Atoms)
+		f.Cols[name] = data[k]
+	}
+
+	// The values are stored atom by atom
+	v := 0
+	for c := 0; c < chunks; c++ {
+		n := d.int()
+		if n < 0 || v+n > f.Atoms*len(cols) {
+			return nil, r.error(i, "", fmt.Errorf("number of atoms don't match"))
+		}
+
+		for j := 0; j < n; j++ {
+			x := d.double()
+			if math.IsNaN(x) || math.IsInf(x, 0) {
+				return nil, r.error(i, cols[v%len(cols)], fmt.Errorf("invalid value %g (atom %d)", x, v/len(cols)+1))
+			}
+
+			data[v%len(cols)][v/len(cols)] = x
+			v++
+		}
+	}
+
+	if d.err != nil {
+		return nil, r.error(i, "", d.err)
+	}
+	if v != f.Atoms*len(cols) {
+		return nil, r.error(i, "", fmt.Errorf("number of atoms don't match"))
+	}
+
+	return f, nil
+}
+
+// Close is part of the traj.Reader interface.
+func (r *Binary) Close() error {
+	return r.f.Close()
+}
+
+// seek moves to the position off of the file.
+func (r *Binary) seek(off int64) error {
+	_, err := r.f.Seek(off, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	r.r.Reset(r.f)
+	return nil
+}
+
+// next finds the position of the frame following the last one found. A
+// malformed frame is recorded with its error and ends the trajectory.
+func (r *Binary) next() error {
+	err := r.seek(r.end)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.r.Peek(1)
+	if err == io.EOF {
+		r.eof = true
+		return nil
+	}
+
+	i := len(r.offsets)
+	d := &decoder{r: r.r}
+	_, _, chunks, err := r.header(d)
+	for c := 0; c < chunks && err == nil; c++ {
+		n := d.int()
+		if n < 0 && d.err == nil {
+			err = fmt.Errorf("invalid chunk")
+			break
+		}
+		d.skip(8 * n)
+		err = d.err
+	}
+
+	if err != nil {
+		if r.errs == nil {
+			r.errs = make(map[int]error)
+		}
+		r.errs[i] = r.error(i, "", err)
+		r.eof = true
+	}
+
+	r.offsets = append(r.offsets, r.end)
+	r.end += d.n
+	return nil
+}
+
+// error returns the traj.ParseError of the frame i about the column col.
+func (r *Binary) error(i int, col string, err error) error {
+	return &traj.ParseError{File: r.name, Frame: i, Col: col, Err: err}
+}
+
+// header reads the header of a frame. It returns the frame (without its
+// columns), the names of the columns and the number of chunks.
+func (r *Binary) header(d *decoder) (f *traj.Frame, cols []string, chunks int, err error) {
+	f = &traj.Frame{}
+
+	step := d.bigint()
+	if d.err != nil {
+		return nil, nil, 0, d.err
+	}
+
+	// Since 2021, the header starts with the length of a magic string
+	// (negative), the magic string, an endian flag and a revision
+	rev := 0
+	if step < 0 {
+		if step < -64 {
+			return nil, nil, 0, fmt.Errorf("not a binary dump")
+		}
+
+		magic := string(d.read(int(-step)))
+		endian := d.int()
+		rev = d.int()
+		step = d.bigint()
+
+		if d.err != nil {
+			return nil, nil, 0, d.err
+		}
+		if !strings.HasPrefix(magic, "DUMP") {
+			return nil, nil, 0, fmt.Errorf("not a binary dump")
+		}
+		if endian != 1 {
+			return nil, nil, 0, fmt.Errorf("big endian binary dumps are not supported")
+		}
+	}
+
+	f.Step = int(step)
+	f.Atoms = int(d.bigint())
+
+	triclinic := d.int()
+	d.skip(6 * 4) // Boundaries
+
+	for k := 0; k < 3; k++ {
+		for j := 0; j < 2; j++ {
+			f.Box[k][j] = d.double()
+		}
+	}
+
+	switch triclinic {
+	case 0:
+	case 1:
+		for k := 0; k < 3; k++ {
+			f.Tilt[k] = d.double()
+		}
+		bounds(f)
+	default:
+		return nil, nil, 0, fmt.Errorf("general triclinic boxes are not supported")
+	}
+
+	size := d.int()
+
+	// Since the revision 2, the units, the time and the names of the columns
+	// are written
+	if rev > 1 {
+		d.read(d.int()) // Units
+		if d.read(1)[0] != 0 {
+			d.double() // Time
+		}
+		cols = strings.Fields(string(d.read(d.int())))
+	}
+
+	chunks = d.int()
+	if d.err != nil {
+		return nil, nil, 0, d.err
+	}
+
+	switch {
+	case f.Atoms < 0 || size <= 0 || chunks < 0:
+		return nil, nil, 0, fmt.Errorf("not a binary dump")
+	case cols == nil:
+		return nil, nil, 0, fmt.Errorf("the binary dump doesn't give the names of the columns (written by Lammps before 2021)")
+	case len(cols) != size:
+		return nil, nil, 0, fmt.Errorf("number of columns don't match")
+	}
+
+	return f, cols, chunks, nil
+}
+
+// decoder reads the little endian values of a binary dump. The first error is
+// kept and the following reads return zero values.
+type decoder struct {
+	r   *bufio.Reader
+	n   int64 // Number of bytes read
+	err error
+	buf [8]byte
+}
+
+// read reads n bytes.
+func (d *decoder) read(n int) []byte {
+	if (n < 0 || n > 1<<24) && d.err == nil {
+		d.err = fmt.Errorf("invalid size")
+	}
+	if d.err != nil {
+		return make([]byte, 1)
+	}
+
+	b := d.buf[:]
+	if n > len(b) {
+		b = make([]byte, n)
+	}
+	b = b[:n]
+
+	_, err := io.ReadFull(d.r, b)
+	if err != nil {
+		d.err = io.ErrUnexpectedEOF
+		return make([]byte, 1)
+	}
+	d.n += int64(n)
+	return b
+}
+
+// skip discards n bytes.
+func (d *decoder) skip(n int) {
+	if d.err != nil {
+		return
+	}
+
+	m, err := d.r.Discard(n)
+	d.n += int64(m)
+	if err != nil {
+		d.err = io.ErrUnexpectedEOF
+	}
+}
+
+// int reads a 32-bit integer.
+func (d *decoder) int() int {
+	b := d.read(4)
+	if d.err != nil {
+		return 0
+	}
+	return int(int32(binary.LittleEndian.Uint32(b)))
+}
+
+// bigint reads a 64-bit integer.
+func (d *decoder) bigint() int64 {
+	b := d.read(8)
+	if d.err != nil {
+		return 0
+	}
+	return int64(binary.LittleEndian.Uint64(b))
+}
+
+// double reads a 64-bit float.
+func (d *decoder) double() float64 {
+	b := d.read(8)
+	if d.err != nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
@@ -0,0 +1,332 @@
+package lammpstrj
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/kpotier/selfdiff/pkg/traj"
+)
+
+// strs are the columns of strings (see Lammps dump_modify element and types).
+// The other columns are numerical.
+var strs = map[string]bool{"element": true, "label": true, "typelabel": true}
+
+// Reader is a reader of Lammps Trajectory files. It implements the traj.Reader
+// interface. The position of each frame in the file is recorded the first time
+// the file is read up to this frame.
+//
+// The malformed frames return a traj.ParseError. A frame whose header is
+// malformed or which has fewer atoms than expected ends at the next ITEM:
+// TIMESTEP, so that the following frames can still be read.
+type Reader struct {
+	name string
+	f    traj.File
+	r    *bufio.Reader
+
+	offsets []int64       // Position of the frames found so far
+	lines   []int         // First line of the frames found so far
+	errs    map[int]error // Malformed frames found so far
+	end     int64         // Position of the end of the last frame found
+	line    int           // Line of the end of the last frame found
+	eof     bool
+}
+
+// Open opens the Lammps Trajectory file name. The file can be compressed with
+// gzip (see traj.OpenFile). A file which doesn't start with ITEM: is read as a
+// binary dump (see Binary).
+func Open(name string) (traj.Reader, error) {
+	f, err := traj.OpenFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(f)
+	b, err := r.Peek(5)
+	if err == nil && string(b) != "ITEM:" {
+		return &Binary{name: name, f: f, r: r}, nil
+	}
+
+	return &Reader{name: name, f: f, r: r, line: 1}, nil
+}
+
+// Len is part of the traj.Reader interface.
+func (r *Reader) Len() (int, error) {
+	for !r.eof {
+		err := r.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(r.offsets), nil
+}
+
+// Frame is part of the traj.Reader interface. The columns of strings (element,
+// label and typelabel) are put in Strs. The other values must be finite
+// numbers.
+func (r *Reader) Frame(i int) (*traj.Frame, error) {
+	for len(r.offsets) <= i && !r.eof {
+		err := r.next()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if i < 0 || i >= len(r.offsets) {
+		return nil, fmt.Errorf("frame %d: %w", i, io.EOF)
+	}
+
+	if err := r.errs[i]; err != nil {
+		return nil, err
+	}
+
+	err := r.seek(r.offsets[i])
+	if err != nil {
+		return nil, err
+	}
+
+	f, cols, _, h, err := r.header()
+	if err != nil {
+		return nil, r.error(i, r.lines[i]+h-1, "", err)
+	}
+
+	f.Names = cols
+	f.Cols = make(map[string][]float64, len(cols))
+	f.Strs = make(map[string][]string)
+
+	data := make([][]float64, len(cols))
+	str := make([][]string, len(cols))
+	for k, name := range cols {
+		if strs[name] {
+			str[k] = make([]string, f.Atoms)
+			f.Strs[name] = str[k]
+		} else {
+			data[k] = make([]float64, f.Atoms)
+			f.Cols[name] = data[k]
+		}
+	}
+
+	for a := 0; a < f.Atoms; a++ {
+		line := r.lines[i] + 9 + a
+		l, err := traj.ReadLine(r.r)
+		if err != nil && !(err == io.EOF && len(l) != 0) {
+			return nil, r.error(i, line, "", io.ErrUnexpectedEOF)
+		}
+
+		fields := strings.Fields(string(l))
+		if len(fields) != len(cols) {
+			return nil, r.error(i, line, "", fmt.Errorf("%d columns instead of %d", len(fields), len(cols)))
+		}
+
+		for k, v := range fields {
+			if str[k] != nil {
+				str[k][a] = v
+				continue
+			}
+
+			data[k][a], err = strconv.ParseFloat(v, 64)
+			if err != nil || math.IsNaN(data[k][a]) || math.IsInf(data[k][a], 0) {
+				return nil, r.error(i, line, cols[k], fmt.Errorf("invalid value %q", v))
+			}
+		}
+	}
+
+	return f, nil
+}
+
+// Close is part of the traj.Reader interface.
+func (r *Reader) Close() error {
+	return r.f.Close()
+}
+
+// seek moves to the position off of the file.
+func (r *Reader) seek(off int64) error {
+	_, err := r.f.Seek(off, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	r.r.Reset(r.f)
+	return nil
+}
+
+// next finds the position of the frame following the last one found. A
+// malformed frame is recorded with its error and ends at the next ITEM:
+// TIMESTEP (see resync).
+func (r *Reader) next() error {
+	err := r.seek(r.end)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.r.Peek(1)
+	if err == io.EOF {
+		r.eof = true
+		return nil
+	}
+
+	i := len(r.offsets)
+	r.offsets = append(r.offsets, r.end)
+	r.lines = append(r.lines, r.line)
+
+	f, _, n, h, err := r.header()
+	if err != nil {
+		return r.resync(r.error(i, r.line+h-1, "", err))
+	}
+
+	for a := 0; a < f.Atoms; a++ {
+		l, m, err := r.readLine()
+		if err != nil && !(err == io.EOF && m != 0) {
+			return r.resync(r.error(i, r.line+9+a, "", io.ErrUnexpectedEOF))
+		}
+		if strings.HasPrefix(string(l), "ITEM:") {
+			return r.resync(r.error(i, r.line+9+a, "", fmt.Errorf("%d atoms instead of %d", a, f.Atoms)))
+		}
+		n += m
+	}
+
+	r.end += n
+	r.line += 9 + f.Atoms
+	return nil
+}
+
+// resync records the error of the last frame found and moves the end of this
+// frame to the next line starting with ITEM: TIMESTEP (or to the end of the
+// file).
+func (r *Reader) resync(perr error) error {
+	if r.errs == nil {
+		r.errs = make(map[int]error)
+	}
+	r.errs[len(r.offsets)-1] = perr
+
+	err := r.seek(r.end)
+	if err != nil {
+		return err
+	}
+
+	for l := 0; ; l++ {
+		b, n, err := r.readLine()
+		if l > 0 && strings.HasPrefix(string(b), "ITEM: TIMESTEP") {
+			return nil
+		}
+		if err == io.EOF {
+			r.end += n
+			r.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		r.end += n
+		r.line++
+	}
+}
+
+// readLine reads a line. It returns the beginning of the line and its number
+// of bytes.
+func (r *Reader) readLine() ([]byte, int64, error) {
+	b, err := r.r.ReadSlice('\n')
+	n := int64(len(b))
+	if len(b) > 16 {
+		b = b[:16]
+	}
+	l := append([]byte{}, b...)
+
+	for err == bufio.ErrBufferFull {
+		b, err = r.r.ReadSlice('\n')
+		n += int64(len(b))
+	}
+	return l, n, err
+}
+
+// error returns the traj.ParseError of the frame i at the line line of the file
+// about the column col.
+func (r *Reader) error(i, line int, col string, err error) error {
+	return &traj.ParseError{File: r.name, Frame: i, Line: line, Col: col, Err: err}
+}
+
+// header reads the 9 lines of the header of a frame. It returns the frame
+// (without its columns), the names of the columns, the number of bytes read
+// and the number of lines read.
+func (r *Reader) header() (f *traj.Frame, cols []string, n int64, h int, err error) {
+	f = &traj.Frame{}
+	var triclinic bool
+
+	for l := 0; l < 9; l++ {
+		var b string
+		b, err = r.r.ReadString('\n')
+		n += int64(len(b))
+		h++
+		if err != nil {
+			err = io.ErrUnexpectedEOF
+			return
+		}
+
+		switch l {
+		case 1:
+			f.Step, err = strconv.Atoi(strings.TrimSpace(b))
+			if err != nil {
+				err = fmt.Errorf("unable to get the timestep")
+				return
+			}
+		case 3:
+			f.Atoms, err = strconv.Atoi(strings.TrimSpace(b))
+			if err != nil {
+				err = fmt.Errorf("unable to get the number of atoms")
+				return
+			}
+		case 4:
+			triclinic = strings.Contains(b, "xy")
+		case 5, 6, 7:
+			fields := strings.Fields(b)
+			if len(fields) < 2 || (triclinic && len(fields) < 3) {
+				err = fmt.Errorf("unable to get the size of the box")
+				return
+			}
+
+			for j := 0; j < 2; j++ {
+				f.Box[l-5][j], err = strconv.ParseFloat(fields[j], 64)
+				if err != nil {
+					err = fmt.Errorf("unable to get the size of the box")
+					return
+				}
+			}
+
+			// The tilt factors xy, xz and yz follow the bounds
+			if triclinic {
+				f.Tilt[l-5], err = strconv.ParseFloat(fields[2], 64)
+				if err != nil {
+					err = fmt.Errorf("unable to get the tilt factors of the box")
+					return
+				}
+			}
+		case 8:
+			fields := strings.Fields(b)
+			if len(fields) <= 2 {
+				err = fmt.Errorf("not enough columns")
+				return
+			}
+			cols = fields[2:] // Omission of ITEM: ATOMS
+		}
+	}
+
+	if triclinic {
+		bounds(f)
+	}
+
+	return
+}
+
+// bounds converts the bounding box of a triclinic box, written in the dumps
+// instead of its bounds, into its bounds (see Lammps documentation about
+// triclinic boxes). The tilt factors must be set.
+func bounds(f *traj.Frame) {
+	xy, xz, yz := f.Tilt[0], f.Tilt[1], f.Tilt[2]
+	f.Box[0][0] -= math.Min(math.Min(0, xy), math.Min(xz, xy+xz))
+	f.Box[0][1] -= math.Max(math.Max(0, xy), math.Max(xz, xy+xz))
+	f.Box[1][0] -= math.Min(0, yz)
+	f.Box[1][1] -= math.Max(0, yz)
+}
@@ -0,0 +1,88 @@
+package lammpstrj
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kpotier/selfdiff/pkg/traj"
+)
+
+// Writer is a writer of Lammps Trajectory files. It implements the traj.Writer
+// interface.
+type Writer struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+// Create creates the Lammps Trajectory file name.
+func Create(name string) (*Writer, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{f, bufio.NewWriter(f)}, nil
+}
+
+// Write is part of the traj.Writer interface. The columns are written in the
+// order of Names. The bounding box and the tilt factors are written for a
+// triclinic box.
+func (w *Writer) Write(f *traj.Frame) error {
+	fmt.Fprintf(w.w, "ITEM: TIMESTEP\n%d\nITEM: NUMBER OF ATOMS\n%d\n", f.Step, f.Atoms)
+	if f.Tilt == [3]float64{} {
+		fmt.Fprintln(w.w, "ITEM: BOX BOUNDS pp pp pp")
+		for k := 0; k < 3; k++ {
+			fmt.Fprintln(w.w, f.Box[k][0], f.Box[k][1])
+		}
+	} else {
+		xy, xz, yz := f.Tilt[0], f.Tilt[1], f.Tilt[2]
+		fmt.Fprintln(w.w, "ITEM: BOX BOUNDS xy xz yz pp pp pp")
+		fmt.Fprintln(w.w, f.Box[0][0]+math.Min(math.Min(0, xy), math.Min(xz, xy+xz)), f.Box[0][1]+math.Max(math.Max(0, xy), math.Max(xz, xy+xz)), xy)
+		fmt.Fprintln(w.w, f.Box[1][0]+math.Min(0, yz), f.Box[1][1]+math.Max(0, yz), xz)
+		fmt.Fprintln(w.w, f.Box[2][0], f.Box[2][1], yz)
+	}
+	fmt.Fprintln(w.w, "ITEM: ATOMS", strings.Join(f.Names, " "))
+
+	cols := make([][]float64, len(f.Names))
+	strs := make([][]string, len(f.Names))
+	for k, name := range f.Names {
+		cols[k], strs[k] = f.Cols[name], f.Strs[name]
+		if cols[k] == nil && strs[k] == nil {
+			return fmt.Errorf("cannot find the column %s", name)
+		}
+	}
+
+	var b []byte
+	for a := 0; a < f.Atoms; a++ {
+		b = b[:0]
+		for k := range f.Names {
+			if cols[k] != nil {
+				b = strconv.AppendFloat(b, cols[k][a], 'g', -1, 64)
+			} else {
+				b = append(b, strs[k][a]...)
+			}
+			b = append(b, ' ')
+		}
+		b = append(b, '\n')
+
+		_, err := w.w.Write(b)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close is part of the traj.Writer interface.
+func (w *Writer) Close() error {
+	err := w.w.Flush()
+	if err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
@@ -0,0 +1,77 @@
+package traj
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Lenient is a reader which skips the frames of Reader returning a ParseError,
+// Warn being called with the error of each frame skipped. The frames of Reader
+// are checked in order the first time the trajectory is read up to them.
+type Lenient struct {
+	Reader Reader
+	Warn   func(err error)
+
+	frames []int // Frames of Reader which aren't skipped
+	next   int   // Next frame of Reader to check
+	eof    bool
+}
+
+// Len is part of the Reader interface.
+func (l *Lenient) Len() (int, error) {
+	for !l.eof {
+		_, err := l.check()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(l.frames), nil
+}
+
+// Frame is part of the Reader interface.
+func (l *Lenient) Frame(i int) (*Frame, error) {
+	for len(l.frames) <= i && !l.eof {
+		f, err := l.check()
+		if err != nil {
+			return nil, err
+		}
+		if f != nil && len(l.frames) == i+1 {
+			return f, nil
+		}
+	}
+
+	if i < 0 || i >= len(l.frames) {
+		return nil, fmt.Errorf("frame %d: %w", i, io.EOF)
+	}
+	return l.Reader.Frame(l.frames[i])
+}
+
+// Close is part of the Reader interface.
+func (l *Lenient) Close() error {
+	return l.Reader.Close()
+}
+
+// check reads the frame next of Reader. It returns this frame if it isn't
+// skipped.
+func (l *Lenient) check() (*Frame, error) {
+	f, err := l.Reader.Frame(l.next)
+	var perr *ParseError
+	switch {
+	case errors.As(err, &perr):
+		if l.Warn != nil {
+			l.Warn(err)
+		}
+		l.next++
+		return nil, nil
+	case errors.Is(err, io.EOF):
+		l.eof = true
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	l.frames = append(l.frames, l.next)
+	l.next++
+	return f, nil
+}
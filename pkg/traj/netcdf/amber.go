@@ -0,0 +1,231 @@
+package netcdf
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/kpotier/selfdiff/pkg/traj"
+)
+
+// Reader is a reader of AMBER NetCDF trajectories (e.g: pmemd, cpptraj,
+// OpenMM or MDAnalysis). It implements the traj.Reader interface. Each frame
+// is a record of the file, so the frames are found without reading the file.
+//
+// The variable coordinates gives the columns x y z. The file doesn't specify
+// whether they are wrapped, so the trajectory must be unwrapped (pbc), which
+// keeps unwrapped positions as they are. Without cell, the positions can't be
+// wrapped and also give the columns xu yu zu. The variables velocities
+// (multiplied by their scale_factor) and forces give the columns vx vy vz and
+// fx fy fz. The box is given by cell_lengths and cell_angles. The units are the
+// ones of AMBER (Å and ps). The step is the index of the frame. The malformed
+// frames return a traj.ParseError.
+type Reader struct {
+	name  string
+	nc    file
+	atoms int
+	n     int // Number of frames
+
+	coords, vel, forces *variable
+	lengths, angles     *variable
+	scale               float64 // Scale factor of the velocities
+}
+
+// Open opens the AMBER NetCDF file name and reads its header.
+func Open(name string) (*Reader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reader{name: name, nc: file{f: f}}
+	err = r.header()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// header reads the header of the file and checks that it follows the AMBER
+// convention.
+func (r *Reader) header() error {
+	err := r.nc.header()
+	if err != nil {
+		return err
+	}
+
+	conv := r.nc.attrs["Conventions"].str
+	switch {
+	case strings.Contains(conv, "AMBERRESTART"):
+		return fmt.Errorf("AMBER restart files are not supported")
+	case !strings.Contains(conv, "AMBER"):
+		return fmt.Errorf("not an AMBER trajectory")
+	}
+
+	frame, ok := r.nc.dimName["frame"]
+	if !ok || r.nc.dims[frame] != 0 {
+		return fmt.Errorf("unable to get the frames")
+	}
+	atom, ok := r.nc.dimName["atom"]
+	if !ok {
+		return fmt.Errorf("unable to get the number of atoms")
+	}
+	r.atoms = int(r.nc.dims[atom])
+
+	// The per-atom variables have the dimensions (frame, atom, spatial)
+	perAtom := func(name string) (*variable, error) {
+		v, ok := r.nc.vars[name]
+		if !ok {
+			return nil, nil
+		}
+		if len(v.dims) != 3 || v.dims[0] != frame || v.dims[1] != atom || r.nc.dims[v.dims[2]] != 3 {
+			return nil, fmt.Errorf("invalid dimensions of the variable %s", name)
+		}
+		return v, nil
+	}
+
+	if r.coords, err = perAtom("coordinates"); err != nil {
+		return err
+	}
+	if r.vel, err = perAtom("velocities"); err != nil {
+		return err
+	}
+	if r.forces, err = perAtom("forces"); err != nil {
+		return err
+	}
+
+	r.scale = 1
+	if r.vel != nil {
+		if s := r.vel.attrs["scale_factor"].num; len(s) == 1 {
+			r.scale = s[0]
+		}
+	}
+
+	// The cell variables have the dimensions (frame, 3)
+	cell := func(name string) (*variable, error) {
+		v, ok := r.nc.vars[name]
+		if !ok {
+			return nil, nil
+		}
+		if len(v.dims) != 2 || v.dims[0] != frame || r.nc.dims[v.dims[1]] != 3 {
+			return nil, fmt.Errorf("invalid dimensions of the variable %s", name)
+		}
+		return v, nil
+	}
+
+	if r.lengths, err = cell("cell_lengths"); err != nil {
+		return err
+	}
+	if r.angles, err = cell("cell_angles"); err != nil {
+		return err
+	}
+	if (r.lengths == nil) != (r.angles == nil) {
+		return fmt.Errorf("unable to get the unit cell")
+	}
+
+	// The number of records of the header isn't reliable if the simulation
+	// has been interrupted
+	st, err := r.nc.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	first := int64(-1)
+	for _, v := range r.nc.vars {
+		if r.nc.record(v) && (first < 0 || v.begin < first) {
+			first = v.begin
+		}
+	}
+
+	if first >= 0 && r.nc.recSize > 0 && st.Size() > first {
+		r.n = int((st.Size() - first) / r.nc.recSize)
+	}
+	if r.nc.recs >= 0 && r.nc.recs < r.n {
+		r.n = r.nc.recs
+	}
+
+	return nil
+}
+
+// Len is part of the traj.Reader interface.
+func (r *Reader) Len() (int, error) {
+	return r.n, nil
+}
+
+// Frame is part of the traj.Reader interface. The values must be finite
+// numbers.
+func (r *Reader) Frame(i int) (*traj.Frame, error) {
+	if i < 0 || i >= r.n {
+		return nil, fmt.Errorf("frame %d: %w", i, io.EOF)
+	}
+
+	f := &traj.Frame{Step: i, Atoms: r.atoms, Cols: make(map[string][]float64)}
+
+	if r.lengths != nil {
+		l, err := r.nc.values(r.lengths, i)
+		if err != nil {
+			return nil, r.error(i, "", err)
+		}
+		a, err := r.nc.values(r.angles, i)
+		if err != nil {
+			return nil, r.error(i, "", err)
+		}
+
+		err = f.SetLengths(l[0], l[1], l[2], traj.Cos(a[0]), traj.Cos(a[1]), traj.Cos(a[2]))
+		if err != nil {
+			return nil, r.error(i, "", err)
+		}
+	}
+
+	for _, b := range []struct {
+		v     *variable
+		names [3]string
+		scale float64
+	}{
+		{r.coords, [3]string{"x", "y", "z"}, 1},
+		{r.vel, traj.Vel, r.scale},
+		{r.forces, [3]string{"fx", "fy", "fz"}, 1},
+	} {
+		if b.v == nil {
+			continue
+		}
+
+		v, err := r.nc.values(b.v, i)
+		if err != nil {
+			return nil, r.error(i, "", err)
+		}
+
+		for k, name := range b.names {
+			col := make([]float64, r.atoms)
+			for a := range col {
+				col[a] = v[3*a+k] * b.scale
+				if math.IsNaN(col[a]) || math.IsInf(col[a], 0) {
+					return nil, r.error(i, name, fmt.Errorf("invalid value %g (atom %d)", col[a], a+1))
+				}
+			}
+			f.Cols[name] = col
+			f.Names = append(f.Names, name)
+		}
+	}
+
+	// The positions can't be wrapped without cell
+	if f.NoCell() {
+		f.SetUnwrapped()
+	}
+
+	return f, nil
+}
+
+// Close is part of the traj.Reader interface.
+func (r *Reader) Close() error {
+	return r.nc.f.Close()
+}
+
+// error returns the traj.ParseError of the frame i about the column col.
+func (r *Reader) error(i int, col string, err error) error {
+	return &traj.ParseError{File: r.name, Frame: i, Col: col, Err: err}
+}
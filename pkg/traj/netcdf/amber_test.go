@@ -0,0 +1,256 @@
+package netcdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	testAtoms  = 4
+	testFrames = 3
+	testScale  = 20.455
+)
+
+// testCoord returns the coordinate k of the atom a in the frame i.
+func testCoord(i, a, k int) float64 {
+	return float64(a) + 0.25*float64(k) + 0.5*float64(i)
+}
+
+// testVel returns the velocity k (unscaled) of the atom a in the frame i.
+func testVel(i, a, k int) float64 {
+	return -float64(a) - 0.125*float64(k) + float64(i)
+}
+
+// testLengths returns the lengths of the cell of the frame i.
+func testLengths(i int) [3]float64 {
+	return [3]float64{10 + float64(i), 11, 12}
+}
+
+// encoder writes the big endian values of a NetCDF-3 file.
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) int(v int) {
+	binary.Write(e, binary.BigEndian, int32(v))
+}
+
+func (e *encoder) name(s string) {
+	e.int(len(s))
+	e.WriteString(s)
+	for n := len(s); n%4 != 0; n++ {
+		e.WriteByte(0)
+	}
+}
+
+func (e *encoder) float(v float64) {
+	binary.Write(e, binary.BigEndian, float32(v))
+}
+
+func (e *encoder) double(v float64) {
+	binary.Write(e, binary.BigEndian, v)
+}
+
+// testVar is a record variable of the test files.
+type testVar struct {
+	name  string
+	dims  []int
+	typ   int
+	scale bool // Has a scale_factor attribute
+	size  int  // Size of one record
+}
+
+// writeAmber writes an AMBER trajectory of testFrames frames in the classic
+// (version 1) or 64-bit offset (version 2) format.
+func writeAmber(t *testing.T, name string, version byte) {
+	dims := []struct {
+		name string
+		len  int
+	}{
+		{"frame", 0},
+		{"spatial", 3},
+		{"atom", testAtoms},
+		{"cell_spatial", 3},
+		{"cell_angular", 3},
+	}
+	vars := []testVar{
+		{"coordinates", []int{0, 2, 1}, ncFloat, false, 4 * 3 * testAtoms},
+		{"velocities", []int{0, 2, 1}, ncFloat, true, 4 * 3 * testAtoms},
+		{"cell_lengths", []int{0, 3}, ncDouble, false, 8 * 3},
+		{"cell_angles", []int{0, 4}, ncDouble, false, 8 * 3},
+	}
+
+	// The size of the header doesn't depend on the positions of the data
+	header := func(begin int) *encoder {
+		e := &encoder{}
+		e.WriteString("CDF")
+		e.WriteByte(version)
+		e.int(testFrames)
+
+		e.int(ncDimension)
+		e.int(len(dims))
+		for _, d := range dims {
+			e.name(d.name)
+			e.int(d.len)
+		}
+
+		e.int(ncAttribute)
+		e.int(2)
+		for _, a := range [][2]string{{"Conventions", "AMBER"}, {"ConventionVersion", "1.0"}} {
+			e.name(a[0])
+			e.int(ncChar)
+			e.name(a[1])
+		}
+
+		e.int(ncVariable)
+		e.int(len(vars))
+		for _, v := range vars {
+			e.name(v.name)
+			e.int(len(v.dims))
+			for _, d := range v.dims {
+				e.int(d)
+			}
+
+			if v.scale {
+				e.int(ncAttribute)
+				e.int(1)
+				e.name("scale_factor")
+				e.int(ncDouble)
+				e.int(1)
+				e.double(testScale)
+			} else {
+				e.int(0)
+				e.int(0)
+			}
+
+			e.int(v.typ)
+			e.int(v.size)
+			if version == 2 {
+				e.int(0)
+			}
+			e.int(begin)
+			begin += v.size
+		}
+		return e
+	}
+
+	e := header(header(0).Len())
+	for i := 0; i < testFrames; i++ {
+		for a := 0; a < testAtoms; a++ {
+			for k := 0; k < 3; k++ {
+				e.float(testCoord(i, a, k))
+			}
+		}
+		for a := 0; a < testAtoms; a++ {
+			for k := 0; k < 3; k++ {
+				e.float(testVel(i, a, k))
+			}
+		}
+		for _, l := range testLengths(i) {
+			e.double(l)
+		}
+		for k := 0; k < 3; k++ {
+			e.double(90)
+		}
+	}
+
+	err := ioutil.WriteFile(name, e.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netcdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tc := range []struct {
+		name    string
+		version byte
+	}{
+		{"classic", 1},
+		{"offset64", 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			name := filepath.Join(dir, tc.name+".nc")
+			writeAmber(t, name, tc.version)
+
+			r, err := Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			n, err := r.Len()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != testFrames {
+				t.Fatalf("Len() = %d, want %d", n, testFrames)
+			}
+
+			for i := 0; i < testFrames; i++ {
+				f, err := r.Frame(i)
+				if err != nil {
+					t.Fatalf("frame %d: %v", i, err)
+				}
+				if f.Step != i || f.Atoms != testAtoms {
+					t.Errorf("frame %d: step %d and %d atoms, want %d and %d", i, f.Step, f.Atoms, i, testAtoms)
+				}
+
+				l := testLengths(i)
+				for k := 0; k < 3; k++ {
+					if f.Box[k] != [2]float64{0, l[k]} || f.Tilt[k] != 0 {
+						t.Errorf("frame %d: box %v and tilt %v, want the lengths %v", i, f.Box, f.Tilt, l)
+						break
+					}
+				}
+
+				for k, col := range [3]string{"x", "y", "z"} {
+					v := f.Col(col)
+					if len(v) != testAtoms {
+						t.Fatalf("frame %d: column %s has %d values", i, col, len(v))
+					}
+					for a := range v {
+						if want := testCoord(i, a, k); v[a] != want {
+							t.Errorf("frame %d: %s of atom %d = %g, want %g", i, col, a, v[a], want)
+						}
+					}
+				}
+
+				for k, col := range [3]string{"vx", "vy", "vz"} {
+					v := f.Col(col)
+					if len(v) != testAtoms {
+						t.Fatalf("frame %d: column %s has %d values", i, col, len(v))
+					}
+					for a := range v {
+						if want := testVel(i, a, k) * testScale; math.Abs(v[a]-want) > 1e-9 {
+							t.Errorf("frame %d: %s of atom %d = %g, want %g", i, col, a, v[a], want)
+						}
+					}
+				}
+
+				if f.Col("fx") != nil {
+					t.Errorf("frame %d: unexpected column fx", i)
+				}
+			}
+
+			for _, i := range []int{-1, testFrames} {
+				_, err := r.Frame(i)
+				if !errors.Is(err, io.EOF) {
+					t.Errorf("Frame(%d) = %v, want io.EOF", i, err)
+				}
+			}
+		})
+	}
+}
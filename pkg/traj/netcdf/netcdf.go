@@ -0,0 +1,278 @@
+// Package netcdf reads the AMBER trajectories written in the NetCDF-3 format
+// (classic or 64-bit offset). Only the subset of NetCDF-3 used by the AMBER
+// convention is implemented.
+package netcdf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// Types of the values (nc_type)
+const (
+	ncByte   = 1
+	ncChar   = 2
+	ncShort  = 3
+	ncInt    = 4
+	ncFloat  = 5
+	ncDouble = 6
+)
+
+// Tags of the lists of the header
+const (
+	ncDimension = 10
+	ncVariable  = 11
+	ncAttribute = 12
+)
+
+// size is the size of each type.
+var size = [...]int64{ncByte: 1, ncChar: 1, ncShort: 2, ncInt: 4, ncFloat: 4, ncDouble: 8}
+
+// variable is a variable of a NetCDF file.
+type variable struct {
+	dims  []int // Indices of the dimensions
+	attrs map[string]attribute
+	typ   int
+	begin int64 // Position of the data
+}
+
+// attribute is an attribute of the file or of a variable. The values are
+// either a string (char) or numbers.
+type attribute struct {
+	str string
+	num []float64
+}
+
+// file is the header of a NetCDF-3 file (classic or 64-bit offset format).
+type file struct {
+	f       *os.File
+	recs    int     // Number of records
+	dims    []int64 // Length of each dimension (0 for the record dimension)
+	dimName map[string]int
+	attrs   map[string]attribute
+	vars    map[string]*variable
+	recSize int64 // Size of a record (all the record variables)
+}
+
+// header reads the header of the file: the number of records, the dimensions,
+// the global attributes and the variables.
+func (nc *file) header() error {
+	h := &reader{r: bufio.NewReader(io.NewSectionReader(nc.f, 0, 1<<62))}
+
+	magic := h.read(4)
+	if h.err != nil || string(magic[:3]) != "CDF" || (magic[3] != 1 && magic[3] != 2) {
+		return fmt.Errorf("not a NetCDF-3 file")
+	}
+	offset64 := magic[3] == 2
+
+	// The number of records is unknown while the file is written
+	// (streaming)
+	recs := h.uint()
+	if recs == 0xffffffff {
+		nc.recs = -1
+	} else {
+		nc.recs = int(int32(recs))
+	}
+
+	nc.dimName = make(map[string]int)
+	n := h.list(ncDimension)
+	for i := 0; i < n && h.err == nil; i++ {
+		name := h.name()
+		nc.dimName[name] = len(nc.dims)
+		nc.dims = append(nc.dims, int64(h.uint()))
+	}
+
+	nc.attrs = h.attrs()
+
+	nc.vars = make(map[string]*variable)
+	n = h.list(ncVariable)
+	for i := 0; i < n && h.err == nil; i++ {
+		name := h.name()
+		v := &variable{dims: make([]int, h.int())}
+		for k := range v.dims {
+			v.dims[k] = h.int()
+			if v.dims[k] < 0 || v.dims[k] >= len(nc.dims) {
+				return fmt.Errorf("invalid dimension of the variable %s", name)
+			}
+		}
+		v.attrs = h.attrs()
+		v.typ = h.int()
+		h.uint() // Size (not reliable for large variables)
+		if offset64 {
+			v.begin = int64(h.uint())<<32 | int64(h.uint())
+		} else {
+			v.begin = int64(h.uint())
+		}
+
+		if v.typ < ncByte || v.typ > ncDouble {
+			return fmt.Errorf("invalid type of the variable %s", name)
+		}
+		nc.vars[name] = v
+
+		if nc.record(v) {
+			nc.recSize += (nc.size(v) + 3) / 4 * 4
+		}
+	}
+
+	if h.err != nil {
+		return fmt.Errorf("unable to read the header: %w", h.err)
+	}
+
+	// The records of a single record variable aren't padded
+	var single *variable
+	for _, v := range nc.vars {
+		if nc.record(v) {
+			if single != nil {
+				single = nil
+				break
+			}
+			single = v
+		}
+	}
+	if single != nil {
+		nc.recSize = nc.size(single)
+	}
+
+	return nil
+}
+
+// record returns true if v is a record variable.
+func (nc *file) record(v *variable) bool {
+	return len(v.dims) > 0 && nc.dims[v.dims[0]] == 0
+}
+
+// size returns the size of one record of the record variable v.
+func (nc *file) size(v *variable) int64 {
+	n := size[v.typ]
+	for _, d := range v.dims[1:] {
+		n *= nc.dims[d]
+	}
+	return n
+}
+
+// values reads the values of the record rec of the record variable v.
+func (nc *file) values(v *variable, rec int) ([]float64, error) {
+	b := make([]byte, nc.size(v))
+	_, err := nc.f.ReadAt(b, v.begin+int64(rec)*nc.recSize)
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	return decode(b, v.typ, len(b)/int(size[v.typ])), nil
+}
+
+// decode decodes n values of type typ (big endian).
+func decode(b []byte, typ int, n int) []float64 {
+	v := make([]float64, n)
+	for i := range v {
+		switch typ {
+		case ncByte, ncChar:
+			v[i] = float64(int8(b[i]))
+		case ncShort:
+			v[i] = float64(int16(binary.BigEndian.Uint16(b[2*i:])))
+		case ncInt:
+			v[i] = float64(int32(binary.BigEndian.Uint32(b[4*i:])))
+		case ncFloat:
+			v[i] = float64(math.Float32frombits(binary.BigEndian.Uint32(b[4*i:])))
+		case ncDouble:
+			v[i] = math.Float64frombits(binary.BigEndian.Uint64(b[8*i:]))
+		}
+	}
+	return v
+}
+
+// reader reads the big endian values of the header. The first error is kept
+// and the following reads return zero values.
+type reader struct {
+	r   *bufio.Reader
+	err error
+}
+
+// read reads n bytes.
+func (h *reader) read(n int) []byte {
+	if (n < 0 || n > 1<<24) && h.err == nil {
+		h.err = fmt.Errorf("invalid size")
+	}
+	if h.err != nil {
+		return make([]byte, 4)
+	}
+
+	b := make([]byte, n)
+	_, err := io.ReadFull(h.r, b)
+	if err != nil {
+		h.err = io.ErrUnexpectedEOF
+		return make([]byte, 4)
+	}
+	return b
+}
+
+// uint reads an unsigned 32-bit integer.
+func (h *reader) uint() uint32 {
+	return binary.BigEndian.Uint32(h.read(4))
+}
+
+// int reads a 32-bit integer.
+func (h *reader) int() int {
+	return int(int32(h.uint()))
+}
+
+// padded reads n bytes padded to 4 bytes.
+func (h *reader) padded(n int) []byte {
+	b := h.read((n + 3) / 4 * 4)
+	if h.err != nil {
+		return nil
+	}
+	return b[:n]
+}
+
+// name reads a name.
+func (h *reader) name() string {
+	return string(h.padded(h.int()))
+}
+
+// list reads the tag and the number of elements of a list. The list can be
+// absent (two zeros).
+func (h *reader) list(tag int) int {
+	t, n := h.int(), h.int()
+	if h.err == nil && t != tag && !(t == 0 && n == 0) {
+		h.err = fmt.Errorf("invalid list")
+	}
+	if n < 0 && h.err == nil {
+		h.err = fmt.Errorf("invalid list")
+	}
+	return n
+}
+
+// attrs reads a list of attributes.
+func (h *reader) attrs() map[string]attribute {
+	attrs := make(map[string]attribute)
+
+	n := h.list(ncAttribute)
+	for i := 0; i < n && h.err == nil; i++ {
+		name := h.name()
+		typ, nelems := h.int(), h.int()
+		if typ < ncByte || typ > ncDouble || nelems < 0 {
+			if h.err == nil {
+				h.err = fmt.Errorf("invalid attribute %s", name)
+			}
+			break
+		}
+
+		b := h.padded(nelems * int(size[typ]))
+		if h.err != nil {
+			break
+		}
+
+		if typ == ncChar {
+			attrs[name] = attribute{str: string(b)}
+		} else {
+			attrs[name] = attribute{num: decode(b, typ, nelems)}
+		}
+	}
+
+	return attrs
+}
@@ -0,0 +1,248 @@
+package traj
+
+import (
+	"fmt"
+	"math"
+)
+
+// Frame is one configuration of a trajectory. Cols contains the numerical
+// per-atom columns by name (e.g: id, type, mol, xu, yu, zu, vx, vy, vz) and
+// Strs the other ones (e.g: element), each one having Atoms values. Names are
+// the names of the columns in the order of the file. Time is the time of the
+// frame if the trajectory gives it (e.g: H5MD), otherwise 0.
+type Frame struct {
+	Step  int
+	Time  float64
+	Box   [3][2]float64 // Lower and upper bounds of the box along x, y and z
+	Tilt  [3]float64    // Tilt factors xy, xz and yz of a triclinic box
+	Atoms int
+
+	Names []string
+	Cols  map[string][]float64
+	Strs  map[string][]string
+}
+
+// Col returns the numerical column name. It returns nil if the column doesn't
+// exist.
+func (f *Frame) Col(name string) []float64 {
+	return f.Cols[name]
+}
+
+// vectors are the Cartesian columns rotated with the cell (see SetCell).
+var vectors = [][3]string{{"x", "y", "z"}, Pos, Vel, {"fx", "fy", "fz"}}
+
+// SetCell sets Box and Tilt from the vectors a, b and c of the cell. Lammps
+// requires a to be along x and b in the xy plane (see Lammps documentation
+// about triclinic boxes). Otherwise, the cell is rotated into this orientation
+// (and mirrored if it is left-handed), and so are the columns x y z, xu yu zu,
+// vx vy vz and fx fy fz, which must then be set beforehand. The image flags
+// are unchanged. A cell of zero volume is rejected.
+func (f *Frame) SetCell(a, b, c [3]float64) error {
+	if a[1] == 0 && a[2] == 0 && b[2] == 0 && a[0] >= 0 && b[1] >= 0 && c[2] >= 0 {
+		f.Box = [3][2]float64{{0, a[0]}, {0, b[1]}, {0, c[2]}}
+		f.Tilt = [3]float64{b[0], c[0], c[1]}
+		return nil
+	}
+
+	h := [3][3]float64{a, b, c}
+	vol := det(h)
+	if math.Abs(vol) <= 1e-12*norm(a)*norm(b)*norm(c) {
+		return fmt.Errorf("invalid cell (zero volume)")
+	}
+
+	// a_x = |a|, b_x = a.b/|a|, c_x = a.c/|a|, etc.
+	var r [3][3]float64
+	r[0][0] = norm(a)
+	r[1][0] = dot(a, b) / r[0][0]
+	r[1][1] = math.Sqrt(math.Max(0, dot(b, b)-r[1][0]*r[1][0]))
+	r[2][0] = dot(a, c) / r[0][0]
+	r[2][1] = (dot(b, c) - r[1][0]*r[2][0]) / r[1][1]
+	r[2][2] = math.Abs(vol) / (r[0][0] * r[1][1])
+
+	// The rows of h are turned into the ones of r by m = inverse(h)*r
+	inv := inverse(h, vol)
+	var m [3][3]float64
+	for i := range m {
+		for j := range m[i] {
+			for k := 0; k < 3; k++ {
+				m[i][j] += inv[i][k] * r[k][j]
+			}
+		}
+	}
+
+	// The columns can share their values (e.g: x and xu)
+	done := make(map[*float64]bool)
+	for _, names := range vectors {
+		var v [3][]float64
+		for k, name := range names {
+			v[k] = f.Col(name)
+		}
+		if len(v[0]) == 0 || len(v[1]) == 0 || len(v[2]) == 0 || done[&v[0][0]] {
+			continue
+		}
+		done[&v[0][0]] = true
+
+		for a := range v[0] {
+			p := [3]float64{v[0][a], v[1][a], v[2][a]}
+			for j := 0; j < 3; j++ {
+				v[j][a] = p[0]*m[0][j] + p[1]*m[1][j] + p[2]*m[2][j]
+			}
+		}
+	}
+
+	f.Box = [3][2]float64{{0, r[0][0]}, {0, r[1][1]}, {0, r[2][2]}}
+	f.Tilt = [3]float64{r[1][0], r[2][0], r[2][1]}
+	return nil
+}
+
+// Cell returns the vectors a, b and c of the cell (see SetCell).
+func (f *Frame) Cell() [3][3]float64 {
+	return [3][3]float64{
+		{f.Box[0][1] - f.Box[0][0], 0, 0},
+		{f.Tilt[0], f.Box[1][1] - f.Box[1][0], 0},
+		{f.Tilt[1], f.Tilt[2], f.Box[2][1] - f.Box[2][0]},
+	}
+}
+
+// NoCell returns true if the frame has no cell (e.g: a trajectory without
+// periodic boundaries). Its positions can't be wrapped (see SetUnwrapped).
+func (f *Frame) NoCell() bool {
+	return f.Box == [3][2]float64{} && f.Tilt == [3]float64{}
+}
+
+// SetUnwrapped sets the columns xu yu zu to the positions x y z, which are
+// unwrapped in a frame without periodic boundaries. The columns share their
+// values and xu yu zu aren't added to Names.
+func (f *Frame) SetUnwrapped() {
+	for k, name := range [3]string{"x", "y", "z"} {
+		if v := f.Col(name); v != nil {
+			f.Cols[Pos[k]] = v
+		}
+	}
+}
+
+// Unwrapped returns the positions x y z unwrapped with the image flags ix iy
+// iz: x + ix*a + iy*b + iz*c, a b and c being the vectors of the cell. It
+// returns nil slices if one of these columns doesn't exist.
+func (f *Frame) Unwrapped() (xyz [3][]float64) {
+	var w, img [3][]float64
+	for k, name := range [3]string{"x", "y", "z"} {
+		w[k], img[k] = f.Col(name), f.Col(Image[k])
+		if w[k] == nil || img[k] == nil {
+			return
+		}
+	}
+
+	cell := f.Cell()
+	for k := range xyz {
+		xyz[k] = make([]float64, f.Atoms)
+		for a := range xyz[k] {
+			xyz[k][a] = w[k][a] + img[0][a]*cell[0][k] + img[1][a]*cell[1][k] + img[2][a]*cell[2][k]
+		}
+	}
+	return
+}
+
+// SetLengths sets Box and Tilt from the lengths a, b and c of the cell and the
+// cosines of the angles alpha (between b and c), beta (between a and c) and
+// gamma (between a and b).
+func (f *Frame) SetLengths(a, b, c, cosA, cosB, cosG float64) error {
+	sinG := math.Sqrt(1 - cosG*cosG)
+	if sinG == 0 {
+		return fmt.Errorf("invalid unit cell")
+	}
+
+	cx := c * cosB
+	cy := c * (cosA - cosB*cosG) / sinG
+	cz := math.Sqrt(math.Max(0, c*c-cx*cx-cy*cy))
+
+	return f.SetCell([3]float64{a, 0, 0}, [3]float64{b * cosG, b * sinG, 0}, [3]float64{cx, cy, cz})
+}
+
+// Cos returns the cosine of the angle v (in degrees). It is exactly 0 for a
+// right angle.
+func Cos(v float64) float64 {
+	if v == 90 {
+		return 0
+	}
+	return math.Cos(v * math.Pi / 180)
+}
+
+// Rename renames the column old into name.
+func (f *Frame) Rename(old, name string) {
+	for i, n := range f.Names {
+		if n == old {
+			f.Names[i] = name
+		}
+	}
+
+	if v, ok := f.Cols[old]; ok {
+		delete(f.Cols, old)
+		f.Cols[name] = v
+	}
+	if v, ok := f.Strs[old]; ok {
+		delete(f.Strs, old)
+		f.Strs[name] = v
+	}
+}
+
+// Reader is a format-agnostic trajectory reader. The frames can be read in any
+// order.
+type Reader interface {
+	// Len returns the number of frames of the trajectory
+	Len() (int, error)
+
+	// Frame returns the frame i (starting from 0). It returns an error
+	// wrapping io.EOF if the trajectory has fewer frames
+	Frame(i int) (*Frame, error)
+
+	// Close closes the trajectory
+	Close() error
+}
+
+// Writer is a format-agnostic trajectory writer.
+type Writer interface {
+	// Write appends the frame f to the trajectory
+	Write(f *Frame) error
+
+	// Close closes the trajectory
+	Close() error
+}
+
+// Method is an interface that will be used by the modules (msd and vac). It
+// gives the vector quantity (position or velocity) of the center of mass of
+// each molecule for each configuration.
+type Method interface {
+	Read() error
+	GetCfg(int) ([][3]float64, error)
+	End() error
+}
+
+// dot returns the dot product of u and v.
+func dot(u, v [3]float64) float64 {
+	return u[0]*v[0] + u[1]*v[1] + u[2]*v[2]
+}
+
+// norm returns the norm of the vector v.
+func norm(v [3]float64) float64 {
+	return math.Sqrt(dot(v, v))
+}
+
+// det returns the determinant of m.
+func det(m [3][3]float64) float64 {
+	return m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+}
+
+// inverse returns the inverse of m, d being its determinant.
+func inverse(m [3][3]float64, d float64) (inv [3][3]float64) {
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			a, b := (j+1)%3, (j+2)%3
+			c, e := (i+1)%3, (i+2)%3
+			inv[i][j] = (m[a][c]*m[b][e] - m[a][e]*m[b][c]) / d
+		}
+	}
+	return
+}
@@ -0,0 +1,98 @@
+package traj
+
+import (
+	"math"
+	"testing"
+)
+
+// rotate returns the product of the rotation matrix m and v.
+func rotate(m [3][3]float64, v [3]float64) (p [3]float64) {
+	for i := range p {
+		p[i] = dot(m[i], v)
+	}
+	return
+}
+
+func TestSetCell(t *testing.T) {
+	// Restricted cell and positions in its frame
+	cell := [3][3]float64{{10, 0, 0}, {2, 9, 0}, {-1, 3, 8}}
+	pos := [][3]float64{{1, 2, 3}, {9, 8.5, 7.5}, {-0.5, 4, 12}}
+
+	// Rotation around an arbitrary axis
+	c, s := math.Cos(0.7), math.Sin(0.7)
+	u := [3]float64{1 / math.Sqrt(3), 1 / math.Sqrt(3), 1 / math.Sqrt(3)}
+	var rot [3][3]float64
+	for i := range rot {
+		for j := range rot[i] {
+			rot[i][j] = u[i] * u[j] * (1 - c)
+			if i == j {
+				rot[i][j] += c
+			}
+		}
+	}
+	rot[0][1] -= u[2] * s
+	rot[0][2] += u[1] * s
+	rot[1][0] += u[2] * s
+	rot[1][2] -= u[0] * s
+	rot[2][0] -= u[1] * s
+	rot[2][1] += u[0] * s
+
+	// Reflection through the xy plane
+	mirror := [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, -1}}
+
+	for _, tc := range []struct {
+		name string
+		m    [3][3]float64
+	}{
+		{"restricted", [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}},
+		{"rotated", rot},
+		{"left-handed", mirror},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := &Frame{Atoms: len(pos), Cols: make(map[string][]float64)}
+			for k, name := range [3]string{"x", "y", "z"} {
+				f.Cols[name] = make([]float64, len(pos))
+				f.Cols[Pos[k]] = f.Cols[name] // Shared with x y z
+				f.Cols[Image[k]] = make([]float64, len(pos))
+			}
+			for a, p := range pos {
+				p = rotate(tc.m, p)
+				for k := range p {
+					f.Cols[[3]string{"x", "y", "z"}[k]][a] = p[k]
+					f.Cols[Image[k]][a] = float64(a - 1)
+				}
+			}
+
+			err := f.SetCell(rotate(tc.m, cell[0]), rotate(tc.m, cell[1]), rotate(tc.m, cell[2]))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := f.Cell()
+			for i := range cell {
+				for j := range cell[i] {
+					if math.Abs(got[i][j]-cell[i][j]) > 1e-12 {
+						t.Fatalf("Cell() = %v, want %v", got, cell)
+					}
+				}
+			}
+
+			for a, p := range pos {
+				for k, name := range [3]string{"x", "y", "z"} {
+					if v := f.Cols[name][a]; math.Abs(v-p[k]) > 1e-12 {
+						t.Errorf("%s of atom %d = %g, want %g", name, a, v, p[k])
+					}
+					if v := f.Cols[Image[k]][a]; v != float64(a-1) {
+						t.Errorf("%s of atom %d = %g, want %d", Image[k], a, v, a-1)
+					}
+				}
+			}
+		})
+	}
+
+	f := &Frame{}
+	err := f.SetCell([3]float64{1, 1, 0}, [3]float64{2, 2, 0}, [3]float64{0, 0, 1})
+	if err == nil {
+		t.Errorf("SetCell accepted a cell of zero volume")
+	}
+}
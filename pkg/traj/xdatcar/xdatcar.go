@@ -0,0 +1,439 @@
+// Package xdatcar reads the XDATCAR trajectories of VASP.
+package xdatcar
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/kpotier/selfdiff/pkg/traj"
+)
+
+// cell is the header of the file, repeated before each frame if the cell
+// varies (e.g: NpT simulations).
+type cell struct {
+	lattice [3][3]float64 // Vectors a, b and c (scaled)
+	inv     [3][3]float64 // Inverse of the lattice before its scaling
+	names   []string      // Species (empty for VASP 4)
+	counts  []int         // Number of atoms of each species
+	atoms   int
+}
+
+// frame is the position of a frame in the file.
+type frame struct {
+	off  int64 // Position of the coordinates
+	line int   // Line of the coordinates
+	step int
+	cart bool // Cartesian coordinates instead of fractional ones
+	cell *cell
+}
+
+// Reader is a reader of VASP XDATCAR files. It implements the traj.Reader
+// interface. The position of each frame in the file is recorded the first time
+// the file is read up to this frame.
+//
+// The fractional coordinates are converted into the columns x y z with the
+// cell of each frame, rotated such as a is along x and b in the xy plane (see
+// traj.Frame.SetCell). VASP wraps the coordinates into the cell, so the
+// trajectory must be unwrapped (pbc). The columns type and element are the
+// index (from 1) and the name of the species of each atom. The step is the
+// number of the configuration.
+//
+// The malformed frames return a traj.ParseError. A frame whose header is
+// malformed or which is truncated ends the trajectory.
+type Reader struct {
+	name string
+	f    traj.File
+	r    *bufio.Reader
+
+	frames []frame       // Frames found so far
+	errs   map[int]error // Malformed frames found so far
+	cur    *cell         // Last header found
+	end    int64         // Position of the end of the last frame found
+	line   int           // Line of the end of the last frame found
+	eof    bool
+}
+
+// Open opens the XDATCAR file name and reads its header. The file can be
+// compressed with gzip (see traj.OpenFile).
+func Open(name string) (*Reader, error) {
+	f, err := traj.OpenFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reader{name: name, f: f, r: bufio.NewReader(f), line: 1}
+	err = r.next()
+	if err == nil {
+		err = r.errs[0]
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Species returns the names (empty for VASP 4) and the numbers of atoms of the
+// species of the first frame, in the order of the file.
+func (r *Reader) Species() ([]string, []int) {
+	if len(r.frames) == 0 {
+		return nil, nil
+	}
+	return r.frames[0].cell.names, r.frames[0].cell.counts
+}
+
+// Len is part of the traj.Reader interface.
+func (r *Reader) Len() (int, error) {
+	for !r.eof {
+		err := r.next()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(r.frames), nil
+}
+
+// Frame is part of the traj.Reader interface. The coordinates must be finite
+// numbers.
+func (r *Reader) Frame(i int) (*traj.Frame, error) {
+	for len(r.frames) <= i && !r.eof {
+		err := r.next()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if i < 0 || i >= len(r.frames) {
+		return nil, fmt.Errorf("frame %d: %w", i, io.EOF)
+	}
+
+	if err := r.errs[i]; err != nil {
+		return nil, err
+	}
+
+	fr := r.frames[i]
+	c := fr.cell
+	f := &traj.Frame{Step: fr.step, Atoms: c.atoms, Cols: make(map[string][]float64), Strs: make(map[string][]string)}
+
+	a, b, v := c.lattice[0], c.lattice[1], c.lattice[2]
+	la, lb, lv := norm(a), norm(b), norm(v)
+	err := f.SetLengths(la, lb, lv, dot(b, v)/(lb*lv), dot(a, v)/(la*lv), dot(a, b)/(la*lb))
+	if err != nil {
+		return nil, r.error(i, fr.line-1, "", err)
+	}
+
+	rot := f.Cell() // Cell in the frame of the trajectory
+
+	f.Names = []string{"type", "element", "x", "y", "z"}
+	typ, elem := make([]float64, c.atoms), make([]string, c.atoms)
+	f.Cols["type"], f.Strs["element"] = typ, elem
+
+	var xyz [3][]float64
+	for k, name := range [3]string{"x", "y", "z"} {
+		xyz[k] = make([]float64, c.atoms)
+		f.Cols[name] = xyz[k]
+	}
+
+	var at int
+	for s, n := range c.counts {
+		for k := 0; k < n; k++ {
+			typ[at] = float64(s + 1)
+			if len(c.names) > 0 {
+				elem[at] = c.names[s]
+			} else {
+				elem[at] = strconv.Itoa(s + 1)
+			}
+			at++
+		}
+	}
+
+	err = r.seek(fr.off)
+	if err != nil {
+		return nil, err
+	}
+
+	for a := 0; a < c.atoms; a++ {
+		line := fr.line + a
+		l, err := traj.ReadLine(r.r)
+		if err != nil && !(err == io.EOF && len(l) != 0) {
+			return nil, r.error(i, line, "", io.ErrUnexpectedEOF)
+		}
+
+		fields := strings.Fields(string(l))
+		if len(fields) < 3 {
+			return nil, r.error(i, line, "", fmt.Errorf("%d coordinates instead of 3", len(fields)))
+		}
+
+		var p [3]float64
+		for k := range p {
+			p[k], err = strconv.ParseFloat(fields[k], 64)
+			if err != nil || math.IsNaN(p[k]) || math.IsInf(p[k], 0) {
+				return nil, r.error(i, line, f.Names[2+k], fmt.Errorf("invalid value %q", fields[k]))
+			}
+		}
+
+		// The Cartesian coordinates are converted into fractional ones
+		if fr.cart {
+			p = mul(p, c.inv)
+		}
+
+		p = mul(p, rot)
+		for k := range p {
+			xyz[k][a] = p[k]
+		}
+	}
+
+	return f, nil
+}
+
+// Close is part of the traj.Reader interface.
+func (r *Reader) Close() error {
+	return r.f.Close()
+}
+
+// seek moves to the position off of the file.
+func (r *Reader) seek(off int64) error {
+	_, err := r.f.Seek(off, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	r.r.Reset(r.f)
+	return nil
+}
+
+// next finds the position of the frame following the last one found. A new
+// header is read if the frame doesn't start with its configuration line. A
+// malformed frame is recorded with its error and ends the trajectory.
+func (r *Reader) next() error {
+	err := r.seek(r.end)
+	if err != nil {
+		return err
+	}
+
+	i := len(r.frames)
+	line := r.line
+
+	l, n, err := r.readLine()
+	if err == io.EOF && strings.TrimSpace(l) == "" {
+		r.eof = true
+		return nil
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	if !config(l) {
+		c, m, h, err := r.header()
+		n += m
+		if err != nil {
+			return r.stop(r.error(i, line+h, "", err))
+		}
+		r.cur = c
+		line += h + 1
+
+		l, m, err = r.readLine()
+		n += m
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if !config(l) {
+			return r.stop(r.error(i, line, "", fmt.Errorf("unable to get the configuration")))
+		}
+	}
+	if r.cur == nil {
+		return r.stop(r.error(i, line, "", fmt.Errorf("unable to read the header")))
+	}
+
+	fr := frame{off: r.end + n, line: line + 1, step: i + 1, cell: r.cur}
+	fr.cart = strings.HasPrefix(strings.ToLower(strings.TrimSpace(l)), "c")
+	if i := strings.IndexByte(l, '='); i >= 0 {
+		if s, err := strconv.Atoi(strings.TrimSpace(l[i+1:])); err == nil {
+			fr.step = s
+		}
+	}
+
+	for a := 0; a < r.cur.atoms; a++ {
+		_, m, err := r.readLine()
+		if err != nil && !(err == io.EOF && m != 0) {
+			return r.stop(r.error(i, fr.line+a, "", io.ErrUnexpectedEOF))
+		}
+		n += m
+	}
+
+	r.frames = append(r.frames, fr)
+	r.end += n
+	r.line = fr.line + r.cur.atoms
+	return nil
+}
+
+// stop records the malformed frame following the last one found with its
+// error and ends the trajectory.
+func (r *Reader) stop(perr error) error {
+	if r.errs == nil {
+		r.errs = make(map[int]error)
+	}
+	r.errs[len(r.frames)] = perr
+	r.frames = append(r.frames, frame{})
+	r.eof = true
+	return nil
+}
+
+// error returns the traj.ParseError of the frame i at the line line of the file
+// about the column col.
+func (r *Reader) error(i, line int, col string, err error) error {
+	return &traj.ParseError{File: r.name, Frame: i, Line: line, Col: col, Err: err}
+}
+
+// readLine reads a line. It returns the line and the number of bytes read.
+func (r *Reader) readLine() (string, int64, error) {
+	l, err := r.r.ReadString('\n')
+	return l, int64(len(l)), err
+}
+
+// header reads the header following its first line (the comment): the scale,
+// the lattice, the species and the number of atoms of each species. It returns
+// the cell, the number of bytes read and the number of lines read. If it
+// fails, the number of lines is the one of the line of the error.
+func (r *Reader) header() (*cell, int64, int, error) {
+	var n int64
+	var lines [5]string
+	for k := 0; k < 5; k++ {
+		l, m, err := r.readLine()
+		if err != nil {
+			return nil, n, k + 1, io.ErrUnexpectedEOF
+		}
+		lines[k] = l
+		n += m
+	}
+
+	// A negative scale is the volume of the cell. There can be a scale for
+	// each direction
+	scale, err := floats(lines[0])
+	if err != nil || (len(scale) != 1 && len(scale) != 3) {
+		return nil, n, 1, fmt.Errorf("unable to get the scale")
+	}
+
+	c := &cell{}
+	var raw [3][3]float64
+	for k := 0; k < 3; k++ {
+		v, err := floats(lines[k+1])
+		if err != nil || len(v) != 3 {
+			return nil, n, k + 2, fmt.Errorf("unable to get the lattice")
+		}
+		copy(raw[k][:], v)
+	}
+
+	c.inv, err = inverse(raw)
+	if err != nil {
+		return nil, n, 2, err
+	}
+
+	s := [3]float64{scale[0], scale[0], scale[0]}
+	switch {
+	case len(scale) == 3:
+		copy(s[:], scale)
+	case scale[0] < 0:
+		v := math.Cbrt(-scale[0] / math.Abs(det(raw)))
+		s = [3]float64{v, v, v}
+	}
+	for k := range raw {
+		for j := range raw[k] {
+			c.lattice[k][j] = raw[k][j] * s[j]
+		}
+	}
+
+	// The names of the species are absent in VASP 4
+	counts, h := lines[4], 5
+	if _, err := strconv.Atoi(strings.Fields(counts + " x")[0]); err != nil {
+		c.names = strings.Fields(counts)
+		l, m, err := r.readLine()
+		if err != nil {
+			return nil, n, 6, io.ErrUnexpectedEOF
+		}
+		counts, h = l, 6
+		n += m
+	}
+
+	for _, f := range strings.Fields(counts) {
+		v, err := strconv.Atoi(f)
+		if err != nil || v < 0 {
+			return nil, n, h, fmt.Errorf("unable to get the number of atoms")
+		}
+		c.counts = append(c.counts, v)
+		c.atoms += v
+	}
+	if c.atoms == 0 || (c.names != nil && len(c.names) != len(c.counts)) {
+		return nil, n, h, fmt.Errorf("unable to get the number of atoms")
+	}
+
+	return c, n, h, nil
+}
+
+// config returns true if l is the line starting the coordinates of a frame
+// (e.g: Direct configuration=     1).
+func config(l string) bool {
+	l = strings.ToLower(strings.TrimSpace(l))
+	return strings.HasPrefix(l, "direct") || strings.HasPrefix(l, "cartesian") || strings.HasPrefix(l, "konfig")
+}
+
+// floats parses the fields of l.
+func floats(l string) ([]float64, error) {
+	var v []float64
+	for _, s := range strings.Fields(l) {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		v = append(v, f)
+	}
+	return v, nil
+}
+
+// norm returns the norm of the vector v.
+func norm(v [3]float64) float64 {
+	return math.Sqrt(dot(v, v))
+}
+
+// dot returns the dot product of u and v.
+func dot(u, v [3]float64) float64 {
+	return u[0]*v[0] + u[1]*v[1] + u[2]*v[2]
+}
+
+// mul returns the product of the row vector v and the matrix m.
+func mul(v [3]float64, m [3][3]float64) (p [3]float64) {
+	for j := 0; j < 3; j++ {
+		for k := 0; k < 3; k++ {
+			p[j] += v[k] * m[k][j]
+		}
+	}
+	return
+}
+
+// det returns the determinant of m.
+func det(m [3][3]float64) float64 {
+	return m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+}
+
+// inverse returns the inverse of m.
+func inverse(m [3][3]float64) (inv [3][3]float64, err error) {
+	d := det(m)
+	if d == 0 {
+		return inv, fmt.Errorf("invalid lattice")
+	}
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			a, b := (j+1)%3, (j+2)%3
+			c, e := (i+1)%3, (i+2)%3
+			inv[i][j] = (m[a][c]*m[b][e] - m[a][e]*m[b][c]) / d
+		}
+	}
+	return inv, nil
+}
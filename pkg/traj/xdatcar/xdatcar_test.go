@@ -0,0 +1,246 @@
+package xdatcar
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/kpotier/selfdiff/pkg/traj"
+)
+
+// testFrac returns the fractional coordinates of the atom a in the frame i.
+func testFrac(i, a int) [3]float64 {
+	return [3]float64{0.1 * float64(a), 0.05 + 0.1*float64(i), 0.9 - 0.15*float64(a)}
+}
+
+// testLattice returns the lattice (unscaled) of the frame i. The cell is
+// triclinic and isn't in the orientation of traj.Frame.
+func testLattice(i int) [3][3]float64 {
+	l := 1 + 0.02*float64(i)
+	return [3][3]float64{{4 * l, 0.5, 0.2}, {-1, 5 * l, 0.3}, {0.4, -0.6, 6 * l}}
+}
+
+// testXDATCAR describes an XDATCAR file written by write.
+type testXDATCAR struct {
+	scale    string // Scaling factor(s)
+	vasp4    bool   // No names of species
+	variable bool   // Header before each frame
+	cart     bool   // Cartesian coordinates
+}
+
+// testCounts are the numbers of atoms of each species.
+var testCounts = []int{2, 1}
+
+// write returns an XDATCAR file of n frames, the configuration i being the
+// step 10*(i+1).
+func (x testXDATCAR) write(n int) []byte {
+	var b bytes.Buffer
+	for i := 0; i < n; i++ {
+		if i == 0 || x.variable {
+			lat := testLattice(0)
+			if x.variable {
+				lat = testLattice(i)
+			}
+
+			fmt.Fprintf(&b, "Li2 O\n%s\n", x.scale)
+			for _, v := range lat {
+				fmt.Fprintf(&b, "  %.10f %.10f %.10f\n", v[0], v[1], v[2])
+			}
+			if !x.vasp4 {
+				b.WriteString("  Li O\n")
+			}
+			fmt.Fprintf(&b, "  %d %d\n", testCounts[0], testCounts[1])
+		}
+
+		kind := "Direct"
+		if x.cart {
+			kind = "Cartesian"
+		}
+		fmt.Fprintf(&b, "%s configuration= %5d\n", kind, 10*(i+1))
+
+		for a := 0; a < 3; a++ {
+			p := testFrac(i, a)
+			if x.cart {
+				lat := testLattice(0)
+				if x.variable {
+					lat = testLattice(i)
+				}
+				p = mul(p, lat)
+			}
+			fmt.Fprintf(&b, "  %.10f %.10f %.10f\n", p[0], p[1], p[2])
+		}
+	}
+	return b.Bytes()
+}
+
+func TestReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xdatcar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const n = 4
+	vol := det(testLattice(0))
+
+	for _, tc := range []struct {
+		name string
+		x    testXDATCAR
+		s    [3]float64 // Scale of each direction
+	}{
+		{"constant", testXDATCAR{scale: "1.0"}, [3]float64{1, 1, 1}},
+		{"variable", testXDATCAR{scale: "1.5", variable: true}, [3]float64{1.5, 1.5, 1.5}},
+		{"cartesian", testXDATCAR{scale: "1.5", cart: true}, [3]float64{1.5, 1.5, 1.5}},
+		{"variable cartesian", testXDATCAR{scale: "0.8", variable: true, cart: true}, [3]float64{0.8, 0.8, 0.8}},
+		{"volume", testXDATCAR{scale: fmt.Sprint(-8 * vol)}, [3]float64{2, 2, 2}},
+		{"three scales", testXDATCAR{scale: "1.0 2.0 0.5", cart: true}, [3]float64{1, 2, 0.5}},
+		{"vasp 4", testXDATCAR{scale: "1.0", vasp4: true}, [3]float64{1, 1, 1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			name := filepath.Join(dir, "XDATCAR")
+			err := ioutil.WriteFile(name, tc.x.write(n), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			names, counts := r.Species()
+			if want := []string{"Li", "O"}; !tc.x.vasp4 && !reflect.DeepEqual(names, want) {
+				t.Errorf("Species() = %v, want %v", names, want)
+			}
+			if !reflect.DeepEqual(counts, testCounts) {
+				t.Errorf("Species() = %v, want %v", counts, testCounts)
+			}
+
+			l, err := r.Len()
+			if err != nil || l != n {
+				t.Fatalf("Len() = %d, %v, want %d", l, err, n)
+			}
+
+			// Backward to find the frames already recorded
+			for i := n - 1; i >= 0; i-- {
+				f, err := r.Frame(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if f.Step != 10*(i+1) {
+					t.Errorf("frame %d: Step = %d, want %d", i, f.Step, 10*(i+1))
+				}
+
+				elems := []string{"Li", "Li", "O"}
+				if tc.x.vasp4 {
+					elems = []string{"1", "1", "2"}
+				}
+				if !reflect.DeepEqual(f.Col("type"), []float64{1, 1, 2}) || !reflect.DeepEqual(f.Strs["element"], elems) {
+					t.Errorf("frame %d: type %v and element %v, want [1 1 2] and %v", i, f.Col("type"), f.Strs["element"], elems)
+				}
+
+				// The cell has the lengths and the angles of the scaled
+				// lattice
+				lat := testLattice(0)
+				if tc.x.variable {
+					lat = testLattice(i)
+				}
+				for k := range lat {
+					for j := range lat[k] {
+						lat[k][j] *= tc.s[j]
+					}
+				}
+
+				cell := f.Cell()
+				for _, p := range [][2]int{{0, 0}, {1, 1}, {2, 2}, {0, 1}, {0, 2}, {1, 2}} {
+					got, want := dot(cell[p[0]], cell[p[1]]), dot(lat[p[0]], lat[p[1]])
+					if math.Abs(got-want) > 1e-8*math.Abs(want) {
+						t.Fatalf("frame %d: cell %v, want the rotation of %v", i, cell, lat)
+					}
+				}
+
+				for a := 0; a < 3; a++ {
+					want := mul(testFrac(i, a), cell)
+					for k, name := range [3]string{"x", "y", "z"} {
+						if v := f.Col(name)[a]; math.Abs(v-want[k]) > 1e-8 {
+							t.Errorf("frame %d: %s of atom %d = %g, want %g", i, name, a, v, want[k])
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xdatcar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	valid := testXDATCAR{scale: "1.0", variable: true}.write(3)
+	lines := bytes.SplitAfter(valid, []byte("\n"))
+
+	// Each frame has 11 lines, the last element of lines being empty
+	join := func(lines [][]byte) []byte { return bytes.Join(lines, nil) }
+	replace := func(k int, l string) []byte {
+		c := append([][]byte{}, lines...)
+		c[k] = []byte(l)
+		return join(c)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		data  []byte
+		n     int // Number of frames
+		frame int // Malformed frame, -1 if none
+		line  int
+	}{
+		{"coordinate", replace(19, "  0.1 nan 0.2\n"), 3, 1, 20},
+		{"lattice", replace(13, "  1 2\n"), 2, 1, 14},
+		{"truncated", join(lines[:len(lines)-2]), 3, 2, 33},
+		{"interrupted", join(lines[:22]), 2, -1, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			name := filepath.Join(dir, "XDATCAR")
+			err := ioutil.WriteFile(name, tc.data, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+
+			n, err := r.Len()
+			if err != nil || n != tc.n {
+				t.Fatalf("Len() = %d, %v, want %d", n, err, tc.n)
+			}
+
+			for i := 0; i < n; i++ {
+				_, err = r.Frame(i)
+				if i != tc.frame {
+					if err != nil {
+						t.Errorf("Frame(%d): %v", i, err)
+					}
+					continue
+				}
+
+				var perr *traj.ParseError
+				if !errors.As(err, &perr) || perr.Frame != i || perr.Line != tc.line {
+					t.Errorf("Frame(%d) = %v, want a traj.ParseError at line %d", i, err, tc.line)
+				}
+			}
+		})
+	}
+}
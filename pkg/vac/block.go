@@ -0,0 +1,121 @@
+package vac
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/kpotier/selfdiff/pkg/stat"
+	"github.com/kpotier/selfdiff/pkg/topo"
+)
+
+// Blocks is a structure containing the results of a block averaging. Each
+// block is a VAC performed on a contiguous part of the trajectory. The results
+// of the blocks are averaged for each species.
+type Blocks struct {
+	Blocks []*VAC
+
+	Out string
+	Dt  float64
+
+	Curves []*BlockCurve // One for each species
+}
+
+// BlockCurve is the block average of the velocity autocorrelation function of
+// one species. Res and Err are the mean and the standard error of the blocks
+// for each lag. Run is the mean running integral. D and DErr are the mean and
+// the standard error of the diffusion coefficients of the blocks.
+type BlockCurve struct {
+	topo.Species
+
+	Res    []float64
+	Err    []float64
+	ResDiv float64
+	Run    []float64
+
+	D    float64
+	DErr float64
+}
+
+// Perform performs the velocity autocorrelation function and the Green-Kubo
+// integral of each block and averages them. All the blocks must have the same
+// number of configurations.
+func (b *Blocks) Perform() error {
+	if len(b.Blocks) == 0 {
+		return fmt.Errorf("no block")
+	}
+
+	for i, m := range b.Blocks {
+		fmt.Println("> Block", i+1, "/", len(b.Blocks))
+
+		err := m.Perform()
+		if err != nil {
+			return fmt.Errorf("block %d: %w", i+1, err)
+		}
+		m.GreenKubo()
+	}
+
+	n := len(b.Blocks[0].Curves[0].Res)
+	x := make([]float64, len(b.Blocks))
+
+	b.Curves = nil
+	for s, sp := range b.Blocks[0].Species {
+		cur := &BlockCurve{Species: sp, Res: make([]float64, n), Err: make([]float64, n), Run: make([]float64, n+1)}
+
+		for i := 0; i < n; i++ {
+			for j, m := range b.Blocks {
+				if len(m.Curves[s].Res) != n {
+					return fmt.Errorf("blocks don't have the same length")
+				}
+				x[j] = m.Curves[s].Res[i]
+			}
+			cur.Res[i], cur.Err[i] = stat.MeanErr(x)
+		}
+
+		for i := 0; i <= n; i++ {
+			for j, m := range b.Blocks {
+				x[j] = m.Curves[s].Run[i]
+			}
+			cur.Run[i], _ = stat.MeanErr(x)
+		}
+
+		for j, m := range b.Blocks {
+			x[j] = m.Curves[s].ResDiv
+		}
+		cur.ResDiv, _ = stat.MeanErr(x)
+
+		for j, m := range b.Blocks {
+			x[j] = m.Curves[s].D
+		}
+		cur.D, cur.DErr = stat.MeanErr(x)
+
+		b.Curves = append(b.Curves, cur)
+	}
+
+	return nil
+}
+
+// Write writes the mean and the standard error of the blocks into Out. The
+// name of the species is inserted in Out if it isn't empty.
+func (b *Blocks) Write() error {
+	for s, cur := range b.Curves {
+		f, err := os.Create(cur.Out(b.Out))
+		if err != nil {
+			return err
+		}
+
+		for i, m := range b.Blocks {
+			fmt.Fprintln(f, "Block", i+1, m.Curves[s].D)
+		}
+		fmt.Fprintln(f, "D", cur.D, cur.DErr)
+		for i := range cur.Res {
+			fmt.Fprintln(f, float64(i+1)*b.Dt, cur.Res[i], cur.Err[i], cur.ResDiv, cur.Run[i+1])
+		}
+
+		err = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package vac
+
+import (
+	"fmt"
+
+	"github.com/kpotier/selfdiff/pkg/fft"
+)
+
+// performFFT performs the velocity autocorrelation function using the
+// Wiener-Khinchin theorem: the autocorrelation of each velocity component is
+// obtained from the power spectrum of the zero-padded signal in O(N log N).
+// The unnormalized results are identical to the direct method.
+func (m *VAC) performFFT() error {
+	// Velocity of the center of mass of each molecule. vel[mol][k][t]
+	vel := make([][3][]float64, m.MolTot)
+	for mol := range vel {
+		for k := 0; k < 3; k++ {
+			vel[mol][k] = make([]float64, m.Tot)
+		}
+	}
+
+	for i := 0; i < m.Tot; i++ {
+		fmt.Print("\r> Reading ", i+1, "/", m.Tot)
+
+		cfg, err := m.Method.GetCfg(i)
+		if err != nil {
+			return err
+		}
+
+		for mol := 0; mol < m.MolTot; mol++ {
+			for k := 0; k < 3; k++ {
+				vel[mol][k][i] = cfg[mol][k]
+			}
+		}
+	}
+
+	for mol := 0; mol < m.MolTot; mol++ {
+		fmt.Print("\r> Molecule ", mol+1, "/", m.MolTot)
+
+		cur := m.Curves[0]
+		for _, c := range m.Curves {
+			if mol >= c.First {
+				cur = c
+			}
+		}
+
+		for _, k := range m.Dims {
+			v := vel[mol][k]
+			ac := fft.Autocorr(v)
+
+			// The last configuration is never used as a time origin
+			cur.ResDiv += ac[0] - v[m.Tot-1]*v[m.Tot-1]
+			for lag := 1; lag < m.Tot; lag++ {
+				cur.Res[lag-1] += ac[lag]
+			}
+		}
+	}
+
+	fmt.Print("\033[2K\033[1G")
+	return nil
+}
@@ -0,0 +1,71 @@
+package vac
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/kpotier/selfdiff/pkg/topo"
+)
+
+// memory is a traj.Method whose configurations are in memory.
+type memory [][][3]float64
+
+func (m memory) Read() error                        { return nil }
+func (m memory) GetCfg(i int) ([][3]float64, error) { return m[i], nil }
+func (m memory) End() error                         { return nil }
+
+// random returns n configurations of mol molecules whose velocities are
+// correlated in time.
+func random(n, mol int) memory {
+	rng := rand.New(rand.NewSource(1))
+
+	m := make(memory, n)
+	for i := range m {
+		m[i] = make([][3]float64, mol)
+		for j := range m[i] {
+			for k := 0; k < 3; k++ {
+				m[i][j][k] = rng.NormFloat64()
+				if i > 0 {
+					m[i][j][k] += 0.8 * m[i-1][j][k]
+				}
+			}
+		}
+	}
+	return m
+}
+
+func TestFFT(t *testing.T) {
+	const n = 37 // Not a power of 2
+	species := []topo.Species{{Name: "a", Mol: 3, At: 1}, {Name: "b", Mol: 5, At: 1}}
+	cfgs := random(n, topo.MolTot(species))
+
+	for _, dims := range [][]int{nil, {0, 2}} {
+		var res [2]*VAC
+		for k, fft := range []bool{false, true} {
+			res[k] = &VAC{Method: cfgs, End: n, Mem: n, Species: species, Dims: dims, FFT: fft}
+			err := res[k].Perform()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for c, direct := range res[0].Curves {
+			fft := res[1].Curves[c]
+			if !equal(fft.ResDiv, direct.ResDiv) {
+				t.Errorf("dims %v, species %s: ResDiv = %g (FFT), want %g", dims, direct.Name, fft.ResDiv, direct.ResDiv)
+			}
+			for i := range direct.Res {
+				if !equal(fft.Res[i], direct.Res[i]) {
+					t.Errorf("dims %v, species %s: Res[%d] = %g (FFT), want %g", dims, direct.Name, i, fft.Res[i], direct.Res[i])
+				}
+			}
+		}
+	}
+}
+
+// equal returns true if a and b are equal up to the rounding errors of the
+// fast Fourier transform.
+func equal(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Abs(b))
+}
@@ -0,0 +1,29 @@
+package vac
+
+import (
+	"github.com/kpotier/selfdiff/pkg/quad"
+)
+
+// GreenKubo integrates the velocity autocorrelation function of each species
+// in physical time and determines its self diffusion coefficient from the
+// Green-Kubo relation D = (1/d)*int(<v(0).v(t)>), d being the number of
+// dimensions in Dims. Res and ResDiv being equal to 2/d of <v(0).v(t)>,
+// D = (1/2)*int(Res). Perform must be called beforehand.
+func (m *VAC) GreenKubo() {
+	cut := m.Cut
+	if cut == 0 {
+		cut = m.Tot - 1
+	}
+
+	for _, cur := range m.Curves {
+		y := make([]float64, m.Tot)
+		y[0] = cur.ResDiv / 2.
+		for i := 0; i < m.Tot-1; i++ {
+			y[i+1] = cur.Res[i] / 2.
+		}
+
+		cur.Run = quad.Running(y, m.Dt, m.Quad)
+		cur.D = cur.Run[cut]
+		cur.Int = float64(len(m.Dims)) * cur.D
+	}
+}
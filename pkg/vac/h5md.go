@@ -0,0 +1,57 @@
+package vac
+
+import (
+	"github.com/kpotier/selfdiff/pkg/hdf5"
+	"github.com/kpotier/selfdiff/pkg/stat"
+	"github.com/kpotier/selfdiff/pkg/traj/h5md"
+)
+
+// WriteH5MD adds the velocity autocorrelation function of each species to the
+// observables of the H5MD file w (see h5md.Create), in the group vacf of the
+// species. The datasets lag, value (ResDiv then Res) and integral (Run) start
+// from t = 0 and the attributes D and integral are the result of the
+// Green-Kubo integral. GreenKubo must be called beforehand.
+func (m *VAC) WriteH5MD(w *hdf5.Writer) {
+	for _, cur := range m.Curves {
+		g := h5md.Observables(w, cur.Name).Group("vacf")
+		curve(g, m.Dt, cur.ResDiv, cur.Res, cur.Run)
+		g.Attr("D", cur.D).Attr("integral", cur.Int)
+	}
+}
+
+// WriteH5MD adds the block average of the velocity autocorrelation function of
+// each species to the observables of the H5MD file w (see VAC.WriteH5MD). The
+// dataset error is the standard error of the blocks and the attribute D_blocks
+// the diffusion coefficient of each block.
+func (b *Blocks) WriteH5MD(w *hdf5.Writer) {
+	x := make([]float64, len(b.Blocks))
+	for s, cur := range b.Curves {
+		g := h5md.Observables(w, cur.Name).Group("vacf")
+		curve(g, b.Dt, cur.ResDiv, cur.Res, cur.Run)
+
+		for i, m := range b.Blocks {
+			x[i] = m.Curves[s].ResDiv
+		}
+		_, e := stat.MeanErr(x)
+		g.Dataset("error", []int{len(cur.Err) + 1}, append([]float64{e}, cur.Err...))
+
+		d := make([]float64, len(b.Blocks))
+		for i, m := range b.Blocks {
+			d[i] = m.Curves[s].D
+		}
+		g.Attr("D", cur.D).Attr("D_error", cur.DErr).Attr("D_blocks", d)
+	}
+}
+
+// curve adds the lags, the function (res0 at t = 0 then res) and its running
+// integral run to the group g.
+func curve(g *hdf5.Object, dt float64, res0 float64, res, run []float64) {
+	lag := make([]float64, len(res)+1)
+	for i := range lag {
+		lag[i] = float64(i) * dt
+	}
+
+	g.Dataset("lag", []int{len(lag)}, lag)
+	g.Dataset("value", []int{len(lag)}, append([]float64{res0}, res...))
+	g.Dataset("integral", []int{len(run)}, run)
+}
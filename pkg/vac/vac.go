@@ -3,20 +3,17 @@ package vac
 import (
 	"fmt"
 	"os"
-)
 
-// Method is an interface that will be used by the modules.
-type Method interface {
-	Read() error
-	GetCfg(int) ([][3]float64, error)
-	End() error
-}
+	"github.com/kpotier/selfdiff/pkg/quad"
+	"github.com/kpotier/selfdiff/pkg/topo"
+	"github.com/kpotier/selfdiff/pkg/traj"
+)
 
 // VAC structure is a structure containing information that will be used by the
 // modules. It contains the position of the first configuration, the position of
 // the last configuration, etc.
 type VAC struct {
-	Method Method
+	Method traj.Method
 
 	Traj string
 	Out  string
@@ -28,29 +25,92 @@ type VAC struct {
 	Tot    int
 	MemPos int // Position of the configurations that are in the memory
 	AtTot  int
+	MolTot int
+
+	// Species are the kinds of molecules in one configuration. A velocity
+	// autocorrelation function is calculated for each of them
+	Species []topo.Species
+	Dt      float64
+
+	// Dims are the dimensions (0 for x, 1 for y and 2 for z) included in the
+	// velocity autocorrelation function. Default is all of them
+	Dims []int
+
+	// FFT specifies if the fast Fourier transform algorithm must be used
+	// instead of the direct (quadratic) one
+	FFT bool
+
+	// Cut is the upper limit (in number of configurations) of the Green-Kubo
+	// integral. If it is equal to 0, the whole function is integrated
+	Cut int
+
+	// Quad is the quadrature rule used to perform the Green-Kubo integral
+	Quad quad.Rule
+
+	Curves []*Curve // One for each species
+}
 
-	At     int
-	Mol    int
-	Masses []float64
-	Dt     float64
+// Curve is the velocity autocorrelation function of one species. Res is the
+// function for each lag and ResDiv its value at t = 0.
+type Curve struct {
+	topo.Species
+
+	First int // Index of the first molecule of the species
 
 	Res    []float64
 	ResDiv float64
-	Int    float64
+
+	Run []float64 // Running integral (1/d)*int(<v(0).v(t)>) from t = 0
+	Int float64   // Integral of <v(0).v(t)> from t = 0 to t = Cut*Dt
+	D   float64
 }
 
-// Perform performs the mean squared displacement.
+// Perform performs the velocity autocorrelation function.
 func (m *VAC) Perform() (err error) {
 	m.Tot = m.End - m.Start
-	m.Res = make([]float64, m.Tot-1)
-	m.AtTot = m.At * m.Mol
+	m.AtTot = topo.AtTot(m.Species)
+	m.MolTot = topo.MolTot(m.Species)
 	m.MemPos = m.Tot - m.Mem
+	if len(m.Dims) == 0 {
+		m.Dims = []int{0, 1, 2}
+	}
+
+	m.Curves = nil
+	var first int
+	for _, sp := range m.Species {
+		m.Curves = append(m.Curves, &Curve{Species: sp, First: first, Res: make([]float64, m.Tot-1)})
+		first += sp.Mol
+	}
 
+	defer m.Method.End()
 	err = m.Method.Read()
 	if err != nil {
 		return
 	}
 
+	if m.FFT {
+		err = m.performFFT()
+	} else {
+		err = m.performDirect()
+	}
+	if err != nil {
+		return
+	}
+
+	d := len(m.Dims)
+	for _, cur := range m.Curves {
+		cur.ResDiv /= float64((m.Tot-1)*cur.Mol*d) / 2.
+		for i := 0; i < m.Tot-1; i++ {
+			cur.Res[i] /= float64((m.Tot-1-i)*cur.Mol*d) / 2.
+		}
+	}
+
+	return
+}
+
+// performDirect performs the velocity autocorrelation function by looping over
+// every pair of configurations. The results are not normalized.
+func (m *VAC) performDirect() (err error) {
 	for i := 0; i < m.Tot-1; i++ {
 		fmt.Print("\r> Step ", i+1, "/", m.Tot-1)
 
@@ -60,9 +120,11 @@ func (m *VAC) Perform() (err error) {
 			return
 		}
 
-		for mol := 0; mol < m.Mol; mol++ {
-			for k := 0; k < 3; k++ {
-				m.ResDiv += icfg[mol][k] * icfg[mol][k]
+		for _, cur := range m.Curves {
+			for mol := cur.First; mol < cur.First+cur.Mol; mol++ {
+				for _, k := range m.Dims {
+					cur.ResDiv += icfg[mol][k] * icfg[mol][k]
+				}
 			}
 		}
 
@@ -74,35 +136,39 @@ func (m *VAC) Perform() (err error) {
 				return
 			}
 
-			for mol := 0; mol < m.Mol; mol++ {
-				for k := 0; k < 3; k++ {
-					m.Res[j-i-1] += icfg[mol][k] * tcfg[mol][k]
+			for _, cur := range m.Curves {
+				for mol := cur.First; mol < cur.First+cur.Mol; mol++ {
+					for _, k := range m.Dims {
+						cur.Res[j-i-1] += icfg[mol][k] * tcfg[mol][k]
+					}
 				}
 			}
 		}
 	}
 
 	fmt.Print("\033[2K\033[1G")
-
-	m.ResDiv /= float64((m.Tot-1)*m.Mol*3) / 2.
-	for i := 0; i < m.Tot-1; i++ {
-		m.Res[i] /= float64((m.Tot-1-i)*m.Mol*3) / 2.
-		m.Int += m.Res[i]
-	}
-
 	return
 }
 
-// Write writes the results into Out.
+// Write writes the results into Out. The name of the species is inserted in
+// Out if it isn't empty (see topo.Species.Out).
 func (m *VAC) Write() error {
-	f, err := os.Create(m.Out)
-	if err != nil {
-		return err
-	}
+	for _, cur := range m.Curves {
+		f, err := os.Create(cur.Out(m.Out))
+		if err != nil {
+			return err
+		}
 
-	fmt.Fprintln(f, "Integral", m.Int)
-	for i := 0; i < m.Tot-1; i++ {
-		fmt.Fprintln(f, float64(i+1)*m.Dt, m.Res[i], m.ResDiv)
+		fmt.Fprintln(f, "Integral", cur.Int)
+		fmt.Fprintln(f, "D", cur.D)
+		for i := 0; i < m.Tot-1; i++ {
+			fmt.Fprintln(f, float64(i+1)*m.Dt, cur.Res[i], cur.ResDiv, cur.Run[i+1])
+		}
+
+		err = f.Close()
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil